@@ -0,0 +1,83 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/model"
+	"github.com/leslie-wang/clusterd/common/util"
+	"github.com/leslie-wang/clusterd/handler/manager"
+	"github.com/leslie-wang/clusterd/types"
+)
+
+// VerifyCallback checks the callback request sent by manager is signed by the key with the sign method, and not
+// expired. body is the content read from the request.
+func VerifyCallback(r *http.Request, body []byte, key, method string) error {
+	return util.VerifyCallbackSign(method, key, body, r.Header.Get(util.CallbackSignatureHeader), time.Now())
+}
+
+// ListCallbackRecords lists callbacks sent by manager. filters are query params of DescribeCallbackRecordsList,
+// e.g. StreamName, Status or CallbackId.
+func (c *Client) ListCallbackRecords(filters map[string]string) (*model.DescribeCallbackRecordsListResponseParams, error) {
+	u := c.makeURL(types.URLRecord)
+	query := map[string]string{
+		manager.Action: manager.ActionDescribeCallbackRecordsList,
+	}
+	for k, v := range filters {
+		query[k] = v
+	}
+
+	u = c.addQuery(u, query)
+
+	req, err := http.NewRequest(http.MethodPost, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, util.MakeStatusError(resp.Body)
+	}
+
+	records := model.DescribeCallbackRecordsListResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&records)
+	if err != nil {
+		return nil, err
+	}
+	return records.Response, nil
+}
+
+// ReplayCallbackRecord sends the callback again
+func (c *Client) ReplayCallbackRecord(id string) error {
+	u := c.makeURL(types.URLRecord)
+	query := map[string]string{
+		manager.Action:     manager.ActionReplayCallbackRecord,
+		manager.CallbackID: id,
+	}
+
+	u = c.addQuery(u, query)
+
+	req, err := http.NewRequest(http.MethodPost, u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return util.MakeStatusError(resp.Body)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package manager
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
@@ -33,8 +34,21 @@ func (c *Client) ListJobs() ([]types.Job, error) {
 	return jobs, json.NewDecoder(resp.Body).Decode(&jobs)
 }
 
-func (c *Client) DownloadLogFromManager(jobID int) (io.ReadCloser, error) {
+// DownloadLogFromManager reads given stream's log of the job. Only last lines are returned if tail
+// is positive, and new content is streamed until the job is finished if follow is set.
+func (c *Client) DownloadLogFromManager(jobID int, stream string, tail int, follow bool) (io.ReadCloser, error) {
 	url := c.makeURL(types.URLJobLog, strconv.Itoa(jobID))
+	query := map[string]string{
+		types.LogFollow: strconv.FormatBool(follow),
+	}
+	if stream != "" {
+		query[types.LogStream] = stream
+	}
+	if tail > 0 {
+		query[types.LogTail] = strconv.Itoa(tail)
+	}
+	url = c.addQuery(url, query)
+
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
@@ -46,11 +60,64 @@ func (c *Client) DownloadLogFromManager(jobID int) (io.ReadCloser, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
 		return nil, util.MakeStatusError(resp.Body)
 	}
 	return resp.Body, nil
 }
 
+// UploadJobLog uploads the content of job's log stream starting at offset
+func (c *Client) UploadJobLog(jobID int, stream string, offset int64, content []byte) error {
+	url := c.makeURL(types.URLJobLog, strconv.Itoa(jobID))
+	url = c.addQuery(url, map[string]string{
+		types.LogStream: stream,
+		types.LogOffset: strconv.FormatInt(offset, 10),
+	})
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(content))
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return util.MakeStatusError(resp.Body)
+	}
+	return nil
+}
+
+// JobLogSize returns the size of job's log stream uploaded to manager
+func (c *Client) JobLogSize(jobID int, stream string) (int64, error) {
+	url := c.makeURL(types.URLJobLog, strconv.Itoa(jobID))
+	url = c.addQuery(url, map[string]string{
+		types.LogStream: stream,
+	})
+
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// HEAD response has no body to carry the error
+		return 0, fmt.Errorf("get job %d %s log size: %s", jobID, stream, resp.Status)
+	}
+	return resp.ContentLength, nil
+}
+
 func (c *Client) GetJob(jobID int) (*types.Job, error) {
 	url := c.makeURL(types.URLJob, strconv.Itoa(jobID))
 	req, err := http.NewRequest(http.MethodGet, url, nil)
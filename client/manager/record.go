@@ -14,7 +14,7 @@ import (
 	"github.com/leslie-wang/clusterd/types"
 )
 
-func (c *Client) CreateRecordTask(domain, app, stream, url string, start, end *uint64) (*string, error) {
+func (c *Client) CreateRecordTask(domain, app, stream, url string, start, end *uint64, priority int) (*string, error) {
 	task := &types.LiveRecordTask{}
 	task.CreateRecordTaskRequestParams = &model.CreateRecordTaskRequestParams{
 		DomainName:    &domain,
@@ -23,6 +23,7 @@ func (c *Client) CreateRecordTask(domain, app, stream, url string, start, end *u
 		StartTime:     start,
 		RecordStreams: []model.RecordInputStream{{SourceURL: url}},
 		EndTime:       end,
+		Priority:      priority,
 	}
 	createRecordURL := c.makeURL(types.URLRecord)
 	query := map[string]string{
@@ -88,6 +89,87 @@ func (c *Client) CancelRecordTask(id string) error {
 	return nil
 }
 
+func (c *Client) StopRecordTask(id string) error {
+	stopRecordURL := c.makeURL(types.URLRecord)
+	query := map[string]string{
+		manager.Action: manager.ActionStopRecordTask,
+		manager.TaskID: id,
+	}
+
+	stopRecordURL = c.addQuery(stopRecordURL, query)
+
+	req, err := http.NewRequest(http.MethodPost, stopRecordURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return util.MakeStatusError(resp.Body)
+	}
+	return nil
+}
+
+func (c *Client) PauseRecordTask(id string) error {
+	pauseRecordURL := c.makeURL(types.URLRecord)
+	query := map[string]string{
+		manager.Action: manager.ActionPauseRecordTask,
+		manager.TaskID: id,
+	}
+
+	pauseRecordURL = c.addQuery(pauseRecordURL, query)
+
+	req, err := http.NewRequest(http.MethodPost, pauseRecordURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return util.MakeStatusError(resp.Body)
+	}
+	return nil
+}
+
+func (c *Client) ResumeRecordTask(id string) error {
+	resumeRecordURL := c.makeURL(types.URLRecord)
+	query := map[string]string{
+		manager.Action: manager.ActionResumeRecordTask,
+		manager.TaskID: id,
+	}
+
+	resumeRecordURL = c.addQuery(resumeRecordURL, query)
+
+	req, err := http.NewRequest(http.MethodPost, resumeRecordURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return util.MakeStatusError(resp.Body)
+	}
+	return nil
+}
+
 func (c *Client) ListLiveCallbackTemplates() ([]*model.CallBackTemplateInfo, error) {
 	u := c.makeURL(types.URLRecord)
 	query := map[string]string{
@@ -157,6 +239,38 @@ func (c *Client) CreateLiveCallbackTemplate(template *model.CallBackTemplateInfo
 	return *tresp.Response.TemplateId, nil
 }
 
+// ModifyLiveCallbackTemplate changes the given fields of the callback template
+func (c *Client) ModifyLiveCallbackTemplate(template *model.ModifyLiveCallbackTemplateRequestParams) error {
+	u := c.makeURL(types.URLRecord)
+	query := map[string]string{
+		manager.Action: manager.ActionModifyLiveCallbackTemplate,
+	}
+
+	u = c.addQuery(u, query)
+
+	buf := &bytes.Buffer{}
+	err := json.NewEncoder(buf).Encode(template)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, u, buf)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return util.MakeStatusError(resp.Body)
+	}
+	return nil
+}
+
 func (c *Client) ListLiveCallbackRules() ([]*model.CallBackRuleInfo, error) {
 	u := c.makeURL(types.URLRecord)
 	query := map[string]string{
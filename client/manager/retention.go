@@ -0,0 +1,37 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/leslie-wang/clusterd/common/util"
+	"github.com/leslie-wang/clusterd/types"
+)
+
+// ListRetentions lists recorded files which expire next, up to limit. The manager's default is used if limit
+// is 0.
+func (c *Client) ListRetentions(limit int) ([]types.RecordRetention, error) {
+	url := c.makeURL(types.URLRetention)
+	if limit > 0 {
+		url = c.addQuery(url, map[string]string{types.RetentionLimit: strconv.Itoa(limit)})
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, util.MakeStatusError(resp.Body)
+	}
+
+	rs := []types.RecordRetention{}
+	return rs, json.NewDecoder(resp.Body).Decode(&rs)
+}
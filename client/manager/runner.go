@@ -1,6 +1,7 @@
 package manager
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -10,9 +11,13 @@ import (
 	"github.com/leslie-wang/clusterd/types"
 )
 
-func (c *Client) AcquireJob(name string) (*types.Job, error) {
+func (c *Client) AcquireJob(name string, hb *types.JobHeartbeat) (*types.Job, error) {
+	content, err := json.Marshal(hb)
+	if err != nil {
+		return nil, err
+	}
 	url := c.makeURL(types.URLJobRunner, name)
-	req, err := http.NewRequest(http.MethodPost, url, nil)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(content))
 	if err != nil {
 		return nil, err
 	}
@@ -28,7 +33,7 @@ func (c *Client) AcquireJob(name string) (*types.Job, error) {
 		return nil, util.MakeStatusError(resp.Body)
 	}
 
-	content, err := io.ReadAll(resp.Body)
+	content, err = io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
@@ -41,7 +46,33 @@ func (c *Client) AcquireJob(name string) (*types.Job, error) {
 	return job, json.Unmarshal(content, job)
 }
 
-func (c *Client) ListRunners() (map[string]types.Job, error) {
+func (c *Client) Heartbeat(name string, hb *types.JobHeartbeat) (*types.JobHeartbeatResponse, error) {
+	content, err := json.Marshal(hb)
+	if err != nil {
+		return nil, err
+	}
+	url := c.makeURL(types.URLJobHeartbeat, name)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(content))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, util.MakeStatusError(resp.Body)
+	}
+
+	hbResp := &types.JobHeartbeatResponse{}
+	return hbResp, json.NewDecoder(resp.Body).Decode(hbResp)
+}
+
+func (c *Client) ListRunners() ([]types.Runner, error) {
 	url := c.makeURL(types.URLRunner)
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
@@ -59,27 +90,60 @@ func (c *Client) ListRunners() (map[string]types.Job, error) {
 		return nil, util.MakeStatusError(resp.Body)
 	}
 
-	runners := map[string]types.Job{}
+	runners := []types.Runner{}
 	return runners, json.NewDecoder(resp.Body).Decode(&runners)
 }
 
-func (c *Client) DownloadLogFromRunner(jobID int, writer io.Writer) error {
-	url := c.makeURL(types.MkIDURLByBase(types.URLRunnerLogJob), strconv.Itoa(jobID))
+func (c *Client) GetRunner(name string) (*types.Runner, error) {
+	url := c.makeURL(types.URLRunner, name)
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	resp, err := c.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return util.MakeStatusError(resp.Body)
+		return nil, util.MakeStatusError(resp.Body)
+	}
+
+	runner := &types.Runner{}
+	return runner, json.NewDecoder(resp.Body).Decode(runner)
+}
+
+// DownloadLogFromRunner reads given stream's log of the job directly from the runner, starting
+// at offset. New content is streamed until the job is finished if follow is set.
+func (c *Client) DownloadLogFromRunner(jobID int, stream string, offset int64, follow bool) (io.ReadCloser, error) {
+	url := c.makeURL(types.URLRunnerLogJob, strconv.Itoa(jobID))
+	query := map[string]string{
+		types.LogFollow: strconv.FormatBool(follow),
+	}
+	if stream != "" {
+		query[types.LogStream] = stream
+	}
+	if offset > 0 {
+		query[types.LogOffset] = strconv.FormatInt(offset, 10)
+	}
+	url = c.addQuery(url, query)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, util.MakeStatusError(resp.Body)
 	}
-	_, err = io.Copy(writer, resp.Body)
-	return err
+	return resp.Body, nil
 }
@@ -13,7 +13,10 @@ import (
 	"time"
 
 	"github.com/leslie-wang/clusterd/common/db"
+	"github.com/leslie-wang/clusterd/common/db/migrate"
 	"github.com/leslie-wang/clusterd/common/release"
+	"github.com/leslie-wang/clusterd/common/storage"
+	"github.com/leslie-wang/clusterd/common/util"
 	"github.com/leslie-wang/clusterd/handler/manager"
 	"github.com/leslie-wang/clusterd/types"
 	"github.com/urfave/cli"
@@ -38,22 +41,22 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:  "db-host",
-			Usage: "mysql address or sqlite db file",
+			Usage: "mysql address, postgres address with optional ?<params>, or sqlite db file",
 			Value: db.Sqlite + `://` + types.ClusterDBName + ".db",
 		},
 		cli.StringFlag{
 			Name:  "db-user",
-			Usage: "mysql username",
+			Usage: "mysql or postgres username",
 			Value: "root",
 		},
 		cli.StringFlag{
 			Name:  "db-pass",
-			Usage: "mysql password",
+			Usage: "mysql or postgres password",
 			Value: "rootroot",
 		},
 		cli.StringFlag{
 			Name:  "db-name",
-			Usage: "mysql database name",
+			Usage: "mysql or postgres database name",
 			Value: types.ClusterDBName,
 		},
 		cli.StringFlag{
@@ -61,20 +64,103 @@ func main() {
 			Usage: "mysql dsn to use",
 			Value: "dd",
 		},
+		cli.BoolFlag{
+			Name:  "skip-migrate",
+			Usage: "do not apply pending DB migrations at startup",
+		},
 		cli.DurationFlag{
 			Name:  "schedule-interval, i",
 			Usage: "interval for runner to get job",
 			Value: 10 * time.Second,
 		},
+		cli.DurationFlag{
+			Name:  "job-lease-timeout",
+			Usage: "duration without runner heartbeat before its running job is requeued or failed",
+			Value: time.Minute,
+		},
+		cli.IntFlag{
+			Name:  "max-job-requeue",
+			Usage: "maximum times of requeuing one job after its runner is lost",
+			Value: 3,
+		},
 		cli.StringFlag{
 			Name:  "notify-url",
 			Usage: "url to notify record status",
 		},
+		cli.StringFlag{
+			Name:  "callback-key",
+			Usage: "key to sign callbacks to notify url, callback template's key is used if there is one",
+		},
+		cli.StringFlag{
+			Name:  "callback-sign-method",
+			Usage: "method to sign callbacks, md5 or hmac-sha256",
+			Value: util.SignMethodMD5,
+		},
+		cli.StringFlag{
+			Name:  "stream-source-url",
+			Usage: "url to pull the stream which is recorded by record rule, with {domain}, {app} and {stream} replaced",
+			Value: manager.DefaultStreamSourceURL,
+		},
+		cli.DurationFlag{
+			Name:  "auto-record-max-duration",
+			Usage: "maximum duration of recording started by record rule, if the stream stop event is missed",
+			Value: 24 * time.Hour,
+		},
+		cli.IntFlag{
+			Name:  "callback-workers",
+			Usage: "number of workers delivering callbacks",
+			Value: 4,
+		},
 		cli.StringFlag{
 			Name:  "media-dir, md",
 			Usage: "directory to store all recorded videos",
 			Value: "/mnt/media",
 		},
+		cli.StringFlag{
+			Name:  "storage",
+			Usage: "store of recorded videos, local or s3. local store is in media dir",
+		},
+		cli.StringFlag{
+			Name:  "s3-endpoint",
+			Usage: "url of S3 compatible service, e.g. http://minio:9000",
+		},
+		cli.StringFlag{
+			Name:  "s3-region",
+			Usage: "region of S3 bucket",
+			Value: "us-east-1",
+		},
+		cli.StringFlag{
+			Name:  "s3-bucket",
+			Usage: "S3 bucket to store recorded videos",
+		},
+		cli.StringFlag{
+			Name:  "s3-prefix",
+			Usage: "prefix of all recorded videos in S3 bucket",
+		},
+		cli.StringFlag{
+			Name:   "s3-access-key",
+			Usage:  "access key of S3 bucket",
+			EnvVar: "CD_S3_ACCESS_KEY",
+		},
+		cli.StringFlag{
+			Name:   "s3-secret-key",
+			Usage:  "secret key of S3 bucket",
+			EnvVar: "CD_S3_SECRET_KEY",
+		},
+		cli.DurationFlag{
+			Name:  "retention-interval",
+			Usage: "interval to remove recorded videos kept for their storage time, 0 disables it",
+			Value: 10 * time.Minute,
+		},
+		cli.BoolFlag{
+			Name:  "retention-callback",
+			Usage: "notify record_file_expired when recorded videos are removed by retention",
+		},
+		cli.StringSliceFlag{
+			Name: "domain-quota",
+			Usage: "storage quota of recorded videos of the domain, like test.play.com=1024 in megabytes." +
+				" domain * is for domains without their own quota",
+		},
 		cli.StringFlag{
 			Name:  "log-dir, ld",
 			Usage: "directory to store all logs",
@@ -92,38 +178,126 @@ func main() {
 		},
 	}
 
+	app.Commands = []cli.Command{
+		{
+			Name:   "migrate",
+			Usage:  "apply pending DB migrations",
+			Action: migrateDB,
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "baseline",
+					Usage: "record the DB as being at given version without running migrations, for DB created by hand",
+					Value: migrate.NoVersion,
+				},
+			},
+		},
+	}
+
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func serve(ctx *cli.Context) error {
-	parts := strings.Split(ctx.String("db-host"), `://`)
+// parseDBHost returns the driver and address in db-host flag
+func parseDBHost(ctx *cli.Context) (string, string, error) {
+	parts := strings.Split(ctx.GlobalString("db-host"), `://`)
 	if len(parts) != 2 {
-		return errors.New("db-host must be sqlite://<sqlite filename> or mysql://<mysql address>")
+		return "", "", errors.New("db-host must be sqlite://<sqlite filename>, mysql://<mysql address> " +
+			"or postgres://<postgres address>")
+	}
+	switch parts[0] {
+	case db.MySQL, db.Postgres:
+		return parts[0], parts[1], nil
+	}
+	return db.Sqlite, parts[1], nil
+}
+
+func migrateDB(ctx *cli.Context) error {
+	driver, addr, err := parseDBHost(ctx)
+	if err != nil {
+		return err
+	}
+
+	d, err := db.OpenDB(types.Config{
+		Driver: driver,
+		DBUser: ctx.GlobalString("db-user"),
+		DBPass: ctx.GlobalString("db-pass"),
+		DBName: ctx.GlobalString("db-name"),
+		Addr:   addr,
+	})
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if ctx.Int("baseline") != migrate.NoVersion {
+		err = migrate.Baseline(d, driver, ctx.Int("baseline"))
+		if err != nil {
+			return err
+		}
+	}
+
+	applied, err := migrate.Up(d, driver)
+	if err != nil {
+		return err
+	}
+	current, err := migrate.Current(d)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("applied migrations %v, DB is at version %d\n", applied, current)
+	return nil
+}
+
+func serve(ctx *cli.Context) error {
+	driver, addr, err := parseDBHost(ctx)
+	if err != nil {
+		return err
 	}
 
 	host := fmt.Sprintf(":%d", ctx.Uint("port"))
 
+	quotas, err := manager.ParseDomainQuotas(ctx.StringSlice("domain-quota"))
+	if err != nil {
+		return err
+	}
+
 	cfg := manager.Config{
-		DBAddress:        ctx.String("db-host"),
-		DBUser:           ctx.GlobalString("db-user"),
-		DBPass:           ctx.String("db-pass"),
-		DBName:           ctx.String("db-name"),
-		ScheduleInterval: ctx.Duration("schedule-interval"),
-		NotifyURL:        ctx.String("notify-url"),
-		BaseURL:          fmt.Sprintf("http://%s%s", ctx.String("ip"), host),
-		MediaDir:         ctx.String("media-dir"),
-		LogDir:           ctx.String("log-dir"),
-		MaxLogSize:       ctx.Int("max-log-size"),
-		MaxLogBackup:     ctx.Int("max-log-backups"),
-	}
-	if parts[0] == db.MySQL {
-		cfg.Driver = db.MySQL
-	} else {
-		cfg.Driver = db.Sqlite
-	}
-	cfg.DBAddress = parts[1]
+		DBAddress:         ctx.String("db-host"),
+		DBUser:            ctx.GlobalString("db-user"),
+		DBPass:            ctx.String("db-pass"),
+		DBName:            ctx.String("db-name"),
+		SkipMigrate:       ctx.Bool("skip-migrate"),
+		ScheduleInterval:  ctx.Duration("schedule-interval"),
+		JobLeaseTimeout:   ctx.Duration("job-lease-timeout"),
+		MaxJobRequeue:     ctx.Int("max-job-requeue"),
+		NotifyURL:         ctx.String("notify-url"),
+		CallbackKey:       ctx.String("callback-key"),
+		SignMethod:        ctx.String("callback-sign-method"),
+		StreamSourceURL:   ctx.String("stream-source-url"),
+		AutoRecordMaxDur:  ctx.Duration("auto-record-max-duration"),
+		CallbackWorkers:   ctx.Int("callback-workers"),
+		BaseURL:           fmt.Sprintf("http://%s%s", ctx.String("ip"), host),
+		MediaDir:          ctx.String("media-dir"),
+		RetentionInterval: ctx.Duration("retention-interval"),
+		RetentionCallback: ctx.Bool("retention-callback"),
+		DomainQuotas:      quotas,
+		LogDir:            ctx.String("log-dir"),
+		MaxLogSize:        ctx.Int("max-log-size"),
+		MaxLogBackup:      ctx.Int("max-log-backups"),
+		Storage: storage.Config{
+			Type:      ctx.String("storage"),
+			Dir:       ctx.String("media-dir"),
+			Endpoint:  ctx.String("s3-endpoint"),
+			Region:    ctx.String("s3-region"),
+			Bucket:    ctx.String("s3-bucket"),
+			Prefix:    ctx.String("s3-prefix"),
+			AccessKey: ctx.String("s3-access-key"),
+			SecretKey: ctx.String("s3-secret-key"),
+		},
+	}
+	cfg.Driver = driver
+	cfg.DBAddress = addr
 
 	installSignalHandler()
 
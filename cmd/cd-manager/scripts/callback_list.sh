@@ -0,0 +1,4 @@
+#!/bin/bash
+
+# optional filters, e.g. "Status=2" lists failed callbacks
+curl -s -X POST "http://localhost:8088/mediaproc/v1/record?Action=DescribeCallbackRecordsList&$1"
@@ -0,0 +1,4 @@
+#!/bin/bash
+
+# only given fields are changed
+curl -s -X POST -H 'content-type: application/json' -d "{\"TemplateId\": $1, \"RecordStatusNotifyUrl\": \"http://localhost:8090/record-status\"}" 'http://localhost:8088/mediaproc/v1/record?Action=ModifyLiveCallbackTemplate'
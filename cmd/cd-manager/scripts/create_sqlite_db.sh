@@ -1,2 +1 @@
-sqlite3 ../clusterd.db < ../../../migrations/sqlite/0.sql
-
+cd-manager --db-host sqlite://../clusterd.db migrate
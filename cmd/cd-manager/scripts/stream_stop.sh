@@ -0,0 +1,3 @@
+#!/bin/bash
+
+curl -s -X POST http://localhost:8088/mediaproc/v1/stream -d "{\"event_type\": 0, \"app\": \"test.play.com\", \"appname\": \"live\", \"stream_id\": \"$1\"}"
@@ -0,0 +1,3 @@
+#!/bin/bash
+
+curl -s -X POST "http://localhost:8088/mediaproc/v1/record?Action=PauseRecordTask&TaskId=$1"
@@ -0,0 +1,4 @@
+#!/bin/bash
+
+# only given fields are changed
+curl -s -X POST "http://localhost:8088/mediaproc/v1/record?Action=ModifyLiveRecordTemplate&TemplateId=$1&Description=modified&Mp4Param.Enable=1&Mp4Param.RecordInterval=600"
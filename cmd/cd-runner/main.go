@@ -9,10 +9,12 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/leslie-wang/clusterd/common/release"
+	"github.com/leslie-wang/clusterd/common/storage"
 	"github.com/leslie-wang/clusterd/handler/runner"
 	"github.com/leslie-wang/clusterd/types"
 	"github.com/urfave/cli"
@@ -28,6 +30,13 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if err := newApp(name, wd).Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newApp returns the app of runner, whose flags default to the host name and working directory
+func newApp(name, wd string) *cli.App {
 	app := cli.NewApp()
 	app.Usage = "FFMPEG cluster runner"
 	app.Version = release.Version
@@ -54,21 +63,81 @@ func main() {
 			Usage: "local directory for recorded video",
 			Value: filepath.Join(wd, "runner"),
 		},
+		cli.Uint64Flag{
+			Name:  "min-free-space",
+			Usage: "minimum free space in megabytes of media dir to acquire jobs, 0 disables the check",
+			Value: 1024,
+		},
+		cli.StringFlag{
+			Name:  "storage",
+			Usage: "store to upload recorded videos, local or s3. videos are kept in media dir if it is empty",
+		},
+		cli.StringFlag{
+			Name:  "storage-dir",
+			Usage: "directory of local store to upload recorded videos, e.g. mount of the manager's media dir",
+		},
+		cli.StringFlag{
+			Name:  "s3-endpoint",
+			Usage: "url of S3 compatible service, e.g. http://minio:9000",
+		},
+		cli.StringFlag{
+			Name:  "s3-region",
+			Usage: "region of S3 bucket",
+			Value: "us-east-1",
+		},
+		cli.StringFlag{
+			Name:  "s3-bucket",
+			Usage: "S3 bucket to store recorded videos",
+		},
+		cli.StringFlag{
+			Name:  "s3-prefix",
+			Usage: "prefix of all recorded videos in S3 bucket",
+		},
+		cli.StringFlag{
+			Name:   "s3-access-key",
+			Usage:  "access key of S3 bucket",
+			EnvVar: "CD_S3_ACCESS_KEY",
+		},
+		cli.StringFlag{
+			Name:   "s3-secret-key",
+			Usage:  "secret key of S3 bucket",
+			EnvVar: "CD_S3_SECRET_KEY",
+		},
 		cli.StringFlag{
 			Name:  "name, n",
 			Usage: "current runner's name",
 			Value: name,
 		},
+		cli.StringFlag{
+			Name:  "advertise-host",
+			Usage: "host which manager and utilities use to reach current runner",
+			Value: name,
+		},
 		cli.DurationFlag{
 			Name:  "interval, i",
 			Usage: "interval to fetch next job",
 			Value: time.Second,
 		},
+		cli.IntFlag{
+			Name:  "max-jobs",
+			Usage: "maximum number of jobs running at the same time",
+			Value: 1,
+		},
+		cli.DurationFlag{
+			Name:  "heartbeat-interval",
+			Usage: "interval to renew lease of running jobs",
+			Value: 10 * time.Second,
+		},
 		cli.StringFlag{
 			Name:  "log-dir, ld",
 			Usage: "directory to store all logs",
 			Value: "/var/log/clusterd",
 		},
+		cli.DurationFlag{
+			Name:  "job-log-retention",
+			Usage: "how long to keep logs of finished jobs",
+			Value: 7 * 24 * time.Hour,
+		},
 		cli.IntFlag{
 			Name:  "max-log-size",
 			Usage: "maximum size in megabytes of the log file before it get rotated",
@@ -80,25 +149,42 @@ func main() {
 			Value: 25,
 		},
 	}
+	return app
+}
 
-	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+// mkConfig returns configuration of the handler by flags
+func mkConfig(ctx *cli.Context) runner.Config {
+	return runner.Config{
+		MgrHost:           ctx.GlobalString("mgr-host"),
+		MgrPort:           ctx.GlobalUint("mgr-port"),
+		Interval:          ctx.GlobalDuration("interval"),
+		MaxJobs:           ctx.GlobalInt("max-jobs"),
+		HeartbeatInterval: ctx.GlobalDuration("heartbeat-interval"),
+		Name:              ctx.GlobalString("name"),
+		Address:           net.JoinHostPort(ctx.GlobalString("advertise-host"), strconv.Itoa(int(ctx.Uint("port")))),
+		Workdir:           ctx.GlobalString("media-dir"),
+		MinFreeSpace:      ctx.GlobalUint64("min-free-space") << 20,
+		LogDir:            ctx.String("log-dir"),
+		JobLogRetention:   ctx.Duration("job-log-retention"),
+		MaxLogSize:        ctx.Int("max-log-size"),
+		MaxLogBackup:      ctx.Int("max-log-backups"),
+		Storage: storage.Config{
+			Type:      ctx.GlobalString("storage"),
+			Dir:       ctx.GlobalString("storage-dir"),
+			Endpoint:  ctx.GlobalString("s3-endpoint"),
+			Region:    ctx.GlobalString("s3-region"),
+			Bucket:    ctx.GlobalString("s3-bucket"),
+			Prefix:    ctx.GlobalString("s3-prefix"),
+			AccessKey: ctx.GlobalString("s3-access-key"),
+			SecretKey: ctx.GlobalString("s3-secret-key"),
+		},
 	}
 }
 
 func serve(ctx *cli.Context) error {
 	installSignalHandler()
 
-	handler, err := runner.NewHandler(runner.Config{
-		MgrHost:      ctx.GlobalString("mgr-host"),
-		MgrPort:      ctx.GlobalUint("mgr-port"),
-		Interval:     ctx.GlobalDuration("interval"),
-		Name:         ctx.GlobalString("name"),
-		Workdir:      ctx.GlobalString("media-dir"),
-		LogDir:       ctx.String("log-dir"),
-		MaxLogSize:   ctx.Int("max-log-size"),
-		MaxLogBackup: ctx.Int("max-log-backups"),
-	})
+	handler, err := runner.NewHandler(mkConfig(ctx))
 	if err != nil {
 		return err
 	}
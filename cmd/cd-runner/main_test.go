@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/leslie-wang/clusterd/handler/runner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli"
+)
+
+func parseConfig(t *testing.T, args ...string) runner.Config {
+	var c runner.Config
+	app := newApp("runner", t.TempDir())
+	app.Action = func(ctx *cli.Context) error {
+		c = mkConfig(ctx)
+		return nil
+	}
+	require.Nil(t, app.Run(append([]string{"cd-runner"}, args...)))
+	return c
+}
+
+func TestMinFreeSpace(t *testing.T) {
+	// in megabytes
+	assert.Equal(t, uint64(1024<<20), parseConfig(t).MinFreeSpace)
+	assert.Equal(t, uint64(10<<20), parseConfig(t, "--min-free-space", "10").MinFreeSpace)
+	assert.Equal(t, uint64(0), parseConfig(t, "--min-free-space", "0").MinFreeSpace)
+}
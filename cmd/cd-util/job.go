@@ -88,8 +88,17 @@ func getJobLog(ctx *cli.Context) error {
 		return errors.New("job ID must be integer, please provide a valid job ID")
 	}
 
-	mc := manager.NewClient(ctx.GlobalString("mgr-host"), ctx.GlobalUint("mgr-port"))
-	logReader, err := mc.DownloadLogFromManager(id)
+	var logReader io.ReadCloser
+	if ctx.Bool("from-runner") {
+		if ctx.GlobalString("runner-host") == "" {
+			return errors.New("please provide runner host")
+		}
+		rc := manager.NewClient(ctx.GlobalString("runner-host"), ctx.GlobalUint("runner-port"))
+		logReader, err = rc.DownloadLogFromRunner(id, ctx.String("stream"), 0, ctx.Bool("follow"))
+	} else {
+		mc := manager.NewClient(ctx.GlobalString("mgr-host"), ctx.GlobalUint("mgr-port"))
+		logReader, err = mc.DownloadLogFromManager(id, ctx.String("stream"), ctx.Int("tail"), ctx.Bool("follow"))
+	}
 	if err != nil {
 		return err
 	}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"text/tabwriter"
 	"time"
 
 	"github.com/leslie-wang/clusterd/client/manager"
+	"github.com/leslie-wang/clusterd/common/util"
 	"github.com/leslie-wang/clusterd/types"
 	"github.com/urfave/cli"
 )
@@ -93,6 +97,26 @@ func main() {
 					},
 				},
 			},
+			{
+				Name:      "modify",
+				Usage:     "modify given fields of callback template",
+				Action:    modifyCallBackTemplate,
+				ArgsUsage: "[ID]",
+				Flags: []cli.Flag{
+					cli.StringFlag{Name: "TemplateName"},
+					cli.StringFlag{Name: "Description"},
+					cli.StringFlag{Name: "CallbackKey"},
+					cli.StringFlag{Name: "StreamBeginNotifyUrl"},
+					cli.StringFlag{Name: "StreamEndNotifyUrl"},
+					cli.StringFlag{Name: "RecordNotifyUrl"},
+					cli.StringFlag{Name: "RecordStatusNotifyUrl"},
+					cli.StringFlag{Name: "SnapshotNotifyUrl"},
+					cli.StringFlag{Name: "PornCensorshipNotifyUrl"},
+					cli.StringFlag{Name: "PushExceptionNotifyUrl"},
+					cli.StringFlag{Name: "AudioAuditNotifyUrl"},
+					cli.StringFlag{Name: "StreamMixNotifyUrl"},
+				},
+			},
 		},
 	}
 	callbackRuleCommands := cli.Command{
@@ -114,6 +138,35 @@ func main() {
 			},
 		},
 	}
+	callbackRecordCommands := cli.Command{
+		Name:   "history",
+		Usage:  "list callbacks sent by manager",
+		Action: listCallbackRecords,
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "id", Usage: "show only the callback with the ID"},
+			cli.StringFlag{Name: "session", Usage: "session ID of the callbacks"},
+			cli.StringFlag{Name: "stream, s", Usage: "stream name of the callbacks"},
+			cli.StringFlag{Name: "status", Usage: "0: pending, 1: delivered, 2: failed"},
+			cli.StringFlag{Name: "event-type", Usage: "event type of the callbacks"},
+			cli.StringFlag{Name: "result-code", Usage: "0 for delivered, otherwise http status code or 1 for error"},
+			cli.StringFlag{Name: "start-time", Usage: "created since the time, like 2006-01-02T15:04:05Z"},
+			cli.StringFlag{Name: "end-time", Usage: "created until the time, like 2006-01-02T15:04:05Z"},
+			cli.StringFlag{Name: "page", Usage: "page number, from 1"},
+			cli.StringFlag{Name: "page-size", Usage: "callbacks in each page, at most 100"},
+			cli.StringFlag{
+				Name:  "output, o",
+				Usage: "output file which saves callback info",
+			},
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:      "replay",
+				Usage:     "send the delivered or failed callback again",
+				Action:    replayCallbackRecord,
+				ArgsUsage: "[callback ID]",
+			},
+		},
+	}
 	app.Commands = []cli.Command{
 		{
 			Name:    "record",
@@ -141,6 +194,10 @@ func main() {
 							Usage: "trea  name of the recording",
 							Value: "livetest",
 						},
+						cli.IntFlag{
+							Name:  "priority",
+							Usage: "priority of the recording, higher priority is scheduled first",
+						},
 						cli.UintFlag{
 							Name: "retry-count",
 						},
@@ -160,12 +217,41 @@ func main() {
 					ArgsUsage: "[job ID]",
 					Action:    cancelRecordTask,
 				},
+				{
+					Name:      "stop",
+					Usage:     "stop one recording, and keep recorded files",
+					ArgsUsage: "[task ID]",
+					Action:    stopRecordTask,
+				},
+				{
+					Name:      "pause",
+					Usage:     "pause one recording, which keeps the task until it is resumed",
+					ArgsUsage: "[task ID]",
+					Action:    pauseRecordTask,
+				},
+				{
+					Name:      "resume",
+					Usage:     "resume one paused recording into the same playlist",
+					ArgsUsage: "[task ID]",
+					Action:    resumeRecordTask,
+				},
+				{
+					Name:   "retention",
+					Usage:  "list recorded files which expire next, without removing them",
+					Action: listRetentions,
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "limit, l",
+							Usage: "number of retentions to list, manager's default if not set",
+						},
+					},
+				},
 				{
 					Name:    "callback",
 					Aliases: []string{"cb"},
 					Usage: "create record task. if start time is not provided, record will start in 5 second." +
 						" [record URL] [[start time]] [duration]",
-					Subcommands: []cli.Command{callbackTemplateCommands, callbackRuleCommands},
+					Subcommands: []cli.Command{callbackTemplateCommands, callbackRuleCommands, callbackRecordCommands},
 				},
 			},
 		},
@@ -179,6 +265,18 @@ func main() {
 					Usage:   "list all active registered runners",
 					Action:  listRunners,
 				},
+				{
+					Name:      "get",
+					Usage:     "get one registered runner",
+					ArgsUsage: "[runner name]",
+					Action:    getRunner,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "output, o",
+							Usage: "output file which saves runner info",
+						},
+					},
+				},
 			},
 		},
 		{
@@ -209,6 +307,25 @@ func main() {
 					Usage:     "get one job's log",
 					ArgsUsage: "[job ID]",
 					Action:    getJobLog,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "stream, s",
+							Usage: "log stream, stdout or stderr",
+							Value: types.LogStreamStderr,
+						},
+						cli.IntFlag{
+							Name:  "tail, t",
+							Usage: "number of lines to show from the end of the log, all lines if not set",
+						},
+						cli.BoolFlag{
+							Name:  "follow, f",
+							Usage: "keep streaming new log until the job is finished",
+						},
+						cli.BoolFlag{
+							Name:  "from-runner, r",
+							Usage: "read log directly from the runner given by --runner-host",
+						},
+					},
 				},
 				{
 					Name:      "get",
@@ -266,6 +383,15 @@ func main() {
 							Usage: "listen port",
 							Value: types.UtilListenPort,
 						},
+						cli.StringFlag{
+							Name:  "key, k",
+							Usage: "verify sign of callbacks by the key if it is given",
+						},
+						cli.StringFlag{
+							Name:  "sign-method",
+							Usage: "method which callbacks are signed by, md5 or hmac-sha256",
+							Value: util.SignMethodMD5,
+						},
 					},
 				},
 			},
@@ -287,19 +413,45 @@ func listRunners(ctx *cli.Context) error {
 	writer := tabwriter.NewWriter(os.Stdout, 5, 1, 1, ' ', 0)
 	defer writer.Flush()
 
-	writer.Write([]byte("Runner\tJob ID\tStart Time\tLast Seen Time\n"))
+	writer.Write([]byte("Runner\tAddress\tVersion\tCapacity\tJobs\tDisk Free\tDisk Used\tLast Seen Time\n"))
 
-	for name, j := range runners {
-		st := ""
-		if j.StartTime != nil {
-			st = j.StartTime.Local().Format("2006-01-02 15:04:05")
-		}
-		lt := ""
-		if j.LastSeenTime != nil {
-			lt = j.LastSeenTime.Local().Format("2006-01-02 15:04:05")
-		}
-		line := fmt.Sprintf("%s\t%d\t%s\t%s\n", name, j.ID, st, lt)
+	for _, r := range runners {
+		line := fmt.Sprintf("%s\t%s\t%s\t%d\t%s\t%d\t%d\t%s\n", r.Name, r.Address, r.Version, r.Capacity,
+			formatJobIDs(r.Jobs), r.DiskFree, r.DiskUsed, r.LastSeenTime.Local().Format("2006-01-02 15:04:05"))
 		writer.Write([]byte(line))
 	}
 	return nil
 }
+
+func getRunner(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return errors.New("please provide one runner name")
+	}
+
+	mc := manager.NewClient(ctx.GlobalString("mgr-host"), ctx.GlobalUint("mgr-port"))
+	r, err := mc.GetRunner(ctx.Args()[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Name: %s\nAddress: %s\nVersion: %s\nCapacity: %d\nJobs: %s\nDisk Free: %d\nDisk Used: %d\n"+
+		"Last Seen Time: %s\n", r.Name, r.Address, r.Version, r.Capacity, formatJobIDs(r.Jobs), r.DiskFree,
+		r.DiskUsed, r.LastSeenTime.Local().Format("2006-01-02 15:04:05"))
+
+	outputFilename := ctx.String("output")
+	if outputFilename == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputFilename, content, 0755)
+}
+
+func formatJobIDs(ids []int) string {
+	strs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		strs = append(strs, strconv.Itoa(id))
+	}
+	return strings.Join(strs, ",")
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/leslie-wang/clusterd/client/manager"
@@ -56,6 +57,7 @@ func createRecordTask(ctx *cli.Context) error {
 			ctx.Args()[0],
 			start,
 			end,
+			ctx.Int("priority"),
 		)
 		if err == nil {
 			fmt.Printf("Created recording task: %s\n", *id)
@@ -93,3 +95,62 @@ func cancelRecordTask(ctx *cli.Context) error {
 	mc := manager.NewClient(ctx.GlobalString("mgr-host"), ctx.GlobalUint("mgr-port"))
 	return mc.CancelRecordTask(ctx.Args()[0])
 }
+
+func stopRecordTask(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return errors.New("please provide one task ID")
+	}
+	_, err := strconv.Atoi(ctx.Args()[0])
+	if err != nil {
+		return errors.New("task ID must be integer, please provide a valid task ID")
+	}
+
+	mc := manager.NewClient(ctx.GlobalString("mgr-host"), ctx.GlobalUint("mgr-port"))
+	return mc.StopRecordTask(ctx.Args()[0])
+}
+
+func pauseRecordTask(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return errors.New("please provide one task ID")
+	}
+	_, err := strconv.Atoi(ctx.Args()[0])
+	if err != nil {
+		return errors.New("task ID must be integer, please provide a valid task ID")
+	}
+
+	mc := manager.NewClient(ctx.GlobalString("mgr-host"), ctx.GlobalUint("mgr-port"))
+	return mc.PauseRecordTask(ctx.Args()[0])
+}
+
+func resumeRecordTask(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return errors.New("please provide one task ID")
+	}
+	_, err := strconv.Atoi(ctx.Args()[0])
+	if err != nil {
+		return errors.New("task ID must be integer, please provide a valid task ID")
+	}
+
+	mc := manager.NewClient(ctx.GlobalString("mgr-host"), ctx.GlobalUint("mgr-port"))
+	return mc.ResumeRecordTask(ctx.Args()[0])
+}
+
+func listRetentions(ctx *cli.Context) error {
+	mc := manager.NewClient(ctx.GlobalString("mgr-host"), ctx.GlobalUint("mgr-port"))
+	rs, err := mc.ListRetentions(ctx.Int("limit"))
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 5, 1, 1, ' ', 0)
+	defer writer.Flush()
+
+	writer.Write([]byte("Job\tFormat\tExpire Time\tFiles\tSize\n"))
+
+	for _, r := range rs {
+		line := fmt.Sprintf("%d\t%s\t%s\t%d\t%d\n", r.JobID, r.Format,
+			r.ExpireTime.Local().Format("2006-01-02 15:04:05"), r.Files, r.Size)
+		writer.Write([]byte(line))
+	}
+	return nil
+}
@@ -118,6 +118,41 @@ func createCallBackTemplate(ctx *cli.Context) error {
 	return err
 }
 
+func modifyCallBackTemplate(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return errors.New("invalid input")
+	}
+	id, err := strconv.ParseInt(ctx.Args()[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	mc := manager.NewClient(ctx.GlobalString("mgr-host"), ctx.GlobalUint("mgr-port"))
+
+	// only flags given in command line are changed
+	get := func(name string) *string {
+		if !ctx.IsSet(name) {
+			return nil
+		}
+		val := ctx.String(name)
+		return &val
+	}
+	return mc.ModifyLiveCallbackTemplate(&model.ModifyLiveCallbackTemplateRequestParams{
+		TemplateId:              &id,
+		TemplateName:            get("TemplateName"),
+		Description:             get("Description"),
+		CallbackKey:             get("CallbackKey"),
+		StreamBeginNotifyUrl:    get("StreamBeginNotifyUrl"),
+		StreamEndNotifyUrl:      get("StreamEndNotifyUrl"),
+		RecordNotifyUrl:         get("RecordNotifyUrl"),
+		RecordStatusNotifyUrl:   get("RecordStatusNotifyUrl"),
+		SnapshotNotifyUrl:       get("SnapshotNotifyUrl"),
+		PornCensorshipNotifyUrl: get("PornCensorshipNotifyUrl"),
+		PushExceptionNotifyUrl:  get("PushExceptionNotifyUrl"),
+		AudioAuditNotifyUrl:     get("AudioAuditNotifyUrl"),
+		StreamMixNotifyUrl:      get("StreamMixNotifyUrl"),
+	})
+}
+
 func listCallbackRules(ctx *cli.Context) error {
 	outputFilename := ctx.String("output")
 	mc := manager.NewClient(ctx.GlobalString("mgr-host"), ctx.GlobalUint("mgr-port"))
@@ -156,3 +191,60 @@ func createCallBackRule(ctx *cli.Context) error {
 	mc := manager.NewClient(ctx.GlobalString("mgr-host"), ctx.GlobalUint("mgr-port"))
 	return mc.CreateLiveCallbackRule(id, ctx.Args()[1], ctx.Args()[2])
 }
+
+func listCallbackRecords(ctx *cli.Context) error {
+	outputFilename := ctx.String("output")
+	mc := manager.NewClient(ctx.GlobalString("mgr-host"), ctx.GlobalUint("mgr-port"))
+
+	filters := map[string]string{}
+	for flag, param := range map[string]string{
+		"id":          "CallbackId",
+		"session":     "SessionId",
+		"stream":      "StreamName",
+		"status":      "Status",
+		"event-type":  "EventType",
+		"result-code": "ResultCode",
+		"start-time":  "StartTime",
+		"end-time":    "EndTime",
+		"page":        "PageNum",
+		"page-size":   "PageSize",
+	} {
+		if val := ctx.String(flag); val != "" {
+			filters[param] = val
+		}
+	}
+	records, err := mc.ListCallbackRecords(filters)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 10, 2, 2, ' ', 0)
+	writer.Write([]byte("ID\tSession\tEvent Type\tStatus\tAttempts\tResult Code\tEvent Time\tURL\n"))
+	for _, r := range records.DataInfoList {
+		code := "-"
+		if r.ResultCode != nil {
+			code = strconv.FormatUint(*r.ResultCode, 10)
+		}
+		writer.Write([]byte(fmt.Sprintf("%d\t%s\t%d\t%d\t%d\t%s\t%s\t%s\n", *r.CallbackId, *r.SessionId,
+			*r.EventType, *r.Status, *r.Attempts, code, *r.EventTime, *r.Url)))
+	}
+	writer.Flush()
+	fmt.Printf("page %d of %d, total %d\n", *records.PageNum, *records.TotalPage, *records.TotalNum)
+
+	if outputFilename == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(records.DataInfoList, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputFilename, content, 0755)
+}
+
+func replayCallbackRecord(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return errors.New("invalid input")
+	}
+	mc := manager.NewClient(ctx.GlobalString("mgr-host"), ctx.GlobalUint("mgr-port"))
+	return mc.ReplayCallbackRecord(ctx.Args()[0])
+}
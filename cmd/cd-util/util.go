@@ -6,12 +6,19 @@ import (
 	"os"
 	"strconv"
 
+	"github.com/leslie-wang/clusterd/client/manager"
 	"github.com/leslie-wang/clusterd/common/util"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
 func listen(ctx *cli.Context) error {
+	key, method := ctx.String("key"), ctx.String("sign-method")
+	err := util.CheckSignMethod(method)
+	if err != nil {
+		return err
+	}
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 		content, err := io.ReadAll(r.Body)
@@ -20,6 +27,14 @@ func listen(ctx *cli.Context) error {
 			return
 		}
 		logrus.Infof("%s - %s\n%s\n", r.Method, r.URL.Path, string(content))
+		if key != "" {
+			err = manager.VerifyCallback(r, content, key, method)
+			if err != nil {
+				logrus.Warnf("verify callback: %s", err)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
 		if len(content) != 0 {
 			os.WriteFile("request.json", content, 0755)
 		}
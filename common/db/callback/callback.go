@@ -0,0 +1,217 @@
+package callback
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/db/dialect"
+	"github.com/leslie-wang/clusterd/types"
+)
+
+const (
+	callbackColumns = "id, session_id, stream_name, url, sign_key, sign_method, event_type, content, status, attempts," +
+		" result_code, response, next_time, create_time, update_time"
+
+	insertCallback = "insert into callbacks (session_id, stream_name, url, sign_key, sign_method, event_type, content," +
+		" next_time, create_time) values(?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)"
+	getCallback = "select " + callbackColumns + " from callbacks where id=?"
+	// oldest pending callback of each session, so that callbacks of one session are delivered in order
+	listDueCallbacks = "select " + callbackColumns + " from callbacks as c where c.status=0 and c.next_time<=?" +
+		" and not exists (select 1 from callbacks as p where p.session_id=c.session_id and p.status=0 and p.id<c.id)" +
+		" order by c.id limit ?"
+	updateCallbackAttempt = "update callbacks set status=?, attempts=attempts+1, result_code=?, response=?," +
+		" next_time=?, update_time=CURRENT_TIMESTAMP where id=?"
+	replayCallback = "update callbacks set status=0, attempts=0, next_time=?, update_time=CURRENT_TIMESTAMP" +
+		" where id=? and status<>0"
+	removeDeliveredCallbacks = "delete from callbacks where status=1 and update_time<?"
+)
+
+var (
+	prepareCallbackSQLs = []string{
+		getCallback,
+		listDueCallbacks,
+		updateCallbackAttempt,
+		replayCallback,
+		removeDeliveredCallbacks,
+	}
+)
+
+// DB is interface to callback outbox database
+type DB struct {
+	db     *sql.DB
+	driver string
+	// statements are of this DB, so that handlers of different DBs don't share them
+	statements map[string]*sql.Stmt
+}
+
+func NewDB(db *sql.DB, driver string) *DB {
+	return &DB{db: db, driver: driver}
+}
+
+// Prepare prepares all statement
+func (c *DB) Prepare() error {
+	c.statements = make(map[string]*sql.Stmt)
+	for _, s := range prepareCallbackSQLs {
+		stmt, err := c.db.Prepare(dialect.Rebind(c.driver, s))
+		if err != nil {
+			return err
+		}
+		c.statements[s] = stmt
+	}
+	return nil
+}
+
+// Insert saves the callback into outbox, to be delivered after its next time
+func (c *DB) Insert(cb *types.Callback) error {
+	id, err := dialect.Insert(c.db, c.driver, insertCallback, cb.SessionID, cb.StreamName, cb.URL, cb.SignKey,
+		cb.SignMethod, cb.EventType, cb.Content, cb.NextTime.UTC())
+	if err != nil {
+		return err
+	}
+	cb.ID = id
+	return nil
+}
+
+// Get returns the callback, or nil if it doesn't exist
+func (c *DB) Get(id int64) (*types.Callback, error) {
+	s := c.statements[getCallback]
+	cb, err := scanCallback(s.QueryRow(id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return cb, err
+}
+
+// ListDue lists callbacks to deliver at now, at most one of each session
+func (c *DB) ListDue(now time.Time, limit int) ([]types.Callback, error) {
+	s := c.statements[listDueCallbacks]
+	rows, err := s.QueryContext(context.Background(), now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanCallbacks(rows)
+}
+
+// UpdateAttempt saves result of one delivery attempt, and when to try next if it is still pending
+func (c *DB) UpdateAttempt(id int64, status types.CallbackStatus, resultCode int, response string, next time.Time) error {
+	s := c.statements[updateCallbackAttempt]
+	_, err := s.Exec(status, resultCode, response, next.UTC(), id)
+	return err
+}
+
+// Replay delivers the callback again from now. It returns false if the callback doesn't exist or is pending.
+func (c *DB) Replay(id int64) (bool, error) {
+	s := c.statements[replayCallback]
+	res, err := s.Exec(time.Now().UTC(), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RemoveDelivered removes callbacks delivered before given time
+func (c *DB) RemoveDelivered(before time.Time) (int64, error) {
+	s := c.statements[removeDeliveredCallbacks]
+	res, err := s.Exec(before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// List lists callbacks selected by the filter in order of creation, and total number of selected ones
+func (c *DB) List(f *types.CallbackFilter) ([]types.Callback, int, error) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if f.StartTime != nil {
+		conds = append(conds, "create_time>=?")
+		args = append(args, f.StartTime.UTC())
+	}
+	if f.EndTime != nil {
+		conds = append(conds, "create_time<=?")
+		args = append(args, f.EndTime.UTC())
+	}
+	if f.SessionID != "" {
+		conds = append(conds, "session_id=?")
+		args = append(args, f.SessionID)
+	}
+	if f.StreamName != "" {
+		conds = append(conds, "stream_name=?")
+		args = append(args, f.StreamName)
+	}
+	if f.EventType != nil {
+		conds = append(conds, "event_type=?")
+		args = append(args, *f.EventType)
+	}
+	if f.Status != nil {
+		conds = append(conds, "status=?")
+		args = append(args, *f.Status)
+	}
+	if f.ResultCode != nil {
+		conds = append(conds, "result_code=?")
+		args = append(args, *f.ResultCode)
+	}
+	where := ""
+	if len(conds) != 0 {
+		where = " where " + strings.Join(conds, " and ")
+	}
+
+	var total int
+	err := c.db.QueryRow(dialect.Rebind(c.driver, "select count(*) from callbacks"+where), args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := "select " + callbackColumns + " from callbacks" + where + " order by id limit ? offset ?"
+	rows, err := c.db.Query(dialect.Rebind(c.driver, query), append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	cbs, err := scanCallbacks(rows)
+	return cbs, total, err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCallback(row scanner) (*types.Callback, error) {
+	var (
+		cb                                    types.Callback
+		stream, signKey, signMethod, response sql.NullString
+		resultCode                            sql.NullInt64
+	)
+	err := row.Scan(&cb.ID, &cb.SessionID, &stream, &cb.URL, &signKey, &signMethod, &cb.EventType, &cb.Content,
+		&cb.Status, &cb.Attempts, &resultCode, &response, &cb.NextTime, &cb.CreateTime, &cb.UpdateTime)
+	if err != nil {
+		return nil, err
+	}
+	cb.StreamName = stream.String
+	cb.SignKey = signKey.String
+	cb.SignMethod = signMethod.String
+	cb.Response = response.String
+	if resultCode.Valid {
+		code := int(resultCode.Int64)
+		cb.ResultCode = &code
+	}
+	return &cb, nil
+}
+
+func scanCallbacks(rows *sql.Rows) ([]types.Callback, error) {
+	defer rows.Close()
+
+	cbs := []types.Callback{}
+	for rows.Next() {
+		cb, err := scanCallback(rows)
+		if err != nil {
+			return nil, err
+		}
+		cbs = append(cbs, *cb)
+	}
+	return cbs, rows.Err()
+}
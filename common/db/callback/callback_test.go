@@ -0,0 +1,173 @@
+package callback
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/db/dbtest"
+	"github.com/leslie-wang/clusterd/common/db/migrate"
+	"github.com/leslie-wang/clusterd/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDB(t *testing.T, driver string) *DB {
+	d := dbtest.Open(t, driver)
+	_, err := migrate.Up(d, driver)
+	require.Nil(t, err)
+
+	cdb := NewDB(d, driver)
+	require.Nil(t, cdb.Prepare())
+	return cdb
+}
+
+func insertTestCallback(t *testing.T, cdb *DB, session string, typ types.LiveCallbackEventType, next time.Time) int64 {
+	cb := &types.Callback{
+		SessionID:  session,
+		StreamName: "stream-" + session,
+		URL:        "http://localhost/cb",
+		EventType:  typ,
+		Content:    `{"event_type":1}`,
+		NextTime:   next,
+	}
+	require.Nil(t, cdb.Insert(cb))
+	return cb.ID
+}
+
+func dueIDs(t *testing.T, cdb *DB, now time.Time) []int64 {
+	cbs, err := cdb.ListDue(now, 10)
+	require.Nil(t, err)
+	ids := []int64{}
+	for _, cb := range cbs {
+		ids = append(ids, cb.ID)
+	}
+	return ids
+}
+
+func TestListDueInSessionOrder(t *testing.T) {
+	dbtest.Run(t, testListDueInSessionOrder)
+}
+
+func testListDueInSessionOrder(t *testing.T, driver string) {
+	cdb := newTestDB(t, driver)
+	now := time.Now()
+
+	a1 := insertTestCallback(t, cdb, "a", types.LiveCallbackEventTypeRecordStatus, now)
+	a2 := insertTestCallback(t, cdb, "a", types.LiveCallbackEventTypeRecordFile, now)
+	b1 := insertTestCallback(t, cdb, "b", types.LiveCallbackEventTypeRecordStatus, now.Add(time.Hour))
+
+	// b1 isn't due yet, and a2 waits for a1
+	assert.Equal(t, []int64{a1}, dueIDs(t, cdb, now))
+	assert.Equal(t, []int64{a1, b1}, dueIDs(t, cdb, now.Add(2*time.Hour)))
+
+	// a1 failed once, and a2 still waits for its retry
+	require.Nil(t, cdb.UpdateAttempt(a1, types.CallbackPending, 500, "oops", now.Add(time.Minute)))
+	assert.Equal(t, []int64{}, dueIDs(t, cdb, now))
+	assert.Equal(t, []int64{a1}, dueIDs(t, cdb, now.Add(time.Minute)))
+
+	require.Nil(t, cdb.UpdateAttempt(a1, types.CallbackDelivered, 0, "", now))
+	assert.Equal(t, []int64{a2}, dueIDs(t, cdb, now))
+
+	cb, err := cdb.Get(a1)
+	require.Nil(t, err)
+	assert.Equal(t, types.CallbackDelivered, cb.Status)
+	assert.Equal(t, 2, cb.Attempts)
+	require.NotNil(t, cb.ResultCode)
+	assert.Equal(t, 0, *cb.ResultCode)
+	assert.NotNil(t, cb.UpdateTime)
+
+	cb, err = cdb.Get(a1 + 100)
+	require.Nil(t, err)
+	assert.Nil(t, cb)
+}
+
+func TestReplay(t *testing.T) {
+	dbtest.Run(t, testReplay)
+}
+
+func testReplay(t *testing.T, driver string) {
+	cdb := newTestDB(t, driver)
+	now := time.Now()
+
+	id := insertTestCallback(t, cdb, "a", types.LiveCallbackEventTypeRecordStatus, now)
+
+	// pending callback can't be replayed
+	ok, err := cdb.Replay(id)
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	require.Nil(t, cdb.UpdateAttempt(id, types.CallbackFailed, 404, "not found", now))
+	assert.Equal(t, []int64{}, dueIDs(t, cdb, now.Add(time.Hour)))
+
+	ok, err = cdb.Replay(id)
+	require.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []int64{id}, dueIDs(t, cdb, time.Now()))
+
+	cb, err := cdb.Get(id)
+	require.Nil(t, err)
+	assert.Equal(t, types.CallbackPending, cb.Status)
+	assert.Equal(t, 0, cb.Attempts)
+
+	ok, err = cdb.Replay(id + 100)
+	require.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestList(t *testing.T) {
+	dbtest.Run(t, testList)
+}
+
+func testList(t *testing.T, driver string) {
+	cdb := newTestDB(t, driver)
+	now := time.Now()
+
+	a1 := insertTestCallback(t, cdb, "a", types.LiveCallbackEventTypeRecordStatus, now)
+	a2 := insertTestCallback(t, cdb, "a", types.LiveCallbackEventTypeRecordFile, now)
+	b1 := insertTestCallback(t, cdb, "b", types.LiveCallbackEventTypeRecordStatus, now)
+	require.Nil(t, cdb.UpdateAttempt(b1, types.CallbackFailed, 500, "oops", now))
+
+	ids := func(f types.CallbackFilter) ([]int64, int) {
+		if f.Limit == 0 {
+			f.Limit = 10
+		}
+		cbs, total, err := cdb.List(&f)
+		require.Nil(t, err)
+		ids := []int64{}
+		for _, cb := range cbs {
+			ids = append(ids, cb.ID)
+		}
+		return ids, total
+	}
+
+	got, total := ids(types.CallbackFilter{})
+	assert.Equal(t, []int64{a1, a2, b1}, got)
+	assert.Equal(t, 3, total)
+
+	got, total = ids(types.CallbackFilter{Offset: 1, Limit: 1})
+	assert.Equal(t, []int64{a2}, got)
+	assert.Equal(t, 3, total)
+
+	got, _ = ids(types.CallbackFilter{SessionID: "a"})
+	assert.Equal(t, []int64{a1, a2}, got)
+
+	got, _ = ids(types.CallbackFilter{StreamName: "stream-b"})
+	assert.Equal(t, []int64{b1}, got)
+
+	typ := types.LiveCallbackEventTypeRecordStatus
+	got, _ = ids(types.CallbackFilter{EventType: &typ})
+	assert.Equal(t, []int64{a1, b1}, got)
+
+	status := types.CallbackFailed
+	got, _ = ids(types.CallbackFilter{Status: &status})
+	assert.Equal(t, []int64{b1}, got)
+
+	code := 500
+	got, _ = ids(types.CallbackFilter{ResultCode: &code})
+	assert.Equal(t, []int64{b1}, got)
+
+	end := now.Add(-time.Hour)
+	got, total = ids(types.CallbackFilter{EndTime: &end})
+	assert.Equal(t, []int64{}, got)
+	assert.Equal(t, 0, total)
+}
@@ -0,0 +1,120 @@
+// Package dialect adapts the SQL written for mysql and sqlite to other DB drivers.
+package dialect
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// MySQL is the driver name of MySQL
+	MySQL = "mysql"
+	// Postgres is the driver name of PostgreSQL
+	Postgres = "postgres"
+)
+
+// errors of mysql DDL whose change is in the schema already
+const (
+	mysqlTableExists = 1050
+	mysqlDupColumn   = 1060
+	mysqlDupKey      = 1061
+)
+
+// errors of mysql when the transaction loses a row lock to a concurrent one
+const (
+	mysqlLockWaitTimeout = 1205
+	mysqlDeadlock        = 1213
+)
+
+// Execer is implemented by both *sql.DB and *sql.Tx
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Rebind converts "?" placeholders in query to the ones driver supports
+func Rebind(driver, query string) string {
+	if driver != Postgres {
+		return query
+	}
+
+	b := strings.Builder{}
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// SkipLocked returns the clause which locks the selected rows in the transaction, and skips rows
+// locked by others. Sqlite has no row lock, so it returns empty.
+func SkipLocked(driver string) string {
+	switch driver {
+	case MySQL, Postgres:
+		return " for update skip locked"
+	}
+	return ""
+}
+
+// Insert executes the insert query, and returns id generated for the new row
+func Insert(e Execer, driver, query string, args ...interface{}) (int64, error) {
+	if driver == Postgres {
+		// postgres doesn't support LastInsertId
+		var id int64
+		err := e.QueryRow(Rebind(driver, query)+" returning id", args...).Scan(&id)
+		return id, err
+	}
+
+	res, err := e.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// TransactionalDDL returns whether schema changes of the driver are rolled back with the transaction. DDL of
+// mysql commits the transaction implicitly.
+func TransactionalDDL(driver string) bool {
+	return driver != MySQL
+}
+
+// AlreadyApplied returns whether err is returned since the schema change is in the database already, e.g. the
+// column is added by the part of the migration which was applied before it failed
+func AlreadyApplied(driver string, err error) bool {
+	if driver != MySQL {
+		return false
+	}
+	var merr *mysql.MySQLError
+	if !errors.As(err, &merr) {
+		return false
+	}
+	switch merr.Number {
+	case mysqlTableExists, mysqlDupColumn, mysqlDupKey:
+		return true
+	}
+	return false
+}
+
+// LockConflict returns whether err is returned since the transaction lost a row lock to a concurrent one, so
+// that it can be tried again. Only mysql reports it under SKIP LOCKED, e.g. next-key locks of concurrent updates
+// deadlock.
+func LockConflict(driver string, err error) bool {
+	if driver != MySQL {
+		return false
+	}
+	var merr *mysql.MySQLError
+	if !errors.As(err, &merr) {
+		return false
+	}
+	return merr.Number == mysqlLockWaitTimeout || merr.Number == mysqlDeadlock
+}
@@ -0,0 +1,42 @@
+package dialect
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRebind(t *testing.T) {
+	q := "update jobs set runner=? where id=? and last_seen_time < ?"
+	assert.Equal(t, q, Rebind("sqlite", q))
+	assert.Equal(t, q, Rebind("mysql", q))
+	assert.Equal(t, "update jobs set runner=$1 where id=$2 and last_seen_time < $3", Rebind(Postgres, q))
+	assert.Equal(t, "select 1", Rebind(Postgres, "select 1"))
+}
+
+func TestSkipLocked(t *testing.T) {
+	assert.Equal(t, "", SkipLocked("sqlite"))
+	assert.Equal(t, " for update skip locked", SkipLocked(MySQL))
+	assert.Equal(t, " for update skip locked", SkipLocked(Postgres))
+}
+
+func TestAlreadyApplied(t *testing.T) {
+	dup := fmt.Errorf("alter table: %w", &mysql.MySQLError{Number: mysqlDupColumn, Message: "Duplicate column name"})
+	assert.True(t, AlreadyApplied(MySQL, dup))
+	assert.True(t, AlreadyApplied(MySQL, &mysql.MySQLError{Number: mysqlDupKey}))
+	assert.False(t, AlreadyApplied(MySQL, &mysql.MySQLError{Number: 1064})) // syntax error
+	assert.False(t, AlreadyApplied(MySQL, errors.New("duplicate column")))
+	assert.False(t, AlreadyApplied(Postgres, dup))
+}
+
+func TestLockConflict(t *testing.T) {
+	deadlock := fmt.Errorf("update jobs: %w", &mysql.MySQLError{Number: mysqlDeadlock, Message: "Deadlock found"})
+	assert.True(t, LockConflict(MySQL, deadlock))
+	assert.True(t, LockConflict(MySQL, &mysql.MySQLError{Number: mysqlLockWaitTimeout}))
+	assert.False(t, LockConflict(MySQL, &mysql.MySQLError{Number: mysqlDupKey}))
+	assert.False(t, LockConflict(Postgres, deadlock))
+}
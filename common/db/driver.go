@@ -4,14 +4,17 @@ import (
 	"database/sql"
 	"fmt"
 
+	"github.com/leslie-wang/clusterd/common/db/dialect"
 	"github.com/leslie-wang/clusterd/common/db/mysql"
+	"github.com/leslie-wang/clusterd/common/db/postgres"
 	"github.com/leslie-wang/clusterd/common/db/sqlite"
 	"github.com/leslie-wang/clusterd/types"
 )
 
 const (
-	MySQL  = "mysql"
-	Sqlite = "sqlite"
+	MySQL    = dialect.MySQL
+	Sqlite   = "sqlite"
+	Postgres = dialect.Postgres
 )
 
 // OpenDB opens the db
@@ -21,6 +24,8 @@ func OpenDB(cfg types.Config) (*sql.DB, error) {
 		return mysql.OpenDB(cfg)
 	case Sqlite:
 		return sqlite.OpenDB(cfg)
+	case Postgres:
+		return postgres.OpenDB(cfg)
 	}
 
 	return nil, fmt.Errorf("not support DB driver")
@@ -5,32 +5,68 @@ import (
 	"database/sql"
 	"time"
 
+	"github.com/leslie-wang/clusterd/common/db/dialect"
 	"github.com/leslie-wang/clusterd/types"
 	"github.com/pkg/errors"
 )
 
 const (
-	insertJob  = "insert into jobs (ref_id, category, metadata, create_time, schedule_time) values(?, ?, ?, CURRENT_TIMESTAMP, ?)"
+	insertJob  = "insert into jobs (ref_id, category, metadata, priority, tenant, create_time, schedule_time) values(?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?)"
 	archiveJob = `insert into job_archives (id, ref_id, category, metadata, runner, exit_code, create_time, start_time, end_time) 
 					select id, ref_id, category, metadata, runner, ?, create_time, start_time, CURRENT_TIMESTAMP from jobs where id=?`
-	listJobs            = "select id, ref_id, category, metadata, runner, create_time, schedule_time, start_time, last_seen_time from jobs"
-	getNotStartedJob    = "select id, category, metadata, schedule_time from jobs where start_time is null and (schedule_time is null or schedule_time < ?) order by create_time limit 1"
-	getNotFinishJobByID = "select ref_id, category, metadata, runner, create_time, start_time, schedule_time, last_seen_time from jobs where id=?"
+	listJobs            = "select id, ref_id, category, metadata, priority, tenant, runner, create_time, schedule_time, start_time, stop_time, pause_time, last_seen_time from jobs"
+	getNotFinishJobByID = "select ref_id, category, metadata, priority, tenant, runner, create_time, start_time, schedule_time, stop_time, pause_time, last_seen_time from jobs where id=?"
 	getArchivedJobByID  = "select ref_id, category, metadata, runner, exit_code, create_time, start_time, end_time from job_archives where id=?"
-	updateJobForRunner  = "update jobs set runner=?, start_time=CURRENT_TIMESTAMP, last_seen_time=CURRENT_TIMESTAMP where id=?"
+	updateJobForRunner  = "update jobs set runner=?, start_time=CURRENT_TIMESTAMP, last_seen_time=CURRENT_TIMESTAMP where id=? and start_time is null"
+	stopJob             = "update jobs set stop_time=CURRENT_TIMESTAMP where id=? and stop_time is null"
 	removeJob           = "delete from jobs where id=?"
+	pauseJob            = "update jobs set pause_time=CURRENT_TIMESTAMP where id=? and pause_time is null"
+	resumeJob           = "update jobs set pause_time=null where id=? and pause_time is not null"
+	updateJobMetadata   = "update jobs set metadata=? where id=? and start_time is null"
+
+	heartbeatJob    = "update jobs set last_seen_time=CURRENT_TIMESTAMP where id=? and runner=?"
+	listExpiredJobs = "select id, ref_id, category, metadata, runner, create_time, start_time, stop_time, last_seen_time," +
+		" requeue_count from jobs where runner is not null and last_seen_time < ?"
+	// getNotStartedJob picks due job of highest priority. Among the same priority, the tenant with
+	// fewest running jobs goes first, so that a burst from one tenant doesn't starve others. Jobs without
+	// tenant are counted as one tenant.
+	getNotStartedJob = "select id, category, metadata, priority, tenant, schedule_time from jobs as j" +
+		" where start_time is null and (schedule_time is null or schedule_time < ?)" +
+		" order by priority desc," +
+		" (select count(*) from jobs as r where coalesce(r.tenant,'')=coalesce(j.tenant,'') and r.start_time is not null)," +
+		" create_time limit 1"
+	requeueJob = "update jobs set runner=null, start_time=null, last_seen_time=null, requeue_count=requeue_count+1" +
+		" where id=? and runner=? and last_seen_time < ?"
+	retryJob = "update jobs set runner=null, start_time=null, last_seen_time=null, schedule_time=?" +
+		" where id=? and runner=?"
+
+	insertAttempt = "insert into job_attempts (job_id, runner, exit_code, detail, start_time, end_time)" +
+		" values(?, ?, ?, ?, ?, CURRENT_TIMESTAMP)"
+	listAttempts = "select runner, exit_code, detail, start_time, end_time from job_attempts where job_id=? order by id"
 
 	listActiveRunners = "select id, ref_id, category, metadata, runner, create_time, start_time, last_seen_time from jobs where runner is not null order by runner"
 )
 
+// maxAcquireAttempts is how many times to pick another job after losing one to other runner
+const maxAcquireAttempts = 3
+
 var (
+	errJobTaken = errors.New("job is taken by other runner")
+
 	prepareJobSQLs = []string{
-		insertJob,
 		listJobs,
-		getNotStartedJob,
 		getNotFinishJobByID,
 		getArchivedJobByID,
 		updateJobForRunner,
+		stopJob,
+		pauseJob,
+		resumeJob,
+		heartbeatJob,
+		listExpiredJobs,
+		requeueJob,
+		retryJob,
+		insertAttempt,
+		listAttempts,
 		listActiveRunners,
 		archiveJob,
 		removeJob,
@@ -40,18 +76,19 @@ var (
 
 // DB is interface to job database
 type DB struct {
-	db *sql.DB
+	db     *sql.DB
+	driver string
 }
 
-func NewDB(db *sql.DB) *DB {
-	rdb := &DB{db: db}
+func NewDB(db *sql.DB, driver string) *DB {
+	rdb := &DB{db: db, driver: driver}
 	return rdb
 }
 
 func (j *DB) Prepare() error {
 	prepareJobStatements = make(map[string]*sql.Stmt)
 	for _, s := range prepareJobSQLs {
-		stmt, err := j.db.Prepare(s)
+		stmt, err := j.db.Prepare(dialect.Rebind(j.driver, s))
 		if err != nil {
 			return err
 		}
@@ -67,11 +104,11 @@ func (j *DB) Insert(tx *sql.Tx, job *types.Job) error {
 		t := job.ScheduleTime.UTC()
 		st = &t
 	}
-	res, err := tx.Exec(insertJob, job.RefID, job.Category, job.Metadata, st)
-	if err != nil {
-		return err
+	var tenant *string
+	if job.Tenant != "" {
+		tenant = &job.Tenant
 	}
-	id, err := res.LastInsertId()
+	id, err := dialect.Insert(tx, j.driver, insertJob, job.RefID, job.Category, job.Metadata, job.Priority, tenant, st)
 	if err != nil {
 		return err
 	}
@@ -90,11 +127,13 @@ func (j *DB) List() ([]types.Job, error) {
 	jobs := []types.Job{}
 	for rows.Next() {
 		job := types.Job{}
-		err = rows.Scan(&job.ID, &job.RefID, &job.Category, &job.Metadata, &job.RunningHost,
-			&job.CreateTime, &job.ScheduleTime, &job.StartTime, &job.LastSeenTime)
+		var tenant sql.NullString
+		err = rows.Scan(&job.ID, &job.RefID, &job.Category, &job.Metadata, &job.Priority, &tenant, &job.RunningHost,
+			&job.CreateTime, &job.ScheduleTime, &job.StartTime, &job.StopTime, &job.PauseTime, &job.LastSeenTime)
 		if err != nil {
 			return nil, err
 		}
+		job.Tenant = tenant.String
 
 		jobs = append(jobs, job)
 	}
@@ -102,17 +141,6 @@ func (j *DB) List() ([]types.Job, error) {
 	return jobs, nil
 }
 
-func (j *DB) GetUnStarted() (*types.Job, error) {
-	job := &types.Job{}
-	s := prepareJobStatements[getNotStartedJob]
-
-	err := s.QueryRowContext(context.Background()).Scan(&job.ID, &job.Category, &job.Metadata)
-	if err != nil && err == sql.ErrNoRows {
-		return nil, nil
-	}
-	return job, err
-}
-
 func (j *DB) ListActiveRunners() (map[string]types.Job, error) {
 	s := prepareJobStatements[listActiveRunners]
 
@@ -139,43 +167,216 @@ func (j *DB) ListActiveRunners() (map[string]types.Job, error) {
 	return runners, nil
 }
 
+// Acquire assigns the earliest job scheduled before scheduleTime to the runner. It returns nil if
+// there is no job. Each job is assigned to only one runner, even when runners acquire concurrently.
 func (j *DB) Acquire(runner string, scheduleTime time.Time) (*types.Job, error) {
+	for i := 0; i < maxAcquireAttempts; i++ {
+		job, err := j.tryAcquire(runner, scheduleTime)
+		if err != errJobTaken {
+			return job, err
+		}
+	}
+	// lost all races, runner will retry in next poll
+	return nil, nil
+}
+
+func (j *DB) tryAcquire(runner string, scheduleTime time.Time) (*types.Job, error) {
+	job, err := j.acquireTx(runner, scheduleTime)
+	if dialect.LockConflict(j.driver, err) {
+		return nil, errJobTaken
+	}
+	return job, err
+}
+
+func (j *DB) acquireTx(runner string, scheduleTime time.Time) (*types.Job, error) {
 	tx, err := j.db.Begin()
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback() // Rollback the transaction if an error occurs
 
-	getStmt, err := tx.Prepare(getNotStartedJob)
+	// lock the row so that others skip it, if DB supports
+	getStmt, err := tx.Prepare(dialect.Rebind(j.driver, getNotStartedJob+dialect.SkipLocked(j.driver)))
 	if err != nil {
 		return nil, err
 	}
 	defer getStmt.Close()
 
 	job := &types.Job{}
+	var tenant sql.NullString
 
-	err = getStmt.QueryRowContext(context.Background(), scheduleTime.UTC()).Scan(&job.ID, &job.Category, &job.Metadata, &job.ScheduleTime)
+	err = getStmt.QueryRowContext(context.Background(), scheduleTime.UTC()).Scan(&job.ID, &job.Category, &job.Metadata,
+		&job.Priority, &tenant, &job.ScheduleTime)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
+	job.Tenant = tenant.String
 
-	updateStmt, err := tx.Prepare(updateJobForRunner)
+	updateStmt, err := tx.Prepare(dialect.Rebind(j.driver, updateJobForRunner))
 	if err != nil {
 		return nil, err
 	}
 	defer updateStmt.Close()
 
-	_, err = updateStmt.Exec(runner, job.ID)
+	// only update if the job is still not started, in case others have taken it
+	res, err := updateStmt.Exec(runner, job.ID)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
 	if err != nil {
 		return nil, err
 	}
+	if n == 0 {
+		return nil, errJobTaken
+	}
 
 	return job, tx.Commit()
 }
 
+// Stop marks the job as requested to stop. The runner owning the job will end it gracefully,
+// and report the final status afterward.
+func (j *DB) Stop(id int) error {
+	s := prepareJobStatements[stopJob]
+	_, err := s.Exec(id)
+	return err
+}
+
+// Pause marks the job as requested to pause. The runner owning the job stops recording until it is
+// resumed. It returns false if the job is paused already.
+func (j *DB) Pause(id int) (bool, error) {
+	s := prepareJobStatements[pauseJob]
+	res, err := s.Exec(id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Resume clears the pause request of the job. It returns false if the job is not paused.
+func (j *DB) Resume(id int) (bool, error) {
+	s := prepareJobStatements[resumeJob]
+	res, err := s.Exec(id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UpdateMetadata replaces metadata of the job which is not started yet. It returns false if the job
+// has been started, in which case the runner records by the old metadata.
+func (j *DB) UpdateMetadata(tx *sql.Tx, id int, metadata string) (bool, error) {
+	res, err := tx.Exec(dialect.Rebind(j.driver, updateJobMetadata), metadata, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Heartbeat renews the lease of the job if it is still owned by the runner.
+// It returns false if the job is not running on the runner anymore.
+func (j *DB) Heartbeat(id int, runner string) (bool, error) {
+	s := prepareJobStatements[heartbeatJob]
+	res, err := s.Exec(id, runner)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ListExpired lists all running jobs whose runner is not seen since given time
+func (j *DB) ListExpired(before time.Time) ([]types.Job, error) {
+	s := prepareJobStatements[listExpiredJobs]
+
+	rows, err := s.QueryContext(context.Background(), before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []types.Job{}
+	for rows.Next() {
+		job := types.Job{}
+		err = rows.Scan(&job.ID, &job.RefID, &job.Category, &job.Metadata, &job.RunningHost,
+			&job.CreateTime, &job.StartTime, &job.StopTime, &job.LastSeenTime, &job.RequeueCount)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// Requeue puts the expired job back to queue, so another runner can pick it up. It returns
+// false if the job has been renewed or taken by others in the meantime.
+func (j *DB) Requeue(id int, runner string, before time.Time) (bool, error) {
+	s := prepareJobStatements[requeueJob]
+	res, err := s.Exec(id, runner, before.UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Retry puts the failed job back to queue, to be picked up after scheduleTime. It returns false if
+// the job is not owned by the runner anymore.
+func (j *DB) Retry(id int, runner string, scheduleTime time.Time) (bool, error) {
+	s := prepareJobStatements[retryJob]
+	res, err := s.Exec(scheduleTime.UTC(), id, runner)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// AddAttempt saves the ended run of the job into its attempt history
+func (j *DB) AddAttempt(id int, attempt *types.JobAttempt) error {
+	var detail *string
+	if attempt.Detail != "" {
+		detail = &attempt.Detail
+	}
+	s := prepareJobStatements[insertAttempt]
+	_, err := s.Exec(id, attempt.Runner, attempt.ExitCode, detail, attempt.StartTime)
+	return err
+}
+
+// ListAttempts returns attempt history of the job, from the oldest one
+func (j *DB) ListAttempts(id int) ([]types.JobAttempt, error) {
+	s := prepareJobStatements[listAttempts]
+	rows, err := s.QueryContext(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []types.JobAttempt{}
+	for rows.Next() {
+		var (
+			a              types.JobAttempt
+			runner, detail sql.NullString
+		)
+		err = rows.Scan(&runner, &a.ExitCode, &detail, &a.StartTime, &a.EndTime)
+		if err != nil {
+			return nil, err
+		}
+		a.Runner = runner.String
+		a.Detail = detail.String
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
 func (j *DB) CompleteAndArchive(id int64, exitCode *int) error {
 	tx, err := j.db.Begin()
 	if err != nil {
@@ -191,7 +392,7 @@ func (j *DB) CompleteAndArchive(id int64, exitCode *int) error {
 }
 
 func (j *DB) CompleteAndArchiveWithTx(tx *sql.Tx, id int64, exitCode *int) error {
-	archStmt, err := tx.Prepare(archiveJob)
+	archStmt, err := tx.Prepare(dialect.Rebind(j.driver, archiveJob))
 	if err != nil {
 		return err
 	}
@@ -202,7 +403,7 @@ func (j *DB) CompleteAndArchiveWithTx(tx *sql.Tx, id int64, exitCode *int) error
 		return err
 	}
 
-	removeStmt, err := tx.Prepare(removeJob)
+	removeStmt, err := tx.Prepare(dialect.Rebind(j.driver, removeJob))
 	if err != nil {
 		return err
 	}
@@ -220,21 +421,23 @@ func (j *DB) Get(id int) (*types.Job, error) {
 	defer tx.Rollback() // Rollback the transaction if an error occurs
 
 	job := &types.Job{ID: id}
-	stmt, err := tx.Prepare(getNotFinishJobByID)
+	stmt, err := tx.Prepare(dialect.Rebind(j.driver, getNotFinishJobByID))
 	if err != nil {
 		return nil, err
 	}
 
-	err = stmt.QueryRowContext(context.Background(), id).Scan(&job.RefID, &job.Category, &job.Metadata,
-		&job.RunningHost, &job.CreateTime, &job.StartTime, &job.ScheduleTime, &job.LastSeenTime)
+	var tenant sql.NullString
+	err = stmt.QueryRowContext(context.Background(), id).Scan(&job.RefID, &job.Category, &job.Metadata, &job.Priority,
+		&tenant, &job.RunningHost, &job.CreateTime, &job.StartTime, &job.ScheduleTime, &job.StopTime, &job.PauseTime, &job.LastSeenTime)
 	if err == nil {
+		job.Tenant = tenant.String
 		return job, tx.Commit()
 	} else if err != sql.ErrNoRows {
 		return nil, err
 	}
 
 	// not in queue, maybe finished, try search from archive
-	stmt, err = tx.Prepare(getArchivedJobByID)
+	stmt, err = tx.Prepare(dialect.Rebind(j.driver, getArchivedJobByID))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,327 @@
+package job
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/db/dbtest"
+	"github.com/leslie-wang/clusterd/common/db/migrate"
+	"github.com/leslie-wang/clusterd/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDB(t *testing.T, driver string) (*sql.DB, *DB) {
+	d := dbtest.Open(t, driver)
+	_, err := migrate.Up(d, driver)
+	require.Nil(t, err)
+
+	jdb := NewDB(d, driver)
+	require.Nil(t, jdb.Prepare())
+	return d, jdb
+}
+
+func insertTestJob(t *testing.T, d *sql.DB, jdb *DB) int {
+	return insertJobWith(t, d, jdb, &types.Job{})
+}
+
+func insertJobWith(t *testing.T, d *sql.DB, jdb *DB, job *types.Job) int {
+	tx, err := d.Begin()
+	require.Nil(t, err)
+	defer tx.Rollback()
+
+	st := time.Now().Add(-time.Second)
+	job.Category = types.CategoryRecord
+	job.Metadata = "{}"
+	job.ScheduleTime = &st
+	require.Nil(t, jdb.Insert(tx, job))
+	require.Nil(t, tx.Commit())
+	return job.ID
+}
+
+func TestJobLease(t *testing.T) {
+	for _, driver := range dbtest.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			testJobLease(t, driver)
+		})
+	}
+}
+
+func testJobLease(t *testing.T, driver string) {
+	d, jdb := newTestDB(t, driver)
+	id := insertTestJob(t, d, jdb)
+
+	job, err := jdb.Acquire("r1", time.Now())
+	require.Nil(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, id, job.ID)
+
+	owned, err := jdb.Heartbeat(id, "r2")
+	assert.Nil(t, err)
+	assert.False(t, owned)
+
+	owned, err = jdb.Heartbeat(id, "r1")
+	assert.Nil(t, err)
+	assert.True(t, owned)
+
+	// not expired yet
+	jobs, err := jdb.ListExpired(time.Now().Add(-time.Minute))
+	assert.Nil(t, err)
+	assert.Empty(t, jobs)
+
+	before := time.Now().Add(time.Minute)
+	jobs, err = jdb.ListExpired(before)
+	assert.Nil(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "r1", *jobs[0].RunningHost)
+
+	requeued, err := jdb.Requeue(id, "r1", before)
+	assert.Nil(t, err)
+	assert.True(t, requeued)
+
+	// requeue again is no-op because runner has been cleared
+	requeued, err = jdb.Requeue(id, "r1", before)
+	assert.Nil(t, err)
+	assert.False(t, requeued)
+
+	job, err = jdb.Get(id)
+	assert.Nil(t, err)
+	assert.Nil(t, job.RunningHost)
+	assert.Nil(t, job.StartTime)
+
+	job, err = jdb.Acquire("r2", time.Now())
+	require.Nil(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, id, job.ID)
+
+	jobs, err = jdb.ListExpired(before)
+	assert.Nil(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "r2", *jobs[0].RunningHost)
+	assert.Equal(t, 1, jobs[0].RequeueCount)
+}
+
+func TestJobArchive(t *testing.T) {
+	for _, driver := range dbtest.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			d, jdb := newTestDB(t, driver)
+			id := insertTestJob(t, d, jdb)
+			// ids are generated by DB
+			assert.NotEqual(t, id, insertTestJob(t, d, jdb))
+
+			job, err := jdb.Acquire("r1", time.Now())
+			require.Nil(t, err)
+			require.NotNil(t, job)
+			assert.Equal(t, id, job.ID)
+
+			exitCode := 1
+			require.Nil(t, jdb.CompleteAndArchive(int64(id), &exitCode))
+
+			job, err = jdb.Get(id)
+			require.Nil(t, err)
+			require.NotNil(t, job)
+			assert.Equal(t, "r1", *job.RunningHost)
+			assert.Equal(t, exitCode, *job.ExitCode)
+			assert.NotNil(t, job.EndTime)
+
+			job, err = jdb.Get(id + 100)
+			assert.Nil(t, err)
+			assert.Nil(t, job)
+		})
+	}
+}
+
+// TestJobAcquireConcurrently checks every job is acquired by exactly one runner. It is run against mysql and
+// postgres as well when dbtest is given them, where runners contend on SKIP LOCKED.
+func TestJobAcquireConcurrently(t *testing.T) {
+	const (
+		jobs    = 100
+		runners = 20
+	)
+
+	for _, driver := range dbtest.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			d, jdb := newTestDB(t, driver)
+			for i := 0; i < jobs; i++ {
+				insertTestJob(t, d, jdb)
+			}
+
+			var (
+				lock     sync.Mutex
+				assigned = map[int]string{}
+				wg       sync.WaitGroup
+			)
+			deadline := time.Now().Add(30 * time.Second)
+			for i := 0; i < runners; i++ {
+				wg.Add(1)
+				go func(runner string) {
+					defer wg.Done()
+					for time.Now().Before(deadline) {
+						job, err := jdb.Acquire(runner, time.Now())
+						if !assert.Nil(t, err) {
+							return
+						}
+
+						lock.Lock()
+						if job != nil {
+							prev, ok := assigned[job.ID]
+							assert.False(t, ok, "job %d is assigned to both %s and %s", job.ID, prev, runner)
+							assigned[job.ID] = runner
+						}
+						done := len(assigned) == jobs
+						lock.Unlock()
+						if done {
+							return
+						}
+					}
+				}(fmt.Sprintf("r%d", i))
+			}
+			wg.Wait()
+
+			require.Len(t, assigned, jobs)
+			for id, runner := range assigned {
+				job, err := jdb.Get(id)
+				require.Nil(t, err)
+				assert.Equal(t, runner, *job.RunningHost)
+			}
+		})
+	}
+}
+
+func TestJobAcquireOrder(t *testing.T) {
+	for _, driver := range dbtest.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			d, jdb := newTestDB(t, driver)
+
+			// burst from one tenant
+			var burst []int
+			for i := 0; i < 5; i++ {
+				burst = append(burst, insertJobWith(t, d, jdb, &types.Job{Tenant: "a.com/live"}))
+			}
+			other := insertJobWith(t, d, jdb, &types.Job{Tenant: "b.com/live"})
+			urgent := insertJobWith(t, d, jdb, &types.Job{Tenant: "a.com/live", Priority: 10})
+
+			acquire := func() int {
+				job, err := jdb.Acquire("r1", time.Now())
+				require.Nil(t, err)
+				require.NotNil(t, job)
+				return job.ID
+			}
+
+			// highest priority first, even though it is created last
+			assert.Equal(t, urgent, acquire())
+			// tenant without running job goes before the burst
+			assert.Equal(t, other, acquire())
+			// then the burst in order of creation
+			for _, id := range burst {
+				assert.Equal(t, id, acquire())
+			}
+
+			// jobs without tenant are one tenant, which doesn't go before the others once it is running
+			assert.Equal(t, insertJobWith(t, d, jdb, &types.Job{}), acquire())
+			untenanted := insertJobWith(t, d, jdb, &types.Job{})
+			other = insertJobWith(t, d, jdb, &types.Job{Tenant: "c.com/live"})
+			assert.Equal(t, other, acquire())
+			assert.Equal(t, untenanted, acquire())
+
+			job, err := jdb.Get(urgent)
+			require.Nil(t, err)
+			assert.Equal(t, 10, job.Priority)
+			assert.Equal(t, "a.com/live", job.Tenant)
+		})
+	}
+}
+
+func TestJobRetry(t *testing.T) {
+	for _, driver := range dbtest.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			d, jdb := newTestDB(t, driver)
+			id := insertTestJob(t, d, jdb)
+
+			job, err := jdb.Acquire("r1", time.Now())
+			require.Nil(t, err)
+			require.NotNil(t, job)
+			job, err = jdb.Get(id)
+			require.Nil(t, err)
+
+			require.Nil(t, jdb.AddAttempt(id, &types.JobAttempt{Runner: "r1", ExitCode: 1, Detail: "failed",
+				StartTime: job.StartTime}))
+
+			// not owner
+			retried, err := jdb.Retry(id, "r2", time.Now())
+			assert.Nil(t, err)
+			assert.False(t, retried)
+
+			retried, err = jdb.Retry(id, "r1", time.Now().Add(time.Hour))
+			assert.Nil(t, err)
+			assert.True(t, retried)
+
+			// backoff not passed yet
+			job, err = jdb.Acquire("r2", time.Now())
+			assert.Nil(t, err)
+			assert.Nil(t, job)
+
+			job, err = jdb.Acquire("r2", time.Now().Add(2*time.Hour))
+			require.Nil(t, err)
+			require.NotNil(t, job)
+			assert.Equal(t, id, job.ID)
+
+			require.Nil(t, jdb.AddAttempt(id, &types.JobAttempt{Runner: "r2"}))
+			exitCode := 0
+			require.Nil(t, jdb.CompleteAndArchive(int64(id), &exitCode))
+
+			attempts, err := jdb.ListAttempts(id)
+			require.Nil(t, err)
+			require.Len(t, attempts, 2)
+			assert.Equal(t, "r1", attempts[0].Runner)
+			assert.Equal(t, 1, attempts[0].ExitCode)
+			assert.Equal(t, "failed", attempts[0].Detail)
+			assert.NotNil(t, attempts[0].StartTime)
+			assert.Equal(t, "r2", attempts[1].Runner)
+			assert.Equal(t, 0, attempts[1].ExitCode)
+			assert.Nil(t, attempts[1].StartTime)
+		})
+	}
+}
+
+func TestJobPause(t *testing.T) {
+	for _, driver := range dbtest.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			d, jdb := newTestDB(t, driver)
+			id := insertTestJob(t, d, jdb)
+
+			resumed, err := jdb.Resume(id)
+			assert.Nil(t, err)
+			assert.False(t, resumed)
+
+			paused, err := jdb.Pause(id)
+			assert.Nil(t, err)
+			assert.True(t, paused)
+
+			// pause again is no-op
+			paused, err = jdb.Pause(id)
+			assert.Nil(t, err)
+			assert.False(t, paused)
+
+			job, err := jdb.Get(id)
+			require.Nil(t, err)
+			assert.NotNil(t, job.PauseTime)
+
+			jobs, err := jdb.List()
+			require.Nil(t, err)
+			require.Len(t, jobs, 1)
+			assert.NotNil(t, jobs[0].PauseTime)
+
+			resumed, err = jdb.Resume(id)
+			assert.Nil(t, err)
+			assert.True(t, resumed)
+
+			job, err = jdb.Get(id)
+			require.Nil(t, err)
+			assert.Nil(t, job.PauseTime)
+		})
+	}
+}
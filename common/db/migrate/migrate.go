@@ -0,0 +1,235 @@
+package migrate
+
+import (
+	"database/sql"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/leslie-wang/clusterd/common/db/dialect"
+	"github.com/leslie-wang/clusterd/migrations"
+	"github.com/pkg/errors"
+)
+
+const (
+	createMigrationTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER NOT NULL PRIMARY KEY,
+    applied_time TIMESTAMP NOT NULL
+)`
+	getVersion       = "select max(version) from schema_migrations"
+	insertVersion    = "insert into schema_migrations (version, applied_time) values (?, CURRENT_TIMESTAMP)"
+	checkLegacyTable = "select 1 from jobs limit 1"
+
+	// NoVersion is the version of a database which has no migration applied
+	NoVersion = -1
+)
+
+var (
+	// ErrSchemaTooNew is returned when the database is migrated by a newer release
+	ErrSchemaTooNew = errors.New("database schema is newer than this release supports")
+	// ErrNoBaseline is returned when the database has tables but no migration record
+	ErrNoBaseline = errors.New("database has no migration record, please run migrate with --baseline")
+)
+
+// Migration is one schema change of given version
+type Migration struct {
+	Version int
+	SQL     string
+}
+
+// Load returns all migrations of the driver in version order
+func Load(driver string) ([]Migration, error) {
+	entries, err := fs.ReadDir(migrations.FS, driver)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load %s migrations", driver)
+	}
+
+	var ms []Migration
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		version, err := strconv.Atoi(strings.TrimSuffix(name, ".sql"))
+		if err != nil {
+			return nil, errors.Errorf("invalid migration filename %s", name)
+		}
+		content, err := fs.ReadFile(migrations.FS, path.Join(driver, name))
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, Migration{Version: version, SQL: string(content)})
+	}
+
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	for i, m := range ms {
+		if m.Version != i {
+			return nil, errors.Errorf("missing %s migration %d", driver, i)
+		}
+	}
+	return ms, nil
+}
+
+// Latest returns the latest version the driver's migrations bring the schema to
+func Latest(driver string) (int, error) {
+	ms, err := Load(driver)
+	if err != nil {
+		return NoVersion, err
+	}
+	return len(ms) - 1, nil
+}
+
+// Current returns the version of the database, or NoVersion if nothing is applied
+func Current(db *sql.DB) (int, error) {
+	_, err := db.Exec(createMigrationTable)
+	if err != nil {
+		return NoVersion, err
+	}
+
+	var version sql.NullInt64
+	err = db.QueryRow(getVersion).Scan(&version)
+	if err != nil {
+		return NoVersion, err
+	}
+	if !version.Valid {
+		return NoVersion, nil
+	}
+	return int(version.Int64), nil
+}
+
+// Check returns error if the database is not at the latest version of the driver's migrations
+func Check(db *sql.DB, driver string) error {
+	current, err := Current(db)
+	if err != nil {
+		return err
+	}
+	latest, err := Latest(driver)
+	if err != nil {
+		return err
+	}
+
+	if current > latest {
+		return errors.Wrapf(ErrSchemaTooNew, "database version %d, supported version %d", current, latest)
+	}
+	if current < latest {
+		return errors.Errorf("database version %d is behind %d, please run migrate", current, latest)
+	}
+	return nil
+}
+
+// Up applies all pending migrations, and returns the versions applied
+func Up(db *sql.DB, driver string) ([]int, error) {
+	current, err := Current(db)
+	if err != nil {
+		return nil, err
+	}
+	ms, err := Load(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	if current >= len(ms) {
+		return nil, errors.Wrapf(ErrSchemaTooNew, "database version %d, supported version %d", current, len(ms)-1)
+	}
+	if current == NoVersion && hasLegacyTables(db) {
+		return nil, ErrNoBaseline
+	}
+
+	var applied []int
+	for _, m := range ms[current+1:] {
+		err = apply(db, driver, m)
+		if err != nil {
+			return applied, errors.Wrapf(err, "apply migration %d", m.Version)
+		}
+		applied = append(applied, m.Version)
+	}
+	return applied, nil
+}
+
+// Baseline records the database as being at given version without running any migration. It is
+// for databases whose schema were applied by hand before migrations were tracked.
+func Baseline(db *sql.DB, driver string, version int) error {
+	current, err := Current(db)
+	if err != nil {
+		return err
+	}
+	if current != NoVersion {
+		return errors.Errorf("database is already at version %d", current)
+	}
+
+	latest, err := Latest(driver)
+	if err != nil {
+		return err
+	}
+	if version < 0 || version > latest {
+		return errors.Errorf("baseline version must be between 0 and %d", latest)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for v := 0; v <= version; v++ {
+		_, err = tx.Exec(dialect.Rebind(driver, insertVersion), v)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func hasLegacyTables(db *sql.DB) bool {
+	rows, err := db.Query(checkLegacyTable)
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// apply runs the migration statement by statement in one transaction. DDL of mysql isn't transactional, so a
+// failed mysql migration may be left partially applied without its version recorded. Running it again skips the
+// statements whose tables, columns or indexes exist already, so mysql migrations must only add them.
+func apply(db *sql.DB, driver string, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmts := splitStatements(m.SQL)
+	for i, stmt := range stmts {
+		_, err = tx.Exec(stmt)
+		if err != nil && dialect.AlreadyApplied(driver, err) {
+			continue
+		}
+		if err != nil {
+			if !dialect.TransactionalDDL(driver) && i > 0 {
+				return errors.Wrapf(err, "%s (statements before it are applied, run migrate again once it is fixed)",
+					stmt)
+			}
+			return errors.Wrap(err, stmt)
+		}
+	}
+
+	_, err = tx.Exec(dialect.Rebind(driver, insertVersion), m.Version)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func splitStatements(content string) []string {
+	var stmts []string
+	for _, stmt := range strings.Split(content, ";") {
+		stmt = strings.TrimSpace(stmt)
+		if stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
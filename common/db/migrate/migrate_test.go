@@ -0,0 +1,114 @@
+package migrate
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/leslie-wang/clusterd/common/db/dbtest"
+	"github.com/leslie-wang/clusterd/common/db/dialect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUp(t *testing.T) {
+	dbtest.Run(t, testUp)
+}
+
+func testUp(t *testing.T, driver string) {
+	db := dbtest.Open(t, driver)
+
+	latest, err := Latest(driver)
+	require.Nil(t, err)
+
+	current, err := Current(db)
+	require.Nil(t, err)
+	assert.Equal(t, NoVersion, current)
+
+	applied, err := Up(db, driver)
+	require.Nil(t, err)
+	assert.Len(t, applied, latest+1)
+	assert.Nil(t, Check(db, driver))
+
+	// nothing pending
+	applied, err = Up(db, driver)
+	require.Nil(t, err)
+	assert.Empty(t, applied)
+
+	// database migrated by newer release
+	_, err = db.Exec(dialect.Rebind(driver, insertVersion), latest+1)
+	require.Nil(t, err)
+	assert.ErrorIs(t, Check(db, driver), ErrSchemaTooNew)
+	_, err = Up(db, driver)
+	assert.ErrorIs(t, err, ErrSchemaTooNew)
+}
+
+func TestBaseline(t *testing.T) {
+	dbtest.Run(t, testBaseline)
+}
+
+func testBaseline(t *testing.T, driver string) {
+	db := dbtest.Open(t, driver)
+
+	ms, err := Load(driver)
+	require.Nil(t, err)
+	// mysql runs one statement at a time
+	for _, stmt := range splitStatements(ms[0].SQL) {
+		_, err = db.Exec(stmt)
+		require.Nil(t, err)
+	}
+
+	// tables applied by hand
+	_, err = Up(db, driver)
+	assert.ErrorIs(t, err, ErrNoBaseline)
+
+	require.Nil(t, Baseline(db, driver, 0))
+	assert.NotNil(t, Baseline(db, driver, 0))
+
+	applied, err := Up(db, driver)
+	require.Nil(t, err)
+	assert.Equal(t, len(ms)-1, len(applied))
+	assert.Equal(t, 1, applied[0])
+}
+
+func TestSplitStatements(t *testing.T) {
+	stmts := splitStatements("ALTER TABLE jobs ADD COLUMN a INT;\n\nALTER TABLE jobs ADD COLUMN b INT;\n")
+	assert.Equal(t, []string{"ALTER TABLE jobs ADD COLUMN a INT", "ALTER TABLE jobs ADD COLUMN b INT"}, stmts)
+}
+
+// mysql migrations run in the database of the DSN, and can be applied again after partially applied. They are
+// applied to a real server by TestUp when CLUSTERD_TEST_MYSQL is set, and the mistakes mysql rejects are
+// checked here without one.
+var (
+	mysqlVarcharNoLength = regexp.MustCompile(`\bVARCHAR\b\s*[^(\s]`)
+	mysqlCreateTable     = regexp.MustCompile(`^CREATE TABLE IF NOT EXISTS (\w+)`)
+	mysqlAlterTable      = regexp.MustCompile(`^ALTER TABLE (\w+)`)
+)
+
+func TestMySQLMigrations(t *testing.T) {
+	ms, err := Load(dialect.MySQL)
+	require.Nil(t, err)
+	tables := map[string]int{}
+	for _, m := range ms {
+		for _, stmt := range splitStatements(m.SQL) {
+			upper := strings.ToUpper(stmt)
+			assert.False(t, mysqlVarcharNoLength.MatchString(upper), "migration %d: %s", m.Version, stmt)
+			if match := mysqlCreateTable.FindStringSubmatch(upper); match != nil {
+				prev, ok := tables[match[1]]
+				assert.False(t, ok, "migration %d creates table %s of migration %d", m.Version, match[1], prev)
+				tables[match[1]] = m.Version
+				assert.True(t, !strings.Contains(upper, " ID INT") || strings.Contains(upper, "AUTO_INCREMENT") ||
+					match[1] == "JOB_ARCHIVES", "migration %d: id of %s isn't auto increment", m.Version, match[1])
+			}
+			if match := mysqlAlterTable.FindStringSubmatch(upper); match != nil {
+				_, ok := tables[match[1]]
+				assert.True(t, ok, "migration %d alters table %s which isn't created", m.Version, match[1])
+			}
+			assert.False(t, strings.HasPrefix(upper, "USE "), "migration %d: %s", m.Version, stmt)
+			assert.False(t, strings.HasPrefix(upper, "CREATE DATABASE"), "migration %d: %s", m.Version, stmt)
+			assert.True(t, strings.HasPrefix(upper, "CREATE TABLE IF NOT EXISTS") ||
+				strings.HasPrefix(upper, "ALTER TABLE") || strings.HasPrefix(upper, "CREATE INDEX"),
+				"migration %d: %s", m.Version, stmt)
+		}
+	}
+}
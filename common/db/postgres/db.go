@@ -0,0 +1,50 @@
+package postgres
+
+import (
+	"database/sql"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/leslie-wang/clusterd/types"
+
+	_ "github.com/lib/pq"
+)
+
+// OpenDB open postgres db. Addr is host[:port], optionally followed by "?" and connection parameters
+// like sslmode=require.
+func OpenDB(cfg types.Config) (*sql.DB, error) {
+	host, rawQuery, _ := strings.Cut(cfg.Addr, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	if query.Get("sslmode") == "" {
+		query.Set("sslmode", "disable")
+	}
+	// timestamps are stored and compared in UTC like other drivers
+	if query.Get("timezone") == "" {
+		query.Set("timezone", "UTC")
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		Host:     host,
+		Path:     "/" + cfg.DBName,
+		RawQuery: query.Encode(),
+	}
+	if cfg.DBUser != "" {
+		u.User = url.UserPassword(cfg.DBUser, cfg.DBPass)
+	}
+
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	// TODO: tune this info, or put it into config file
+	db.SetConnMaxLifetime(3 * time.Minute)
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(10)
+	return db, nil
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/leslie-wang/clusterd/common/db/dialect"
 	"github.com/leslie-wang/clusterd/common/model"
 	"github.com/leslie-wang/clusterd/types"
 )
@@ -14,32 +15,44 @@ import (
 const (
 	insertRecordTemplate = "insert into record_templates (name, params, create_time) " +
 		" values(?, ?, CURRENT_TIMESTAMP)"
-	listRecordTemplates  = "select id, params, create_time from record_templates"
-	getRecordTemplate    = "select id, params, create_time from record_templates where id=?"
+	listRecordTemplates  = "select id, params, create_time, update_time from record_templates"
+	getRecordTemplate    = "select id, params, create_time, update_time from record_templates where id=?"
+	updateRecordTemplate = "update record_templates set name=?, params=?, update_time=CURRENT_TIMESTAMP where id=?"
 	removeRecordTemplate = "delete from record_templates where id=?"
 
 	insertRecordRule = "insert into record_rules (template_id, domain_name, app_name, stream_name, create_time)" +
 		" values(?, ?, ?, ?, CURRENT_TIMESTAMP)"
 	listRecordRules                   = "select template_id, domain_name, app_name, stream_name, create_time from record_rules"
 	removeRecordRuleByDomainAppStream = "delete from record_rules where domain_name=? and app_name=? and stream_name=?"
+	listRecordRulesByDomain           = "select template_id, domain_name, app_name, stream_name, create_time from record_rules" +
+		" where domain_name=?"
 
 	insertRecordTask = "insert into record_tasks (template_id, domain_name, app_name, stream_name, " +
-		" stream_type, start_time, end_time, source_url, store_path, create_time) " +
-		" values(?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)"
+		" stream_type, start_time, end_time, source_url, store_path, auto_record, create_time) " +
+		" values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)"
 	listRecordTasks = "select id, template_id, domain_name, app_name, stream_name, " +
 		" start_time, end_time from record_tasks"
 	removeRecordTask = "delete from record_tasks where id=?"
 	getRecordTask    = "select template_id, domain_name, app_name, stream_name, start_time, end_time from record_tasks where id=?"
+	// jobs not started yet, which record by the template
+	listWaitingJobsByTemplate = "select j.id, j.metadata from jobs as j inner join record_tasks as rt on j.ref_id=rt.id" +
+		" where rt.template_id=? and j.start_time is null"
+	// running or waiting jobs which are created by record rule for the stream
+	listAutoRecordJobs = "select j.id from jobs as j inner join record_tasks as rt on j.ref_id=rt.id" +
+		" where rt.auto_record=1 and rt.domain_name=? and rt.app_name=? and rt.stream_name=? and j.stop_time is null"
 
 	insertCallbackTemplate = "insert into record_cb_templates (name, description, callback_key, begin_url, end_url," +
 		" record_url, record_status_url, porn_censorship_url, stream_mix_url, push_exception_url, audio_audit_url," +
 		" snapshot_url, create_time) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)"
 	listCallbackTemplates = "select id, name, description, callback_key, begin_url, end_url," +
 		" record_url, record_status_url, porn_censorship_url, stream_mix_url, push_exception_url, audio_audit_url," +
-		" snapshot_url from record_cb_templates"
-	getCallbackTemplate = "select name, description, callback_key, begin_url, end_url," +
+		" snapshot_url, update_time from record_cb_templates"
+	getCallbackTemplate = "select id, name, description, callback_key, begin_url, end_url," +
 		" record_url, record_status_url, porn_censorship_url, stream_mix_url, push_exception_url, audio_audit_url," +
-		" snapshot_url from record_cb_templates where id=?"
+		" snapshot_url, update_time from record_cb_templates where id=?"
+	updateCallbackTemplate = "update record_cb_templates set name=?, description=?, callback_key=?, begin_url=?, end_url=?," +
+		" record_url=?, record_status_url=?, porn_censorship_url=?, stream_mix_url=?, push_exception_url=?," +
+		" audio_audit_url=?, snapshot_url=?, update_time=CURRENT_TIMESTAMP where id=?"
 	removeCallbackTemplate = "delete from record_cb_templates where id=?"
 
 	insertCallbackRule = "insert into record_cb_rules (template_id, domain_name, app_name, create_time)" +
@@ -48,23 +61,21 @@ const (
 	removeCallbackRuleByDomainAppStream = "delete from record_cb_rules where domain_name=? and app_name=?"
 	getCallbackRuleByDomainAndApp       = "select name, description, callback_key, begin_url, end_url, record_url," +
 		" record_status_url, porn_censorship_url, stream_mix_url, push_exception_url, audio_audit_url, snapshot_url" +
-		" from record_cb_templates inner join record_cb_rules as r where r.domain_name=? and r.app_name=?"
+		" from record_cb_templates as cb inner join record_cb_rules as r on cb.id=r.template_id" +
+		" where r.domain_name=? and r.app_name=?"
 	getCallbackRuleByRecordTaskID = "select cb.id, name, description, callback_key, begin_url, end_url, record_url," +
 		" record_status_url, porn_censorship_url, stream_mix_url, push_exception_url, audio_audit_url, snapshot_url" +
-		" from record_cb_templates as cb inner join record_cb_rules as r inner join record_tasks as rt" +
-		" on r.domain_name=rt.domain_name and r.app_name=rt.app_name" +
+		" from record_cb_templates as cb inner join record_cb_rules as r on cb.id=r.template_id" +
+		" inner join record_tasks as rt on r.domain_name=rt.domain_name and r.app_name=rt.app_name" +
 		" where rt.id=?"
 )
 
 var (
 	prepareRecordSQLs = []string{
-		insertRecordRule,
-		insertRecordTask,
-		insertRecordTemplate,
-		insertCallbackRule,
-		insertCallbackTemplate,
 		listRecordRules,
+		listRecordRulesByDomain,
 		listRecordTasks,
+		listAutoRecordJobs,
 		listRecordTemplates,
 		listCallbackRules,
 		listCallbackTemplates,
@@ -76,6 +87,7 @@ var (
 		getRecordTask,
 		getRecordTemplate,
 		getCallbackTemplate,
+		updateCallbackTemplate,
 		getCallbackRuleByDomainAndApp,
 		getCallbackRuleByRecordTaskID,
 	}
@@ -84,11 +96,12 @@ var (
 
 // DB is interface to record database
 type DB struct {
-	db *sql.DB
+	db     *sql.DB
+	driver string
 }
 
-func NewDB(db *sql.DB) *DB {
-	rdb := &DB{db: db}
+func NewDB(db *sql.DB, driver string) *DB {
+	rdb := &DB{db: db, driver: driver}
 	return rdb
 }
 
@@ -96,7 +109,7 @@ func NewDB(db *sql.DB) *DB {
 func (r *DB) Prepare() error {
 	prepareRecordStatements = make(map[string]*sql.Stmt)
 	for _, s := range prepareRecordSQLs {
-		stmt, err := r.db.Prepare(s)
+		stmt, err := r.db.Prepare(dialect.Rebind(r.driver, s))
 		if err != nil {
 			return err
 		}
@@ -106,16 +119,11 @@ func (r *DB) Prepare() error {
 }
 
 func (r *DB) InsertRecordTemplate(t *model.CreateLiveRecordTemplateRequestParams) (int64, error) {
-	s := prepareRecordStatements[insertRecordTemplate]
 	content, err := json.Marshal(t)
 	if err != nil {
 		return 0, err
 	}
-	res, err := s.Exec(t.TemplateName, string(content))
-	if err != nil {
-		return 0, err
-	}
-	return res.LastInsertId()
+	return dialect.Insert(r.db, r.driver, insertRecordTemplate, t.TemplateName, string(content))
 }
 
 func (r *DB) GetRecordTemplateByID(id int64) (*types.LiveRecordTemplate, error) {
@@ -126,7 +134,7 @@ func (r *DB) GetRecordTemplateByID(id int64) (*types.LiveRecordTemplate, error)
 		t      types.LiveRecordTemplate
 		tmpl   model.CreateLiveRecordTemplateRequestParams
 	)
-	err := s.QueryRow(id).Scan(&t.ID, &params, &t.CreateTime)
+	err := s.QueryRow(id).Scan(&t.ID, &params, &t.CreateTime, &t.UpdateTime)
 	if err != nil {
 		return nil, err
 	}
@@ -154,7 +162,7 @@ func (r *DB) ListRecordTemplates(ctx context.Context) ([]types.LiveRecordTemplat
 			t      types.LiveRecordTemplate
 			tmpl   model.CreateLiveRecordTemplateRequestParams
 		)
-		err = rows.Scan(&t.ID, &params, &t.CreateTime)
+		err = rows.Scan(&t.ID, &params, &t.CreateTime, &t.UpdateTime)
 		if err != nil {
 			return nil, err
 		}
@@ -170,6 +178,36 @@ func (r *DB) ListRecordTemplates(ctx context.Context) ([]types.LiveRecordTemplat
 	return tmpls, nil
 }
 
+// UpdateRecordTemplate replaces the template, and sets its update time
+func (r *DB) UpdateRecordTemplate(tx *sql.Tx, id int64, t *model.CreateLiveRecordTemplateRequestParams) error {
+	content, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(dialect.Rebind(r.driver, updateRecordTemplate), t.TemplateName, string(content), id)
+	return err
+}
+
+// ListWaitingJobsByTemplate lists jobs which record by the template, and are not started yet
+func (r *DB) ListWaitingJobsByTemplate(tx *sql.Tx, id int64) ([]types.Job, error) {
+	rows, err := tx.Query(dialect.Rebind(r.driver, listWaitingJobsByTemplate), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []types.Job
+	for rows.Next() {
+		var job types.Job
+		err = rows.Scan(&job.ID, &job.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
 func (r *DB) RemoveRecordTemplate(id int64) error {
 	s := prepareRecordStatements[removeRecordTemplate]
 	_, err := s.Exec(id)
@@ -177,12 +215,7 @@ func (r *DB) RemoveRecordTemplate(id int64) error {
 }
 
 func (r *DB) InsertRecordRule(ru *model.CreateLiveRecordRuleRequestParams) (int64, error) {
-	s := prepareRecordStatements[insertRecordRule]
-	res, err := s.Exec(ru.TemplateId, ru.DomainName, ru.AppName, ru.StreamName)
-	if err != nil {
-		return 0, err
-	}
-	return res.LastInsertId()
+	return dialect.Insert(r.db, r.driver, insertRecordRule, ru.TemplateId, ru.DomainName, ru.AppName, ru.StreamName)
 }
 
 func (r *DB) ListRecordRules(ctx context.Context) ([]*model.RuleInfo, error) {
@@ -213,13 +246,115 @@ func (r *DB) RemoveRecordRuleByDomainAppStream(domain, app, stream string) error
 	return err
 }
 
+// MatchRecordRule returns the most specific record rule of the stream, or nil if no rule matches. Empty or "*"
+// app and stream of the rule match any, and exact app is more specific than exact stream.
+func (r *DB) MatchRecordRule(ctx context.Context, domain, app, stream string) (*model.RuleInfo, error) {
+	s := prepareRecordStatements[listRecordRulesByDomain]
+
+	rows, err := s.QueryContext(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		matched *model.RuleInfo
+		score   = -1
+	)
+	for rows.Next() {
+		ru := &model.RuleInfo{}
+		err = rows.Scan(&ru.TemplateId, &ru.DomainName, &ru.AppName, &ru.StreamName, &ru.CreateTime)
+		if err != nil {
+			return nil, err
+		}
+
+		appExact, ok := matchRuleName(ru.AppName, app)
+		if !ok {
+			continue
+		}
+		streamExact, ok := matchRuleName(ru.StreamName, stream)
+		if !ok {
+			continue
+		}
+
+		sc := 0
+		if appExact {
+			sc += 2
+		}
+		if streamExact {
+			sc++
+		}
+		if sc > score {
+			matched, score = ru, sc
+		}
+	}
+	return matched, rows.Err()
+}
+
+// matchRuleName checks whether name of the rule matches the value, and whether it is exact match
+func matchRuleName(name *string, val string) (exact, ok bool) {
+	if name == nil || *name == "" || *name == "*" {
+		return false, true
+	}
+	return true, *name == val
+}
+
 func (r *DB) InsertRecordTask(tx *sql.Tx, t *types.LiveRecordTask) (int64, error) {
-	res, err := tx.Exec(insertRecordTask, t.TemplateId, t.DomainName, t.AppName, t.StreamName, t.StreamType, t.StartTime, t.EndTime,
-		t.RecordStreams[0].SourceURL, t.StorePath)
+	auto := 0
+	if t.AutoRecord {
+		auto = 1
+	}
+	return dialect.Insert(tx, r.driver, insertRecordTask, t.TemplateId, t.DomainName, t.AppName, t.StreamName, t.StreamType,
+		t.StartTime, t.EndTime, t.RecordStreams[0].SourceURL, t.StorePath, auto)
+}
+
+// ListAutoRecordJobs lists not stopped jobs which are created by record rule for the stream
+func (r *DB) ListAutoRecordJobs(ctx context.Context, domain, app, stream string) ([]int, error) {
+	s := prepareRecordStatements[listAutoRecordJobs]
+
+	rows, err := s.QueryContext(ctx, domain, app, stream)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetRecordTask returns the record task, or nil if it doesn't exist
+func (r *DB) GetRecordTask(id int64) (*model.RecordTask, error) {
+	s := prepareRecordStatements[getRecordTask]
+
+	t := &model.RecordTask{}
+	var startTime, endTime *time.Time
+	err := s.QueryRow(id).Scan(&t.TemplateId, &t.DomainName, &t.AppName, &t.StreamName, &startTime, &endTime)
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
-	return res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	if startTime != nil {
+		st := uint64(startTime.Unix())
+		t.StartTime = &st
+	}
+	if endTime != nil {
+		et := uint64(endTime.Unix())
+		t.EndTime = &et
+	}
+	idStr := strconv.FormatInt(id, 10)
+	t.TaskId = &idStr
+	return t, nil
 }
 
 func (r *DB) ListRecordTasks(ctx context.Context) ([]*model.RecordTask, error) {
@@ -263,34 +398,31 @@ func (r *DB) ListRecordTasks(ctx context.Context) ([]*model.RecordTask, error) {
 }
 
 func (r *DB) RemoveRecordTask(tx *sql.Tx, id int64) error {
-	_, err := tx.Exec(removeRecordTask, id)
+	_, err := tx.Exec(dialect.Rebind(r.driver, removeRecordTask), id)
 	return err
 }
 
 func (r *DB) InsertCallbackTemplate(t *model.CreateLiveCallbackTemplateRequestParams) (int64, error) {
-	s := prepareRecordStatements[insertCallbackTemplate]
-	res, err := s.Exec(t.TemplateName, t.Description, t.CallbackKey, t.StreamBeginNotifyUrl, t.StreamEndNotifyUrl,
+	return dialect.Insert(r.db, r.driver, insertCallbackTemplate, t.TemplateName, t.Description, t.CallbackKey, t.StreamBeginNotifyUrl, t.StreamEndNotifyUrl,
 		t.RecordNotifyUrl, t.RecordStatusNotifyUrl, t.PornCensorshipNotifyUrl, t.StreamMixNotifyUrl,
 		t.PushExceptionNotifyUrl, t.AudioAuditNotifyUrl, t.SnapshotNotifyUrl)
-	if err != nil {
-		return 0, err
-	}
-	return res.LastInsertId()
 }
 
 func (r *DB) GetCallbackTemplateByID(id int64) (*model.CallBackTemplateInfo, error) {
 	s := prepareRecordStatements[getCallbackTemplate]
 
 	var (
-		t model.CallBackTemplateInfo
+		t          model.CallBackTemplateInfo
+		updateTime *time.Time
 	)
 	err := s.QueryRow(id).Scan(&t.TemplateId, &t.TemplateName, &t.Description, &t.CallbackKey,
 		&t.StreamBeginNotifyUrl, &t.StreamEndNotifyUrl, &t.RecordNotifyUrl, &t.RecordStatusNotifyUrl,
 		&t.PornCensorshipNotifyUrl, &t.StreamMixNotifyUrl, &t.PushExceptionNotifyUrl, &t.AudioAuditNotifyUrl,
-		&t.SnapshotNotifyUrl)
+		&t.SnapshotNotifyUrl, &updateTime)
 	if err != nil {
 		return nil, err
 	}
+	t.UpdateTime = FormatTime(updateTime)
 	return &t, nil
 }
 
@@ -304,20 +436,33 @@ func (r *DB) ListCallbackTemplates(ctx context.Context) ([]*model.CallBackTempla
 
 	var tmpls []*model.CallBackTemplateInfo
 	for rows.Next() {
-		t := &model.CallBackTemplateInfo{}
+		var (
+			t          = &model.CallBackTemplateInfo{}
+			updateTime *time.Time
+		)
 		err = rows.Scan(&t.TemplateId, &t.TemplateName, &t.Description, &t.CallbackKey,
 			&t.StreamBeginNotifyUrl, &t.StreamEndNotifyUrl, &t.RecordNotifyUrl, &t.RecordStatusNotifyUrl,
 			&t.PornCensorshipNotifyUrl, &t.StreamMixNotifyUrl, &t.PushExceptionNotifyUrl, &t.AudioAuditNotifyUrl,
-			&t.SnapshotNotifyUrl)
+			&t.SnapshotNotifyUrl, &updateTime)
 		if err != nil {
 			return nil, err
 		}
+		t.UpdateTime = FormatTime(updateTime)
 
 		tmpls = append(tmpls, t)
 	}
 	return tmpls, nil
 }
 
+// UpdateCallbackTemplate replaces the template, and sets its update time
+func (r *DB) UpdateCallbackTemplate(id int64, t *model.CallBackTemplateInfo) error {
+	s := prepareRecordStatements[updateCallbackTemplate]
+	_, err := s.Exec(t.TemplateName, t.Description, t.CallbackKey, t.StreamBeginNotifyUrl, t.StreamEndNotifyUrl,
+		t.RecordNotifyUrl, t.RecordStatusNotifyUrl, t.PornCensorshipNotifyUrl, t.StreamMixNotifyUrl,
+		t.PushExceptionNotifyUrl, t.AudioAuditNotifyUrl, t.SnapshotNotifyUrl, id)
+	return err
+}
+
 func (r *DB) RemoveCallbackTemplate(id int64) error {
 	s := prepareRecordStatements[removeCallbackTemplate]
 	_, err := s.Exec(id)
@@ -325,12 +470,7 @@ func (r *DB) RemoveCallbackTemplate(id int64) error {
 }
 
 func (r *DB) InsertCallbackRule(ru *model.CreateLiveCallbackRuleRequestParams) (int64, error) {
-	s := prepareRecordStatements[insertCallbackRule]
-	res, err := s.Exec(ru.TemplateId, ru.DomainName, ru.AppName)
-	if err != nil {
-		return 0, err
-	}
-	return res.LastInsertId()
+	return dialect.Insert(r.db, r.driver, insertCallbackRule, ru.TemplateId, ru.DomainName, ru.AppName)
 }
 
 func (r *DB) ListCallbackRules(ctx context.Context) ([]*model.CallBackRuleInfo, error) {
@@ -374,3 +514,12 @@ func (r *DB) GetCallbackRuleByRecordTaskID(id int64) (*model.CallBackTemplateInf
 	}
 	return &t, err
 }
+
+// FormatTime formats the time as UTC in API response, or returns nil if it is not set
+func FormatTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.UTC().Format(time.RFC3339)
+	return &s
+}
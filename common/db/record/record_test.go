@@ -0,0 +1,266 @@
+package record
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/db/dbtest"
+	"github.com/leslie-wang/clusterd/common/db/job"
+	"github.com/leslie-wang/clusterd/common/db/migrate"
+	"github.com/leslie-wang/clusterd/common/model"
+	"github.com/leslie-wang/clusterd/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDB(t *testing.T, driver string) (*sql.DB, *DB) {
+	d := dbtest.Open(t, driver)
+	_, err := migrate.Up(d, driver)
+	require.Nil(t, err)
+
+	rdb := NewDB(d, driver)
+	require.Nil(t, rdb.Prepare())
+	return d, rdb
+}
+
+func insertTestRule(t *testing.T, rdb *DB, tmpl int64, domain, app, stream string) {
+	_, err := rdb.InsertRecordRule(&model.CreateLiveRecordRuleRequestParams{
+		TemplateId: &tmpl,
+		DomainName: &domain,
+		AppName:    &app,
+		StreamName: &stream,
+	})
+	require.Nil(t, err)
+}
+
+func TestMatchRecordRule(t *testing.T) {
+	dbtest.Run(t, testMatchRecordRule)
+}
+
+func testMatchRecordRule(t *testing.T, driver string) {
+	_, rdb := newTestDB(t, driver)
+	ctx := context.Background()
+
+	insertTestRule(t, rdb, 1, "live.com", "", "")
+	insertTestRule(t, rdb, 2, "live.com", "*", "cam")
+	insertTestRule(t, rdb, 3, "live.com", "sports", "")
+	insertTestRule(t, rdb, 4, "live.com", "sports", "cam")
+	insertTestRule(t, rdb, 5, "other.com", "sports", "cam")
+
+	tests := []struct {
+		app, stream string
+		template    int64
+	}{
+		{"news", "studio", 1},
+		{"news", "cam", 2},
+		{"sports", "studio", 3},
+		{"sports", "cam", 4},
+	}
+	for _, tt := range tests {
+		ru, err := rdb.MatchRecordRule(ctx, "live.com", tt.app, tt.stream)
+		require.Nil(t, err)
+		require.NotNil(t, ru, "%s/%s", tt.app, tt.stream)
+		assert.Equal(t, tt.template, *ru.TemplateId, "%s/%s", tt.app, tt.stream)
+	}
+
+	ru, err := rdb.MatchRecordRule(ctx, "nomatch.com", "sports", "cam")
+	require.Nil(t, err)
+	assert.Nil(t, ru)
+}
+
+func TestListAutoRecordJobs(t *testing.T) {
+	dbtest.Run(t, testListAutoRecordJobs)
+}
+
+func testListAutoRecordJobs(t *testing.T, driver string) {
+	d, rdb := newTestDB(t, driver)
+	jdb := job.NewDB(d, driver)
+	require.Nil(t, jdb.Prepare())
+	ctx := context.Background()
+
+	insertTask := func(stream string, auto bool) int {
+		domain, app, end := "live.com", "sports", uint64(time.Now().Add(time.Hour).Unix())
+		tx, err := d.Begin()
+		require.Nil(t, err)
+		defer tx.Rollback()
+
+		id, err := rdb.InsertRecordTask(tx, &types.LiveRecordTask{
+			CreateRecordTaskRequestParams: &model.CreateRecordTaskRequestParams{
+				DomainName:    &domain,
+				AppName:       &app,
+				StreamName:    &stream,
+				EndTime:       &end,
+				RecordStreams: []model.RecordInputStream{{SourceURL: "rtmp://live.com/sports/" + stream}},
+			},
+			AutoRecord: auto,
+		})
+		require.Nil(t, err)
+		j := &types.Job{RefID: id, Category: types.CategoryRecord}
+		require.Nil(t, jdb.Insert(tx, j))
+		require.Nil(t, tx.Commit())
+		return j.ID
+	}
+
+	auto := insertTask("cam", true)
+	insertTask("cam", false)
+	insertTask("studio", true)
+
+	ids, err := rdb.ListAutoRecordJobs(ctx, "live.com", "sports", "cam")
+	require.Nil(t, err)
+	assert.Equal(t, []int{auto}, ids)
+
+	// stopped job is not listed again
+	require.Nil(t, jdb.Stop(auto))
+	ids, err = rdb.ListAutoRecordJobs(ctx, "live.com", "sports", "cam")
+	require.Nil(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestUpdateRecordTemplate(t *testing.T) {
+	dbtest.Run(t, testUpdateRecordTemplate)
+}
+
+func testUpdateRecordTemplate(t *testing.T, driver string) {
+	d, rdb := newTestDB(t, driver)
+	jdb := job.NewDB(d, driver)
+	require.Nil(t, jdb.Prepare())
+
+	name, enable := "tmpl", int64(1)
+	id, err := rdb.InsertRecordTemplate(&model.CreateLiveRecordTemplateRequestParams{
+		TemplateName: &name,
+		HlsParam:     &model.RecordParam{Enable: &enable},
+	})
+	require.Nil(t, err)
+
+	tmpl, err := rdb.GetRecordTemplateByID(id)
+	require.Nil(t, err)
+	assert.Nil(t, tmpl.UpdateTime)
+
+	// one started job and one waiting job record by the template
+	tx, err := d.Begin()
+	require.Nil(t, err)
+	var jobIDs []int
+	for _, stream := range []string{"started", "waiting"} {
+		domain, end, templateID := "live.com", uint64(time.Now().Add(time.Hour).Unix()), uint64(id)
+		tid, err := rdb.InsertRecordTask(tx, &types.LiveRecordTask{
+			CreateRecordTaskRequestParams: &model.CreateRecordTaskRequestParams{
+				TemplateId:    &templateID,
+				DomainName:    &domain,
+				AppName:       &domain,
+				StreamName:    &stream,
+				EndTime:       &end,
+				RecordStreams: []model.RecordInputStream{{SourceURL: "rtmp://live.com/live/" + stream}},
+			},
+		})
+		require.Nil(t, err)
+		st := time.Now().Add(-time.Second)
+		j := &types.Job{RefID: tid, Category: types.CategoryRecord, Metadata: "{}", ScheduleTime: &st}
+		require.Nil(t, jdb.Insert(tx, j))
+		jobIDs = append(jobIDs, j.ID)
+	}
+	require.Nil(t, tx.Commit())
+	started, err := jdb.Acquire("runner", time.Now())
+	require.Nil(t, err)
+	require.NotNil(t, started)
+	assert.Equal(t, jobIDs[0], started.ID)
+
+	name = "modified"
+	tmpl.TemplateName = &name
+	tx, err = d.Begin()
+	require.Nil(t, err)
+	defer tx.Rollback()
+	require.Nil(t, rdb.UpdateRecordTemplate(tx, id, tmpl.CreateLiveRecordTemplateRequestParams))
+
+	jobs, err := rdb.ListWaitingJobsByTemplate(tx, id)
+	require.Nil(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, jobIDs[1], jobs[0].ID)
+
+	updated, err := jdb.UpdateMetadata(tx, jobs[0].ID, `{"Outputs":[]}`)
+	require.Nil(t, err)
+	assert.True(t, updated)
+	updated, err = jdb.UpdateMetadata(tx, started.ID, `{"Outputs":[]}`)
+	require.Nil(t, err)
+	assert.False(t, updated)
+	require.Nil(t, tx.Commit())
+
+	tmpl, err = rdb.GetRecordTemplateByID(id)
+	require.Nil(t, err)
+	assert.Equal(t, "modified", *tmpl.TemplateName)
+	assert.Equal(t, int64(1), *tmpl.HlsParam.Enable)
+	assert.NotNil(t, tmpl.UpdateTime)
+}
+
+func TestUpdateCallbackTemplate(t *testing.T) {
+	dbtest.Run(t, testUpdateCallbackTemplate)
+}
+
+func testUpdateCallbackTemplate(t *testing.T, driver string) {
+	_, rdb := newTestDB(t, driver)
+
+	name, begin, end := "cb", "http://localhost/begin", "http://localhost/end"
+	id, err := rdb.InsertCallbackTemplate(&model.CreateLiveCallbackTemplateRequestParams{
+		TemplateName:         &name,
+		StreamBeginNotifyUrl: &begin,
+		StreamEndNotifyUrl:   &end,
+	})
+	require.Nil(t, err)
+
+	tmpl, err := rdb.GetCallbackTemplateByID(id)
+	require.Nil(t, err)
+	assert.Equal(t, id, *tmpl.TemplateId)
+	assert.Nil(t, tmpl.UpdateTime)
+
+	begin = "http://localhost/begin2"
+	tmpl.StreamBeginNotifyUrl = &begin
+	require.Nil(t, rdb.UpdateCallbackTemplate(id, tmpl))
+
+	tmpl, err = rdb.GetCallbackTemplateByID(id)
+	require.Nil(t, err)
+	assert.Equal(t, "http://localhost/begin2", *tmpl.StreamBeginNotifyUrl)
+	assert.Equal(t, "http://localhost/end", *tmpl.StreamEndNotifyUrl)
+	assert.NotNil(t, tmpl.UpdateTime)
+
+	tmpls, err := rdb.ListCallbackTemplates(context.Background())
+	require.Nil(t, err)
+	require.Len(t, tmpls, 1)
+	assert.Equal(t, tmpl.UpdateTime, tmpls[0].UpdateTime)
+}
+
+func TestGetRecordTask(t *testing.T) {
+	dbtest.Run(t, testGetRecordTask)
+}
+
+func testGetRecordTask(t *testing.T, driver string) {
+	d, rdb := newTestDB(t, driver)
+
+	domain, app, stream, end := "live.com", "sports", "cam", uint64(time.Now().Add(time.Hour).Unix())
+	tx, err := d.Begin()
+	require.Nil(t, err)
+	id, err := rdb.InsertRecordTask(tx, &types.LiveRecordTask{
+		CreateRecordTaskRequestParams: &model.CreateRecordTaskRequestParams{
+			DomainName:    &domain,
+			AppName:       &app,
+			StreamName:    &stream,
+			EndTime:       &end,
+			RecordStreams: []model.RecordInputStream{{SourceURL: "rtmp://live.com/sports/cam"}},
+		},
+	})
+	require.Nil(t, err)
+	require.Nil(t, tx.Commit())
+
+	task, err := rdb.GetRecordTask(id)
+	require.Nil(t, err)
+	require.NotNil(t, task)
+	assert.Equal(t, domain, *task.DomainName)
+	assert.Equal(t, app, *task.AppName)
+	assert.Equal(t, stream, *task.StreamName)
+	require.NotNil(t, task.EndTime)
+	assert.Equal(t, end, *task.EndTime)
+
+	task, err = rdb.GetRecordTask(id + 1)
+	require.Nil(t, err)
+	assert.Nil(t, task)
+}
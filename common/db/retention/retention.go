@@ -0,0 +1,206 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/db/dialect"
+	"github.com/leslie-wang/clusterd/types"
+)
+
+const (
+	retentionColumns = "id, job_id, format, domain_name, size, expire_time, expired_time"
+
+	insertRetention = "insert into record_retentions (job_id, format, domain_name, size, expire_time, create_time)" +
+		" values(?, ?, ?, ?, ?, CURRENT_TIMESTAMP)"
+	// archived recordings whose retentions are not scheduled yet
+	listUnscheduledRecords = "select a.id, a.ref_id, a.metadata, a.end_time from job_archives as a where a.category=?" +
+		" and not exists (select 1 from record_retentions as r where r.job_id=a.id) order by a.id limit ? offset ?"
+	listDueRetentions = "select " + retentionColumns + " from record_retentions where expired_time is null" +
+		" and expire_time<=? order by expire_time, id limit ? offset ?"
+	listPendingRetentions = "select " + retentionColumns + " from record_retentions where expired_time is null" +
+		" and expire_time is not null order by expire_time, id limit ?"
+	listJobRetentions    = "select " + retentionColumns + " from record_retentions where job_id=? order by id"
+	markRetentionExpired = "update record_retentions set expired_time=? where id=? and expired_time is null"
+	markJobExpired       = "update record_retentions set expired_time=? where job_id=? and expired_time is null"
+	countUnexpired       = "select count(*) from record_retentions where job_id=? and expired_time is null"
+	sumDomainUsage       = "select coalesce(sum(size), 0) from record_retentions where domain_name=?" +
+		" and expired_time is null"
+)
+
+var (
+	prepareRetentionSQLs = []string{
+		listUnscheduledRecords,
+		listDueRetentions,
+		listPendingRetentions,
+		listJobRetentions,
+		markRetentionExpired,
+		markJobExpired,
+		countUnexpired,
+		sumDomainUsage,
+	}
+	prepareRetentionStatements map[string]*sql.Stmt
+)
+
+// DB is interface to retention database of recorded files
+type DB struct {
+	db     *sql.DB
+	driver string
+}
+
+func NewDB(db *sql.DB, driver string) *DB {
+	return &DB{db: db, driver: driver}
+}
+
+// Prepare prepares all statement
+func (r *DB) Prepare() error {
+	prepareRetentionStatements = make(map[string]*sql.Stmt)
+	for _, s := range prepareRetentionSQLs {
+		stmt, err := r.db.Prepare(dialect.Rebind(r.driver, s))
+		if err != nil {
+			return err
+		}
+		prepareRetentionStatements[s] = stmt
+	}
+	return nil
+}
+
+// ListUnscheduled lists archived record jobs which have no retention yet, after skipping the first offset ones
+func (r *DB) ListUnscheduled(offset, limit int) ([]types.Job, error) {
+	s := prepareRetentionStatements[listUnscheduledRecords]
+	rows, err := s.QueryContext(context.Background(), types.CategoryRecord, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []types.Job{}
+	for rows.Next() {
+		var (
+			j       types.Job
+			endTime time.Time
+		)
+		err = rows.Scan(&j.ID, &j.RefID, &j.Metadata, &endTime)
+		if err != nil {
+			return nil, err
+		}
+		j.Category = types.CategoryRecord
+		j.EndTime = &endTime
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// Insert saves retentions of one recording together, so that the recording is scheduled once
+func (r *DB) Insert(rs []types.RecordRetention) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(dialect.Rebind(r.driver, insertRetention))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, rt := range rs {
+		var expire interface{}
+		if rt.ExpireTime != nil {
+			expire = rt.ExpireTime.UTC()
+		}
+		_, err = stmt.Exec(rt.JobID, rt.Format, rt.DomainName, rt.Size, expire)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListDue lists retentions which expire at now, earliest first, after skipping the first offset ones
+func (r *DB) ListDue(now time.Time, offset, limit int) ([]types.RecordRetention, error) {
+	s := prepareRetentionStatements[listDueRetentions]
+	rows, err := s.QueryContext(context.Background(), now.UTC(), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanRetentions(rows)
+}
+
+// ListPending lists retentions which will expire, earliest first. Files kept forever are not listed.
+func (r *DB) ListPending(limit int) ([]types.RecordRetention, error) {
+	s := prepareRetentionStatements[listPendingRetentions]
+	rows, err := s.QueryContext(context.Background(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanRetentions(rows)
+}
+
+// ListByJob lists retentions of the recording
+func (r *DB) ListByJob(jobID int) ([]types.RecordRetention, error) {
+	s := prepareRetentionStatements[listJobRetentions]
+	rows, err := s.QueryContext(context.Background(), jobID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRetentions(rows)
+}
+
+// MarkExpired saves the time when files of the retention are removed
+func (r *DB) MarkExpired(id int64, t time.Time) error {
+	s := prepareRetentionStatements[markRetentionExpired]
+	_, err := s.Exec(t.UTC(), id)
+	return err
+}
+
+// MarkJobExpired saves the time when all files of the recording are removed, e.g. by DeleteRecordFile
+func (r *DB) MarkJobExpired(jobID int, t time.Time) error {
+	s := prepareRetentionStatements[markJobExpired]
+	_, err := s.Exec(t.UTC(), jobID)
+	return err
+}
+
+// CountUnexpired returns number of retentions of the recording whose files are not removed yet
+func (r *DB) CountUnexpired(jobID int) (int, error) {
+	s := prepareRetentionStatements[countUnexpired]
+	var n int
+	err := s.QueryRow(jobID).Scan(&n)
+	return n, err
+}
+
+// DomainUsage returns size of files of the domain which are not removed yet
+func (r *DB) DomainUsage(domain string) (int64, error) {
+	s := prepareRetentionStatements[sumDomainUsage]
+	var size int64
+	err := s.QueryRow(domain).Scan(&size)
+	return size, err
+}
+
+func scanRetentions(rows *sql.Rows) ([]types.RecordRetention, error) {
+	defer rows.Close()
+
+	rs := []types.RecordRetention{}
+	for rows.Next() {
+		var (
+			rt              types.RecordRetention
+			domain          sql.NullString
+			expire, expired sql.NullTime
+		)
+		err := rows.Scan(&rt.ID, &rt.JobID, &rt.Format, &domain, &rt.Size, &expire, &expired)
+		if err != nil {
+			return nil, err
+		}
+		rt.DomainName = domain.String
+		if expire.Valid {
+			rt.ExpireTime = &expire.Time
+		}
+		if expired.Valid {
+			rt.ExpiredTime = &expired.Time
+		}
+		rs = append(rs, rt)
+	}
+	return rs, rows.Err()
+}
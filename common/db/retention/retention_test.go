@@ -0,0 +1,140 @@
+package retention
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/db/dbtest"
+	"github.com/leslie-wang/clusterd/common/db/dialect"
+	"github.com/leslie-wang/clusterd/common/db/migrate"
+	"github.com/leslie-wang/clusterd/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDB(t *testing.T, driver string) (*DB, *sql.DB) {
+	d := dbtest.Open(t, driver)
+	_, err := migrate.Up(d, driver)
+	require.Nil(t, err)
+
+	rdb := NewDB(d, driver)
+	require.Nil(t, rdb.Prepare())
+	return rdb, d
+}
+
+func insertTestArchive(t *testing.T, d *sql.DB, driver string, id int, category types.JobCategory) {
+	_, err := d.Exec(dialect.Rebind(driver, "insert into job_archives (id, ref_id, category, metadata, create_time, end_time)"+
+		" values(?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)"), id, id, category, `{"StorageTime":60}`)
+	require.Nil(t, err)
+}
+
+func retentionIDs(rs []types.RecordRetention) []int64 {
+	ids := []int64{}
+	for _, r := range rs {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func TestListUnscheduled(t *testing.T) {
+	dbtest.Run(t, testListUnscheduled)
+}
+
+func testListUnscheduled(t *testing.T, driver string) {
+	rdb, d := newTestDB(t, driver)
+	insertTestArchive(t, d, driver, 1, types.CategoryRecord)
+	insertTestArchive(t, d, driver, 2, types.CategoryRecord+1)
+	insertTestArchive(t, d, driver, 3, types.CategoryRecord)
+
+	jobs, err := rdb.ListUnscheduled(0, 10)
+	require.Nil(t, err)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, 1, jobs[0].ID)
+	assert.Equal(t, `{"StorageTime":60}`, jobs[0].Metadata)
+	assert.NotNil(t, jobs[0].EndTime)
+	assert.Equal(t, 3, jobs[1].ID)
+
+	jobs, err = rdb.ListUnscheduled(1, 10)
+	require.Nil(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, 3, jobs[0].ID)
+
+	// recording kept forever is scheduled too, so it isn't listed again
+	require.Nil(t, rdb.Insert([]types.RecordRetention{{JobID: 1, Format: types.RecordFormatHLS}}))
+	jobs, err = rdb.ListUnscheduled(0, 10)
+	require.Nil(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, 3, jobs[0].ID)
+}
+
+func TestExpire(t *testing.T) {
+	dbtest.Run(t, testExpire)
+}
+
+func testExpire(t *testing.T, driver string) {
+	rdb, _ := newTestDB(t, driver)
+	now := time.Now()
+	hour, day := now.Add(time.Hour), now.Add(24*time.Hour)
+
+	require.Nil(t, rdb.Insert([]types.RecordRetention{
+		{JobID: 1, Format: types.RecordFormatHLS, DomainName: "a.com", Size: 100, ExpireTime: &day},
+		{JobID: 1, Format: types.RecordFormatFLV, DomainName: "a.com", Size: 10, ExpireTime: &hour},
+		{JobID: 2, Format: types.RecordFormatHLS, DomainName: "b.com", Size: 1000},
+	}))
+	usage := func(domain string) int64 {
+		size, err := rdb.DomainUsage(domain)
+		require.Nil(t, err)
+		return size
+	}
+	assert.Equal(t, int64(110), usage("a.com"))
+	assert.Equal(t, int64(1000), usage("b.com"))
+	assert.Equal(t, int64(0), usage("c.com"))
+
+	rs, err := rdb.ListByJob(1)
+	require.Nil(t, err)
+	require.Len(t, rs, 2)
+	hlsID, flvID := rs[0].ID, rs[1].ID
+	assert.Equal(t, types.RecordFormatFLV, rs[1].Format)
+	assert.Equal(t, "a.com", rs[1].DomainName)
+	assert.Equal(t, int64(10), rs[1].Size)
+	assert.WithinDuration(t, hour, *rs[1].ExpireTime, time.Second)
+	assert.Nil(t, rs[1].ExpiredTime)
+
+	rs, err = rdb.ListDue(now, 0, 10)
+	require.Nil(t, err)
+	assert.Empty(t, rs)
+	rs, err = rdb.ListDue(now.Add(2*time.Hour), 0, 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int64{flvID}, retentionIDs(rs))
+
+	// forever isn't pending
+	rs, err = rdb.ListPending(10)
+	require.Nil(t, err)
+	assert.Equal(t, []int64{flvID, hlsID}, retentionIDs(rs))
+
+	require.Nil(t, rdb.MarkExpired(flvID, now))
+	assert.Equal(t, int64(100), usage("a.com"))
+	rs, err = rdb.ListDue(now.Add(48*time.Hour), 0, 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int64{hlsID}, retentionIDs(rs))
+	rs, err = rdb.ListDue(now.Add(48*time.Hour), 1, 10)
+	require.Nil(t, err)
+	assert.Empty(t, rs)
+	n, err := rdb.CountUnexpired(1)
+	require.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	require.Nil(t, rdb.MarkJobExpired(1, now))
+	n, err = rdb.CountUnexpired(1)
+	require.Nil(t, err)
+	assert.Equal(t, 0, n)
+	rs, err = rdb.ListPending(10)
+	require.Nil(t, err)
+	assert.Empty(t, rs)
+
+	rs, err = rdb.ListByJob(1)
+	require.Nil(t, err)
+	require.NotNil(t, rs[0].ExpiredTime)
+	require.NotNil(t, rs[1].ExpiredTime)
+}
@@ -2,6 +2,8 @@ package sqlite
 
 import (
 	"database/sql"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/leslie-wang/clusterd/types"
@@ -11,7 +13,7 @@ import (
 
 // OpenDB open sqlite db
 func OpenDB(cfg types.Config) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", cfg.Addr)
+	db, err := sql.Open("sqlite3", dsn(cfg.Addr))
 	if err != nil {
 		return nil, err
 	}
@@ -22,3 +24,21 @@ func OpenDB(cfg types.Config) (*sql.DB, error) {
 	db.SetMaxIdleConns(10)
 	return db, nil
 }
+
+// dsn adds default parameters to addr. Sqlite allows only one writer, so transactions take the write
+// lock when begin, and wait for others to finish instead of failing immediately.
+func dsn(addr string) string {
+	filename, rawQuery, _ := strings.Cut(addr, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		// let driver report the bad parameters
+		return addr
+	}
+	if query.Get("_busy_timeout") == "" {
+		query.Set("_busy_timeout", "5000")
+	}
+	if query.Get("_txlock") == "" {
+		query.Set("_txlock", "immediate")
+	}
+	return filename + "?" + query.Encode()
+}
@@ -1,8 +1,10 @@
 package hls
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/bluenviron/gohlslib/pkg/playlist"
 	"github.com/leslie-wang/clusterd/common/logger"
@@ -14,7 +16,11 @@ func ParseMediaPlaylist(filename string) (*playlist.Media, error) {
 	if err != nil {
 		return nil, err
 	}
+	return UnmarshalMediaPlaylist(content)
+}
 
+// UnmarshalMediaPlaylist parses content of media playlist, e.g. which is read from the store
+func UnmarshalMediaPlaylist(content []byte) (*playlist.Media, error) {
 	pl, err := playlist.Unmarshal(content)
 	if err != nil {
 		return nil, err
@@ -35,22 +41,87 @@ func CalculateDuration(media *playlist.Media) uint64 {
 	return uint64(d * 1000)
 }
 
-func CalculateFileSize(dir string, media *playlist.Media, logger *logger.Logger) (size uint64) {
-	initFile := filepath.Join(dir, "init.mp4")
-	stat, err := os.Stat(initFile)
-	if err != nil {
-		logger.Warnf("stat %s: %s", initFile, err)
-	} else {
-		size += uint64(stat.Size())
-	}
+// MediaTimeRange returns wall clock time of the beginning and the end of the media playlist, by
+// EXT-X-PROGRAM-DATE-TIME of its segments. Segments without the tag follow the one before them, so the
+// end is after the last tagged segment even if the recording was paused in between. It returns false if
+// no segment is tagged.
+func MediaTimeRange(media *playlist.Media) (start, end time.Time, ok bool) {
+	var (
+		elapsed     time.Duration // since the beginning of the playlist
+		last        time.Time     // time of last tagged segment
+		lastElapsed time.Duration
+	)
 	for _, seg := range media.Segments {
-		fname := filepath.Join(dir, seg.URI)
-		stat, err = os.Stat(fname)
+		if seg.DateTime != nil {
+			if !ok {
+				start = seg.DateTime.Add(-elapsed)
+				ok = true
+			}
+			last, lastElapsed = *seg.DateTime, elapsed
+		}
+		elapsed += seg.Duration
+	}
+	if !ok {
+		return
+	}
+	return start, last.Add(elapsed - lastElapsed), true
+}
+
+// CalculateFileSize returns size of the media playlist's segments, including init segments of every attempt if
+// the recording is resumed. Each file is sized by size with its URI in the playlist.
+func CalculateFileSize(content []byte, size func(name string) (int64, error), logger *logger.Logger) (total uint64) {
+	segments := MediaSegments(content)
+	files := InitURIs(segments)
+	if len(files) == 0 {
+		files = []string{"init.mp4"}
+	}
+	for _, seg := range segments {
+		files = append(files, seg.URI)
+	}
+
+	for _, f := range files {
+		n, err := size(f)
 		if err != nil {
-			logger.Warnf("stat %s: %s", fname, err)
+			logger.Warnf("size %s: %s", f, err)
 			continue
 		}
-		size += uint64(stat.Size())
+		total += uint64(n)
 	}
 	return
 }
+
+// DirFileSize returns function which sizes files in dir, for CalculateFileSize
+func DirFileSize(dir string) func(name string) (int64, error) {
+	return func(name string) (int64, error) {
+		stat, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return 0, err
+		}
+		return stat.Size(), nil
+	}
+}
+
+// FinalizeMediaPlaylist appends EXT-X-ENDLIST to the media playlist if it is not there yet,
+// e.g. ffmpeg was killed before closing the playlist.
+func FinalizeMediaPlaylist(filename string) error {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(content, []byte("#EXT-X-ENDLIST")) {
+		return nil
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tag := "#EXT-X-ENDLIST\n"
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		tag = "\n" + tag
+	}
+	_, err = f.WriteString(tag)
+	return err
+}
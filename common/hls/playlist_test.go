@@ -0,0 +1,79 @@
+package hls
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const eventPlaylist = `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:6
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:6.000000,
+0.m4s
+#EXTINF:4.000000,
+1.m4s`
+
+func TestFinalizeMediaPlaylist(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "index.m3u8")
+	assert.Nil(t, os.WriteFile(fname, []byte(eventPlaylist), 0644))
+
+	assert.Nil(t, FinalizeMediaPlaylist(fname))
+	content, err := os.ReadFile(fname)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(string(content), "1.m4s\n#EXT-X-ENDLIST\n"))
+
+	// finalize again should not append another tag
+	assert.Nil(t, FinalizeMediaPlaylist(fname))
+	again, err := os.ReadFile(fname)
+	assert.Nil(t, err)
+	assert.Equal(t, content, again)
+
+	mediaPL, err := ParseMediaPlaylist(fname)
+	assert.Nil(t, err)
+	assert.True(t, mediaPL.Endlist)
+	assert.Equal(t, uint64(10000), CalculateDuration(mediaPL))
+}
+
+func TestMediaTimeRange(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "dl1.m3u8")
+	assert.Nil(t, os.WriteFile(fname, []byte(eventPlaylist), 0644))
+	mediaPL, err := ParseMediaPlaylist(fname)
+	assert.Nil(t, err)
+	_, _, ok := MediaTimeRange(mediaPL)
+	assert.False(t, ok)
+
+	// paused for a minute after the first two segments
+	assert.Nil(t, os.WriteFile(fname, []byte(`#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:6
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:6.000000,
+0.m4s
+#EXT-X-PROGRAM-DATE-TIME:2024-05-01T10:00:06.000Z
+#EXTINF:4.000000,
+1.m4s
+#EXT-X-DISCONTINUITY
+#EXT-X-PROGRAM-DATE-TIME:2024-05-01T10:01:10.000Z
+#EXTINF:6.000000,
+2.m4s
+#EXTINF:2.500000,
+3.m4s
+#EXT-X-ENDLIST
+`), 0644))
+	mediaPL, err = ParseMediaPlaylist(fname)
+	assert.Nil(t, err)
+	start, end, ok := MediaTimeRange(mediaPL)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), start.UTC())
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 1, 18, 500000000, time.UTC), end.UTC())
+}
@@ -0,0 +1,274 @@
+package hls
+
+import (
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	tagInf            = "#EXTINF:"
+	tagDiscontinuity  = "#EXT-X-DISCONTINUITY"
+	tagMap            = "#EXT-X-MAP:"
+	tagTargetDuration = "#EXT-X-TARGETDURATION:"
+	tagEndlist        = "#EXT-X-ENDLIST"
+)
+
+// segmentTags are tags applying to the segment after them. gohlslib keeps only one EXT-X-MAP and
+// drops EXT-X-DISCONTINUITY, so playlists of resumed recordings are handled as text.
+var segmentTags = []string{
+	tagInf,
+	tagDiscontinuity,
+	tagMap,
+	"#EXT-X-PROGRAM-DATE-TIME:",
+	"#EXT-X-BYTERANGE:",
+	"#EXT-X-GAP",
+	"#EXT-X-BITRATE:",
+	"#EXT-X-KEY:",
+}
+
+type textSegment struct {
+	tags []string
+	uri  string
+}
+
+// textPlaylist is a media playlist kept as lines, so that all tags survive rewriting
+type textPlaylist struct {
+	header   []string
+	segments []textSegment
+	endlist  bool
+}
+
+func isSegmentTag(line string) bool {
+	for _, t := range segmentTags {
+		if strings.HasPrefix(line, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTextPlaylist(content []byte) *textPlaylist {
+	pl := &textPlaylist{}
+	var tags []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case line == tagEndlist:
+			pl.endlist = true
+		case !strings.HasPrefix(line, "#"):
+			pl.segments = append(pl.segments, textSegment{tags: tags, uri: line})
+			tags = nil
+		case len(pl.segments) == 0 && len(tags) == 0 && !isSegmentTag(line):
+			pl.header = append(pl.header, line)
+		default:
+			tags = append(tags, line)
+		}
+	}
+	return pl
+}
+
+func (pl *textPlaylist) marshal() []byte {
+	b := strings.Builder{}
+	for _, line := range pl.header {
+		b.WriteString(line + "\n")
+	}
+	for _, s := range pl.segments {
+		for _, t := range s.tags {
+			b.WriteString(t + "\n")
+		}
+		b.WriteString(s.uri + "\n")
+	}
+	if pl.endlist {
+		b.WriteString(tagEndlist + "\n")
+	}
+	return []byte(b.String())
+}
+
+func (pl *textPlaylist) targetDuration() int {
+	for _, line := range pl.header {
+		if strings.HasPrefix(line, tagTargetDuration) {
+			d, _ := strconv.Atoi(line[len(tagTargetDuration):])
+			return d
+		}
+	}
+	return 0
+}
+
+func (pl *textPlaylist) setTargetDuration(d int) {
+	for i, line := range pl.header {
+		if strings.HasPrefix(line, tagTargetDuration) {
+			pl.header[i] = tagTargetDuration + strconv.Itoa(d)
+			return
+		}
+	}
+}
+
+// mapOf returns EXT-X-MAP tag in effect for the i-th segment
+func (pl *textPlaylist) mapOf(i int) string {
+	for ; i >= 0; i-- {
+		for _, t := range pl.segments[i].tags {
+			if strings.HasPrefix(t, tagMap) {
+				return t
+			}
+		}
+	}
+	return ""
+}
+
+// Segment is a media segment with the init segment which it is decoded by
+type Segment struct {
+	URI      string
+	Duration time.Duration
+	// Init is URI of EXT-X-MAP in effect, empty if there is none
+	Init string
+	// Discontinuity is set if EXT-X-DISCONTINUITY is before it, e.g. the recording is resumed
+	Discontinuity bool
+}
+
+// MediaSegments returns segments of the media playlist. Every attempt of a resumed recording has an init
+// segment of its own, which is given by EXT-X-MAP after EXT-X-DISCONTINUITY.
+func MediaSegments(content []byte) []Segment {
+	pl := parseTextPlaylist(content)
+	segments := make([]Segment, len(pl.segments))
+	init := ""
+	for i, s := range pl.segments {
+		segments[i].URI = s.uri
+		for _, t := range s.tags {
+			switch {
+			case strings.HasPrefix(t, tagInf):
+				sec, _, _ := strings.Cut(t[len(tagInf):], ",")
+				d, _ := strconv.ParseFloat(sec, 64)
+				segments[i].Duration = time.Duration(d * float64(time.Second))
+			case strings.HasPrefix(t, tagMap):
+				init = mapURI(t)
+			case t == tagDiscontinuity:
+				segments[i].Discontinuity = true
+			}
+		}
+		segments[i].Init = init
+	}
+	return segments
+}
+
+// InitURIs returns init segments of the segments in order, each only once
+func InitURIs(segments []Segment) []string {
+	var uris []string
+	seen := map[string]bool{}
+	for _, s := range segments {
+		if s.Init != "" && !seen[s.Init] {
+			seen[s.Init] = true
+			uris = append(uris, s.Init)
+		}
+	}
+	return uris
+}
+
+// mapURI returns URI attribute of EXT-X-MAP tag
+func mapURI(tag string) string {
+	for _, attr := range strings.Split(tag[len(tagMap):], ",") {
+		key, val, _ := strings.Cut(attr, "=")
+		if strings.TrimSpace(key) == "URI" {
+			return strings.Trim(val, `"`)
+		}
+	}
+	return ""
+}
+
+// SegmentCount returns number of segments in the media playlist
+func SegmentCount(content []byte) int {
+	return len(parseTextPlaylist(content).segments)
+}
+
+// DiscontinuityCount returns number of EXT-X-DISCONTINUITY in the media playlist
+func DiscontinuityCount(content []byte) int {
+	n := 0
+	for _, s := range parseTextPlaylist(content).segments {
+		for _, t := range s.tags {
+			if t == tagDiscontinuity {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// NextSegmentNumber returns number for the segment after the last one, whose names are "<number>.<ext>"
+func NextSegmentNumber(content []byte) int {
+	pl := parseTextPlaylist(content)
+	next := len(pl.segments)
+	for _, s := range pl.segments {
+		name := path.Base(s.uri)
+		n, err := strconv.Atoi(strings.TrimSuffix(name, path.Ext(name)))
+		if err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	return next
+}
+
+// AppendMediaPlaylist appends segments of next playlist to base one, separated by EXT-X-DISCONTINUITY.
+// It is used to continue an interrupted recording, whose new segments are written into next playlist.
+func AppendMediaPlaylist(base, next []byte) []byte {
+	basePL := parseTextPlaylist(base)
+	nextPL := parseTextPlaylist(next)
+
+	basePL.endlist = false
+	if len(nextPL.segments) == 0 {
+		return basePL.marshal()
+	}
+
+	if d := nextPL.targetDuration(); d > basePL.targetDuration() {
+		basePL.setTargetDuration(d)
+	}
+
+	first := nextPL.segments[0]
+	if len(first.tags) == 0 || first.tags[0] != tagDiscontinuity {
+		first.tags = append([]string{tagDiscontinuity}, first.tags...)
+	}
+	basePL.segments = append(basePL.segments, first)
+	basePL.segments = append(basePL.segments, nextPL.segments[1:]...)
+	basePL.endlist = nextPL.endlist
+	return basePL.marshal()
+}
+
+// TrimMediaPlaylist returns the media playlist with only segments after the one of lastURI. The
+// first remaining segment carries the EXT-X-MAP in effect, so the result plays on its own.
+func TrimMediaPlaylist(content []byte, lastURI string) ([]byte, error) {
+	pl := parseTextPlaylist(content)
+
+	start := -1
+	for i, s := range pl.segments {
+		if s.uri == lastURI {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 {
+		return nil, errors.Errorf("unable to find last segment URI '%s' in current playlist", lastURI)
+	}
+	if start == len(pl.segments) {
+		return nil, errors.Errorf("no new segment after '%s'", lastURI)
+	}
+
+	m := pl.mapOf(start)
+	pl.segments = pl.segments[start:]
+	first := &pl.segments[0]
+	if m != "" && !hasTag(first.tags, tagMap) {
+		first.tags = append([]string{m}, first.tags...)
+	}
+	return pl.marshal(), nil
+}
+
+func hasTag(tags []string, prefix string) bool {
+	for _, t := range tags {
+		if strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package hls
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const resumedPlaylist = `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:8
+#EXT-X-MEDIA-SEQUENCE:2
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-MAP:URI="init_1.mp4"
+#EXTINF:8.000000,
+2.m4s
+#EXTINF:2.000000,
+3.m4s
+#EXT-X-ENDLIST
+`
+
+func TestAppendMediaPlaylist(t *testing.T) {
+	base := []byte(eventPlaylist + "\n#EXT-X-ENDLIST\n")
+	assert.Equal(t, 2, NextSegmentNumber(base))
+	assert.Equal(t, 0, DiscontinuityCount(base))
+
+	merged := AppendMediaPlaylist(base, []byte(resumedPlaylist))
+	assert.Equal(t, `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:8
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:6.000000,
+0.m4s
+#EXTINF:4.000000,
+1.m4s
+#EXT-X-DISCONTINUITY
+#EXT-X-MAP:URI="init_1.mp4"
+#EXTINF:8.000000,
+2.m4s
+#EXTINF:2.000000,
+3.m4s
+#EXT-X-ENDLIST
+`, string(merged))
+	assert.Equal(t, 4, SegmentCount(merged))
+	assert.Equal(t, 4, NextSegmentNumber(merged))
+	assert.Equal(t, 1, DiscontinuityCount(merged))
+
+	// nothing is recorded after resume yet
+	assert.Equal(t, eventPlaylist+"\n", string(AppendMediaPlaylist(base, nil)))
+}
+
+func TestTrimMediaPlaylist(t *testing.T) {
+	merged := AppendMediaPlaylist([]byte(eventPlaylist), []byte(resumedPlaylist))
+
+	trimmed, err := TrimMediaPlaylist(merged, "0.m4s")
+	require.Nil(t, err)
+	assert.Equal(t, `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:8
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:4.000000,
+1.m4s
+#EXT-X-DISCONTINUITY
+#EXT-X-MAP:URI="init_1.mp4"
+#EXTINF:8.000000,
+2.m4s
+#EXTINF:2.000000,
+3.m4s
+#EXT-X-ENDLIST
+`, string(trimmed))
+
+	// map of the resumed segments is carried over
+	trimmed, err = TrimMediaPlaylist(merged, "2.m4s")
+	require.Nil(t, err)
+	assert.Equal(t, `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:8
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-MAP:URI="init_1.mp4"
+#EXTINF:2.000000,
+3.m4s
+#EXT-X-ENDLIST
+`, string(trimmed))
+
+	_, err = TrimMediaPlaylist(merged, "3.m4s")
+	assert.NotNil(t, err)
+	_, err = TrimMediaPlaylist(merged, "9.m4s")
+	assert.NotNil(t, err)
+}
+
+func TestMediaSegments(t *testing.T) {
+	merged := AppendMediaPlaylist([]byte(eventPlaylist), []byte(resumedPlaylist))
+	segments := MediaSegments(merged)
+	assert.Equal(t, []Segment{
+		{URI: "0.m4s", Duration: 6 * time.Second, Init: "init.mp4"},
+		{URI: "1.m4s", Duration: 4 * time.Second, Init: "init.mp4"},
+		{URI: "2.m4s", Duration: 8 * time.Second, Init: "init_1.mp4", Discontinuity: true},
+		{URI: "3.m4s", Duration: 2 * time.Second, Init: "init_1.mp4"},
+	}, segments)
+	assert.Equal(t, []string{"init.mp4", "init_1.mp4"}, InitURIs(segments))
+
+	// size of every attempt's init segment is counted
+	dir := t.TempDir()
+	for _, f := range []string{"init.mp4", "init_1.mp4", "0.m4s", "1.m4s", "2.m4s", "3.m4s"} {
+		require.Nil(t, os.WriteFile(filepath.Join(dir, f), []byte(f), 0644))
+	}
+	l := logger.New(1, 1, filepath.Join(dir, "test.log"))
+	assert.Equal(t, uint64(8+10+4*5), CalculateFileSize(merged, DirFileSize(dir), l))
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"encoding/json"
+
+	tchttp "github.com/leslie-wang/clusterd/common/http"
+)
+
+// ReplayCallbackRecord is an extension to DescribeCallbackRecordsList, which sends the callback listed by it
+// again, e.g. after the receiver recovers from the failure.
+
+// Predefined struct for user
+type ReplayCallbackRecordResponseParams struct {
+	// 唯一请求 ID，每次请求都会返回。定位问题时需要提供该次请求的 RequestId。
+	RequestId *string `json:"RequestId,omitempty" name:"RequestId"`
+}
+
+type ReplayCallbackRecordResponse struct {
+	*tchttp.BaseResponse
+	Response *ReplayCallbackRecordResponseParams `json:"Response"`
+}
+
+func (r *ReplayCallbackRecordResponse) ToJsonString() string {
+	b, _ := json.Marshal(r)
+	return string(b)
+}
+
+// FromJsonString It is highly **NOT** recommended to use this function
+// because it has no param check, nor strict type check
+func (r *ReplayCallbackRecordResponse) FromJsonString(s string) error {
+	return json.Unmarshal([]byte(s), &r)
+}
@@ -597,6 +597,9 @@ type CallBackTemplateInfo struct {
 	// 音频审核回调 URL。
 	// 注意：此字段可能返回 null，表示取不到有效值。
 	AudioAuditNotifyUrl *string `json:"AudioAuditNotifyUrl,omitempty" name:"AudioAuditNotifyUrl"`
+
+	// 模板修改时间。
+	UpdateTime *string `json:"UpdateTime,omitempty" name:"UpdateTime"`
 }
 
 type CallbackEventInfo struct {
@@ -628,6 +631,25 @@ type CallbackEventInfo struct {
 
 	// 流名称
 	StreamId *string `json:"StreamId,omitempty" name:"StreamId"`
+
+	// 回调记录 ID，用于重发回调。
+	CallbackId *int64 `json:"CallbackId,omitempty" name:"CallbackId"`
+
+	// 录制会话 ID，同一会话的回调按顺序发送。
+	SessionId *string `json:"SessionId,omitempty" name:"SessionId"`
+
+	// 回调 URL。
+	Url *string `json:"Url,omitempty" name:"Url"`
+
+	// 发送状态。
+	// 0：等待发送，1：发送成功，2：发送失败。
+	Status *uint64 `json:"Status,omitempty" name:"Status"`
+
+	// 已发送次数。
+	Attempts *uint64 `json:"Attempts,omitempty" name:"Attempts"`
+
+	// 下次发送时间，仅等待发送的回调有效。
+	NextTime *string `json:"NextTime,omitempty" name:"NextTime"`
 }
 
 // Predefined struct for user
@@ -3300,6 +3322,15 @@ type CreateRecordTaskRequestParams struct {
 	Mp4FileDuration    uint                `json:"Mp4FileDuration,omitempty" name:"Mp4FileDuration"`
 	HlsSegmentDuration uint                `json:"HlsSegmentDuration,omitempty" name:"HlsSegmentDuration"`
 	RecordTimeout      string              `json:"RecordTimeout,omitempty" name:"RecordTimeout"`
+	Priority           int                 `json:"Priority,omitempty" name:"Priority"`
+
+	// retry policy when recording fails. no retry if RetryMaxAttempts is 0
+	RetryMaxAttempts int    `json:"RetryMaxAttempts,omitempty" name:"RetryMaxAttempts"`
+	RetryBackoff     string `json:"RetryBackoff,omitempty" name:"RetryBackoff"`
+	RetryWindow      string `json:"RetryWindow,omitempty" name:"RetryWindow"`
+
+	// seconds to keep recorded files of all formats, overrides StorageTime of the template. 0 keeps the template's
+	StorageTime int64 `json:"StorageTime,omitempty" name:"StorageTime"`
 }
 
 type CreateRecordTaskRequest struct {
@@ -12073,6 +12104,8 @@ type ModifyLiveCallbackTemplateRequestParams struct {
 	// 录制回调 URL。
 	RecordNotifyUrl *string `json:"RecordNotifyUrl,omitempty" name:"RecordNotifyUrl"`
 
+	RecordStatusNotifyUrl *string `json:"RecordStatusNotifyUrl,omitempty" name:"RecordStatusNotifyUrl"`
+
 	// 截图回调 URL。
 	SnapshotNotifyUrl *string `json:"SnapshotNotifyUrl,omitempty" name:"SnapshotNotifyUrl"`
 
@@ -12083,6 +12116,9 @@ type ModifyLiveCallbackTemplateRequestParams struct {
 	// [事件消息通知](/document/product/267/32744)。
 	CallbackKey *string `json:"CallbackKey,omitempty" name:"CallbackKey"`
 
+	// 参数已弃用。
+	StreamMixNotifyUrl *string `json:"StreamMixNotifyUrl,omitempty" name:"StreamMixNotifyUrl"`
+
 	// 推流异常回调 URL。
 	PushExceptionNotifyUrl *string `json:"PushExceptionNotifyUrl,omitempty" name:"PushExceptionNotifyUrl"`
 
@@ -14665,6 +14701,9 @@ type RecordTemplateInfo struct {
 	// FLV 录制定制参数。
 	// 注意：此字段可能返回 null，表示取不到有效值。
 	FlvSpecialParam *FlvSpecialParam `json:"FlvSpecialParam,omitempty" name:"FlvSpecialParam"`
+
+	// 模板修改时间。
+	UpdateTime *string `json:"UpdateTime,omitempty" name:"UpdateTime"`
 }
 
 type RefererAuthConfig struct {
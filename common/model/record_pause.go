@@ -0,0 +1,54 @@
+package model
+
+import (
+	"encoding/json"
+
+	tchttp "github.com/leslie-wang/clusterd/common/http"
+)
+
+// PauseRecordTask and ResumeRecordTask are extensions to the record task API, which cut breaks out
+// of a long recording without ending it.
+
+// Predefined struct for user
+type PauseRecordTaskResponseParams struct {
+	// 唯一请求 ID，每次请求都会返回。定位问题时需要提供该次请求的 RequestId。
+	RequestId *string `json:"RequestId,omitempty" name:"RequestId"`
+}
+
+type PauseRecordTaskResponse struct {
+	*tchttp.BaseResponse
+	Response *PauseRecordTaskResponseParams `json:"Response"`
+}
+
+func (r *PauseRecordTaskResponse) ToJsonString() string {
+	b, _ := json.Marshal(r)
+	return string(b)
+}
+
+// FromJsonString It is highly **NOT** recommended to use this function
+// because it has no param check, nor strict type check
+func (r *PauseRecordTaskResponse) FromJsonString(s string) error {
+	return json.Unmarshal([]byte(s), &r)
+}
+
+// Predefined struct for user
+type ResumeRecordTaskResponseParams struct {
+	// 唯一请求 ID，每次请求都会返回。定位问题时需要提供该次请求的 RequestId。
+	RequestId *string `json:"RequestId,omitempty" name:"RequestId"`
+}
+
+type ResumeRecordTaskResponse struct {
+	*tchttp.BaseResponse
+	Response *ResumeRecordTaskResponseParams `json:"Response"`
+}
+
+func (r *ResumeRecordTaskResponse) ToJsonString() string {
+	b, _ := json.Marshal(r)
+	return string(b)
+}
+
+// FromJsonString It is highly **NOT** recommended to use this function
+// because it has no param check, nor strict type check
+func (r *ResumeRecordTaskResponse) FromJsonString(s string) error {
+	return json.Unmarshal([]byte(s), &r)
+}
@@ -1,13 +1,24 @@
 package mp4processor
 
 import (
+	"io"
 	"os"
 
 	"github.com/Eyevinn/mp4ff/mp4"
 )
 
 func RewriteDuration(ifilename string, ofile *os.File, audioDuration, videoDuration uint64) error {
-	ifile, err := mp4.ReadMP4File(ifilename)
+	f, err := os.Open(ifilename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return RewriteInitDuration(f, ofile, audioDuration, videoDuration)
+}
+
+// RewriteInitDuration writes the init segment read from r into w, with duration of the whole recording
+func RewriteInitDuration(r io.Reader, w io.Writer, audioDuration, videoDuration uint64) error {
+	ifile, err := mp4.DecodeFile(r)
 	if err != nil {
 		return err
 	}
@@ -46,5 +57,5 @@ func RewriteDuration(ifilename string, ofile *os.File, audioDuration, videoDurat
 		}
 	}
 
-	return ifile.Encode(ofile)
+	return ifile.Encode(w)
 }
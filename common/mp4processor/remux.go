@@ -0,0 +1,594 @@
+package mp4processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// Progressive is a progressive mp4 remuxed from fragmented mp4 segments. Only the header, i.e. ftyp, moov and
+// header of mdat, is built in memory. Sample data is read from the segments by chunks, e.g. by concatenating
+// them after the header with storage.ConcatReader.
+type Progressive struct {
+	Header []byte
+	Chunks []Chunk // sample data in mdat, in order
+}
+
+// Chunk is sample data which is contiguous in one segment
+type Chunk struct {
+	Segment int   // index of the segment
+	Offset  int64 // offset of the data in the segment
+	Size    int64
+}
+
+// remuxSample is a sample of a track. Its data is located by the chunk which it is in.
+type remuxSample struct {
+	dur, size uint32
+	cto       int32
+	sync      bool
+}
+
+// remuxChunk is a trun of one track, whose samples are contiguous in the segment
+type remuxChunk struct {
+	track   int
+	segment int
+	offset  int64 // offset of the first sample in the segment
+	first   int   // index of the first sample in the track
+	count   int
+	desc    uint32 // index of sample description in stsd, 1-based
+}
+
+// Segment is a fragmented mp4 segment, which is initialized by the init segment at index Init. It is opened
+// only when it is read, and closed before the next one is opened, so that a long recording doesn't hold all of
+// its segments open.
+type Segment struct {
+	Init int
+	Open func() (io.ReadSeekCloser, error)
+}
+
+// remuxInit is an init segment, whose tracks are mapped to the tracks of the first init segment
+type remuxInit struct {
+	tracks map[uint32]*initTrack // <track id, track>
+}
+
+type initTrack struct {
+	index     int // of the remuxed track
+	trex      *mp4.TrexBox
+	timescale uint32
+	descs     []uint32 // sample description indexes in the remuxed track, of the ones in this init
+}
+
+type remuxTrack struct {
+	trak      *mp4.TrakBox
+	samples   []remuxSample
+	keyframed bool // has non-sync samples, e.g. video
+	lo, hi    int  // samples within [lo, hi) are kept
+
+	chunkOffsets []uint64 // offsets of kept chunks in mdat
+	chunkCounts  []uint32 // number of kept samples of each chunk
+	chunkDescs   []uint32 // sample description of each chunk
+}
+
+// Remux remuxes fragmented mp4 segments into a progressive mp4 whose moov is in front of mdat, so that it is
+// seekable and plays while downloading. Only samples within [start, end) since the beginning of the first segment
+// are kept, end 0 means the end of the last segment. The range is extended to the keyframe at or before start, and
+// the one at or after end, so that it can be decoded from the beginning.
+//
+// Every segment is read by its init segment. A recording resumed by a new ffmpeg has a new init segment, whose
+// tracks may have other ids, timescales or codec parameters. They are mapped to the tracks of the first init
+// segment by handler type, and samples are rescaled to its timescales. Timestamps of the segments are not used,
+// so the resumed recording plays continuously though its timestamps are reset.
+func Remux(inits []io.Reader, segments []Segment, start, end time.Duration) (*Progressive, error) {
+	var (
+		mvhd   *mp4.MvhdBox
+		tracks []*remuxTrack
+		rinits = make([]*remuxInit, len(inits))
+	)
+	for i, init := range inits {
+		initFile, err := mp4.DecodeFile(init)
+		if err != nil {
+			return nil, fmt.Errorf("read init segment %d: %w", i+1, err)
+		}
+		if initFile.Moov == nil || initFile.Moov.Mvex == nil {
+			return nil, fmt.Errorf("init segment %d is not an init segment of fragmented mp4", i+1)
+		}
+		if i == 0 {
+			mvhd = initFile.Moov.Mvhd
+			for _, trak := range initFile.Moov.Traks {
+				tracks = append(tracks, &remuxTrack{trak: trak})
+			}
+		}
+		rinits[i], err = mapInit(initFile.Moov, tracks)
+		if err != nil {
+			return nil, fmt.Errorf("init segment %d: %w", i+1, err)
+		}
+	}
+	if len(tracks) == 0 {
+		return nil, errors.New("no init segment")
+	}
+
+	var (
+		chunks []remuxChunk
+		err    error
+	)
+	for i, seg := range segments {
+		if seg.Init < 0 || seg.Init >= len(rinits) {
+			return nil, fmt.Errorf("segment %d has no init segment %d", i+1, seg.Init+1)
+		}
+		chunks, err = readSegmentOf(chunks, i, seg, tracks, rinits[seg.Init])
+		if err != nil {
+			return nil, fmt.Errorf("read segment %d: %w", i+1, err)
+		}
+	}
+
+	trim(tracks, start, end)
+
+	p := &Progressive{}
+	var mdatSize uint64
+	for _, c := range chunks {
+		t := tracks[c.track]
+		lo, hi := max(c.first, t.lo), min(c.first+c.count, t.hi)
+		if lo >= hi {
+			continue
+		}
+		offset := c.offset
+		for i := c.first; i < lo; i++ {
+			offset += int64(t.samples[i].size)
+		}
+		var size int64
+		for i := lo; i < hi; i++ {
+			size += int64(t.samples[i].size)
+		}
+
+		t.chunkOffsets = append(t.chunkOffsets, mdatSize)
+		t.chunkCounts = append(t.chunkCounts, uint32(hi-lo))
+		t.chunkDescs = append(t.chunkDescs, c.desc)
+		mdatSize += uint64(size)
+
+		// samples of tracks are usually interleaved in the same segment, so they are copied together
+		if n := len(p.Chunks); n > 0 && p.Chunks[n-1].Segment == c.segment &&
+			p.Chunks[n-1].Offset+p.Chunks[n-1].Size == offset {
+			p.Chunks[n-1].Size += size
+			continue
+		}
+		p.Chunks = append(p.Chunks, Chunk{Segment: c.segment, Offset: offset, Size: size})
+	}
+	if len(p.Chunks) == 0 {
+		return nil, errors.New("no sample within the time range")
+	}
+
+	p.Header, err = progressiveHeader(mvhd, tracks, mdatSize)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// mapInit maps tracks of the init segment to the remuxed tracks, the n-th track of a handler type to the n-th
+// remuxed one of the same type. Its sample descriptions are added to the remuxed track unless they are there.
+func mapInit(moov *mp4.MoovBox, tracks []*remuxTrack) (*remuxInit, error) {
+	if len(moov.Traks) != len(tracks) {
+		return nil, fmt.Errorf("%d tracks instead of %d", len(moov.Traks), len(tracks))
+	}
+
+	ri := &remuxInit{tracks: map[uint32]*initTrack{}}
+	mapped := make([]bool, len(tracks))
+	for _, trak := range moov.Traks {
+		index := -1
+		for i, t := range tracks {
+			if !mapped[i] && t.trak.Mdia.Hdlr.HandlerType == trak.Mdia.Hdlr.HandlerType {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("no track to map %s track %d", trak.Mdia.Hdlr.HandlerType, trak.Tkhd.TrackID)
+		}
+		mapped[index] = true
+
+		it := &initTrack{index: index, timescale: trak.Mdia.Mdhd.Timescale}
+		for _, trex := range moov.Mvex.Trexs {
+			if trex.TrackID == trak.Tkhd.TrackID {
+				it.trex = trex
+			}
+		}
+		for _, entry := range trak.Mdia.Minf.Stbl.Stsd.Children {
+			desc, err := tracks[index].addSampleDescription(entry)
+			if err != nil {
+				return nil, err
+			}
+			it.descs = append(it.descs, desc)
+		}
+		ri.tracks[trak.Tkhd.TrackID] = it
+	}
+	return ri, nil
+}
+
+// addSampleDescription returns index of the sample entry in stsd of the track, it is added if not found
+func (t *remuxTrack) addSampleDescription(entry mp4.Box) (uint32, error) {
+	encoded, err := encodeBox(entry)
+	if err != nil {
+		return 0, err
+	}
+	stsd := t.trak.Mdia.Minf.Stbl.Stsd
+	for i, c := range stsd.Children {
+		existing, err := encodeBox(c)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(existing, encoded) {
+			return uint32(i + 1), nil
+		}
+	}
+	stsd.AddChild(entry)
+	return uint32(len(stsd.Children)), nil
+}
+
+func encodeBox(b mp4.Box) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := b.Encode(buf)
+	return buf.Bytes(), err
+}
+
+// readSegment appends truns of the segment to chunks, and their samples to tracks
+// readSegmentOf opens the segment, and closes it after reading its chunks
+func readSegmentOf(chunks []remuxChunk, index int, seg Segment, tracks []*remuxTrack,
+	init *remuxInit) ([]remuxChunk, error) {
+	data, err := seg.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+	return readSegment(chunks, index, data, tracks, init)
+}
+
+func readSegment(chunks []remuxChunk, index int, seg io.ReadSeeker, tracks []*remuxTrack,
+	init *remuxInit) ([]remuxChunk, error) {
+	_, err := seg.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	// sample data is not read
+	f, err := mp4.DecodeFile(seg, mp4.WithDecodeMode(mp4.DecModeLazyMdat))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range f.Segments {
+		for _, frag := range s.Fragments {
+			moof := frag.Moof
+			if moof == nil {
+				continue
+			}
+			for _, traf := range moof.Trafs {
+				it, ok := init.tracks[traf.Tfhd.TrackID]
+				if !ok {
+					continue
+				}
+				t := tracks[it.index]
+				timescale := t.trak.Mdia.Mdhd.Timescale
+
+				desc := uint32(1)
+				if traf.Tfhd.HasSampleDescriptionIndex() {
+					desc = traf.Tfhd.SampleDescriptionIndex
+				} else if it.trex != nil && it.trex.DefaultSampleDescriptionIndex != 0 {
+					desc = it.trex.DefaultSampleDescriptionIndex
+				}
+				if desc < 1 || int(desc) > len(it.descs) {
+					return nil, fmt.Errorf("track %d has no sample description %d", traf.Tfhd.TrackID, desc)
+				}
+
+				for _, trun := range traf.Truns {
+					trun.AddSampleDefaultValues(traf.Tfhd, it.trex)
+					// offset is relative to moof by default, see Section 8.8.7.1
+					offset := moof.StartPos
+					if traf.Tfhd.HasBaseDataOffset() {
+						offset = traf.Tfhd.BaseDataOffset
+					}
+					if trun.HasDataOffset() {
+						offset = uint64(int64(offset) + int64(trun.DataOffset))
+					}
+
+					chunks = append(chunks, remuxChunk{
+						track:   it.index,
+						segment: index,
+						offset:  int64(offset),
+						first:   len(t.samples),
+						count:   len(trun.Samples),
+						desc:    it.descs[desc-1],
+					})
+					for _, sample := range trun.Samples {
+						sync := !mp4.DecodeSampleFlags(sample.Flags).SampleIsNonSync
+						dur, cto := sample.Dur, sample.CompositionTimeOffset
+						if it.timescale != timescale {
+							dur = uint32(uint64(dur) * uint64(timescale) / uint64(it.timescale))
+							cto = int32(int64(cto) * int64(timescale) / int64(it.timescale))
+						}
+						t.samples = append(t.samples, remuxSample{
+							dur:  dur,
+							size: sample.Size,
+							cto:  cto,
+							sync: sync,
+						})
+						if !sync {
+							t.keyframed = true
+						}
+					}
+				}
+			}
+		}
+	}
+	return chunks, nil
+}
+
+// trim selects samples of tracks within [start, end). The first track with keyframes decides the range, and
+// other tracks are trimmed to it.
+func trim(tracks []*remuxTrack, start, end time.Duration) {
+	ref := -1
+	for i, t := range tracks {
+		if t.keyframed {
+			ref = i
+			start, end = t.trimKeyframes(start, end)
+			break
+		}
+	}
+	for i, t := range tracks {
+		if i != ref {
+			t.trimTime(start, end)
+		}
+	}
+}
+
+func (t *remuxTrack) ticks(d time.Duration) uint64 {
+	return uint64(d.Seconds() * float64(t.trak.Mdia.Mdhd.Timescale))
+}
+
+func (t *remuxTrack) duration(ticks uint64) time.Duration {
+	return time.Duration(float64(ticks) / float64(t.trak.Mdia.Mdhd.Timescale) * float64(time.Second))
+}
+
+// trimKeyframes keeps samples from the keyframe at or before start, to the one at or after end. It returns time of
+// the range after being extended, end is 0 if it is the end of the track.
+func (t *remuxTrack) trimKeyframes(start, end time.Duration) (time.Duration, time.Duration) {
+	startTicks, endTicks := t.ticks(start), t.ticks(end)
+	t.lo, t.hi = 0, len(t.samples)
+
+	var loTicks, dts uint64
+	for i, s := range t.samples {
+		if s.sync {
+			if dts <= startTicks {
+				t.lo, loTicks = i, dts
+			} else if end > 0 && dts >= endTicks {
+				t.hi = i
+				return t.duration(loTicks), t.duration(dts)
+			}
+		}
+		dts += uint64(s.dur)
+	}
+	if dts <= startTicks {
+		// start is after the end of the track
+		t.lo = t.hi
+		return start, 0
+	}
+	return t.duration(loTicks), 0
+}
+
+// trimTime keeps samples decoded within [start, end), end 0 is the end of the track
+func (t *remuxTrack) trimTime(start, end time.Duration) {
+	startTicks, endTicks := t.ticks(start), t.ticks(end)
+	t.lo, t.hi = len(t.samples), len(t.samples)
+
+	var dts uint64
+	for i, s := range t.samples {
+		if dts >= startTicks && t.lo == len(t.samples) {
+			t.lo = i
+		}
+		if end > 0 && dts >= endTicks {
+			t.hi = i
+			break
+		}
+		dts += uint64(s.dur)
+	}
+	if t.lo > t.hi {
+		t.lo = t.hi
+	}
+}
+
+// progressiveHeader returns ftyp, moov and header of mdat which is in size of mdatSize
+func progressiveHeader(mvhd *mp4.MvhdBox, tracks []*remuxTrack, mdatSize uint64) ([]byte, error) {
+	ftyp := mp4.NewFtyp("isom", 0x200, []string{"isom", "iso2", "mp41"})
+
+	mdatHeaderSize := uint64(8)
+	if mdatSize+mdatHeaderSize > math.MaxUint32 {
+		mdatHeaderSize = 16
+	}
+
+	// 32-bit chunk offsets are used unless they overflow
+	for _, co64 := range []bool{false, true} {
+		moov := mp4.NewMoovBox()
+		moov.AddChild(mvhd)
+		for _, t := range tracks {
+			err := t.buildStbl(co64)
+			if err != nil {
+				return nil, err
+			}
+			moov.AddChild(t.trak)
+		}
+		setDurations(moov, tracks)
+
+		base := ftyp.Size() + moov.Size() + mdatHeaderSize
+		if !co64 && base+mdatSize > math.MaxUint32 {
+			continue
+		}
+		for _, t := range tracks {
+			stbl := t.trak.Mdia.Minf.Stbl
+			if co64 {
+				for i := range stbl.Co64.ChunkOffset {
+					stbl.Co64.ChunkOffset[i] += base
+				}
+			} else {
+				for i := range stbl.Stco.ChunkOffset {
+					stbl.Stco.ChunkOffset[i] += uint32(base)
+				}
+			}
+		}
+
+		buf := &bytes.Buffer{}
+		err := ftyp.Encode(buf)
+		if err != nil {
+			return nil, err
+		}
+		err = moov.Encode(buf)
+		if err != nil {
+			return nil, err
+		}
+		if mdatHeaderSize == 8 {
+			binary.Write(buf, binary.BigEndian, uint32(mdatSize+mdatHeaderSize))
+			buf.WriteString("mdat")
+		} else {
+			// largesize
+			binary.Write(buf, binary.BigEndian, uint32(1))
+			buf.WriteString("mdat")
+			binary.Write(buf, binary.BigEndian, mdatSize+mdatHeaderSize)
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, errors.New("mp4 is too large")
+}
+
+// buildStbl replaces the empty sample table of the fragmented track by the kept samples. Chunk offsets are
+// relative to the beginning of mdat data.
+func (t *remuxTrack) buildStbl(co64 bool) error {
+	minf := t.trak.Mdia.Minf
+	samples := t.samples[t.lo:t.hi]
+
+	stts := &mp4.SttsBox{}
+	var (
+		cttsCounts  []uint32
+		cttsOffsets []int32
+		hasCtts     bool
+		negative    bool
+	)
+	stss := &mp4.StssBox{}
+	stsz := &mp4.StszBox{SampleNumber: uint32(len(samples)), SampleSize: make([]uint32, 0, len(samples))}
+	for i, s := range samples {
+		if n := len(stts.SampleCount); n > 0 && stts.SampleTimeDelta[n-1] == s.dur {
+			stts.SampleCount[n-1]++
+		} else {
+			stts.SampleCount = append(stts.SampleCount, 1)
+			stts.SampleTimeDelta = append(stts.SampleTimeDelta, s.dur)
+		}
+
+		if n := len(cttsOffsets); n > 0 && cttsOffsets[n-1] == s.cto {
+			cttsCounts[n-1]++
+		} else {
+			cttsCounts = append(cttsCounts, 1)
+			cttsOffsets = append(cttsOffsets, s.cto)
+		}
+		hasCtts = hasCtts || s.cto != 0
+		negative = negative || s.cto < 0
+
+		if s.sync {
+			stss.SampleNumber = append(stss.SampleNumber, uint32(i+1))
+		}
+		stsz.SampleSize = append(stsz.SampleSize, s.size)
+	}
+
+	stsc := &mp4.StscBox{}
+	for i, count := range t.chunkCounts {
+		if n := len(stsc.Entries); n > 0 && stsc.Entries[n-1].SamplesPerChunk == count &&
+			t.chunkDescs[i-1] == t.chunkDescs[i] {
+			continue
+		}
+		err := stsc.AddEntry(uint32(i+1), count, t.chunkDescs[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	stbl := mp4.NewStblBox()
+	stbl.AddChild(minf.Stbl.Stsd)
+	stbl.AddChild(stts)
+	if t.keyframed {
+		// all samples are sync samples without stss
+		stbl.AddChild(stss)
+	}
+	if hasCtts {
+		ctts := &mp4.CttsBox{}
+		if negative {
+			ctts.Version = 1
+		}
+		err := ctts.AddSampleCountsAndOffset(cttsCounts, cttsOffsets)
+		if err != nil {
+			return err
+		}
+		stbl.AddChild(ctts)
+	}
+	stbl.AddChild(stsc)
+	stbl.AddChild(stsz)
+	if co64 {
+		stbl.AddChild(&mp4.Co64Box{ChunkOffset: append([]uint64(nil), t.chunkOffsets...)})
+	} else {
+		offsets := make([]uint32, len(t.chunkOffsets))
+		for i, o := range t.chunkOffsets {
+			offsets[i] = uint32(o)
+		}
+		stbl.AddChild(&mp4.StcoBox{ChunkOffset: offsets})
+	}
+
+	for i, c := range minf.Children {
+		if c.Type() == "stbl" {
+			minf.Children[i] = stbl
+		}
+	}
+	minf.Stbl = stbl
+	return nil
+}
+
+// setDurations sets durations of tracks and the movie by their kept samples
+func setDurations(moov *mp4.MoovBox, tracks []*remuxTrack) {
+	mvhd := moov.Mvhd
+	mvhd.Duration = 0
+	for _, t := range tracks {
+		var dur uint64
+		for _, s := range t.samples[t.lo:t.hi] {
+			dur += uint64(s.dur)
+		}
+		mdhd := t.trak.Mdia.Mdhd
+		mdhd.Duration = dur
+		mdhd.Version = version(dur)
+
+		// in timescale of the movie
+		movieDur := dur * uint64(mvhd.Timescale) / uint64(mdhd.Timescale)
+		t.trak.Tkhd.Duration = movieDur
+		t.trak.Tkhd.Version = version(movieDur)
+		if movieDur > mvhd.Duration {
+			mvhd.Duration = movieDur
+		}
+
+		if t.trak.Edts == nil {
+			continue
+		}
+		for _, elst := range t.trak.Edts.Elst {
+			if n := len(elst.Entries); n > 0 {
+				elst.Entries[n-1].SegmentDuration = movieDur
+				elst.Version = max(elst.Version, version(movieDur))
+			}
+		}
+	}
+	mvhd.Version = version(mvhd.Duration)
+}
+
+// version returns version of boxes which can keep the duration
+func version(dur uint64) byte {
+	if dur > math.MaxUint32 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,260 @@
+package mp4processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Eyevinn/mp4ff/mp4"
+	"github.com/leslie-wang/clusterd/common/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testVideoID = 1
+	testAudioID = 2
+
+	testSegmentFrames = 60  // 2s of 30fps video, keyframe every 30 frames
+	testSegmentAudios = 100 // 2s of 20ms audio samples
+)
+
+func testSampleData(trackID uint32, i int) []byte {
+	data := make([]byte, 4+i%5)
+	data[0], data[1], data[2] = byte(trackID), byte(i>>8), byte(i)
+	return data
+}
+
+// testInit is how ffmpeg of an attempt initializes the tracks. A resumed recording may have other track order,
+// ids, timescales and codec parameters.
+type testInit struct {
+	audioFirst     bool
+	videoID        uint32
+	audioID        uint32
+	videoTimescale uint32
+	width          uint16
+}
+
+var testInits = []testInit{
+	{videoID: testVideoID, audioID: testAudioID, videoTimescale: 90000, width: 640},
+	{audioFirst: true, videoID: 2, audioID: 1, videoTimescale: 15360, width: 1280},
+}
+
+func addTestTrack(t *testing.T, init *mp4.InitSegment, kind string, id, timescale uint32, entry mp4.Box) {
+	init.AddEmptyTrack(timescale, kind, "und")
+	trak := init.Moov.Traks[len(init.Moov.Traks)-1]
+	trak.Tkhd.TrackID = id
+	init.Moov.Mvex.Trexs[len(init.Moov.Mvex.Trexs)-1].TrackID = id
+	trak.Mdia.Minf.Stbl.Stsd.AddChild(entry)
+}
+
+// mkTestSegments returns init and n segments of fragmented mp4 since segment first, whose timestamps restart in
+// every segment. Sample data is the same for the same sample of recording, whichever init it is written by.
+func mkTestSegments(t *testing.T, ti testInit, first, n int) ([]byte, []io.ReadSeeker) {
+	init := mp4.CreateEmptyInit()
+	video := mp4.CreateVisualSampleEntryBox("avc1", ti.width, ti.width*9/16, nil)
+	audio := mp4.CreateAudioSampleEntryBox("mp4a", 2, 16, 48000, nil)
+	if ti.audioFirst {
+		addTestTrack(t, init, "audio", ti.audioID, 48000, audio)
+		addTestTrack(t, init, "video", ti.videoID, ti.videoTimescale, video)
+	} else {
+		addTestTrack(t, init, "video", ti.videoID, ti.videoTimescale, video)
+		addTestTrack(t, init, "audio", ti.audioID, 48000, audio)
+	}
+	buf := &bytes.Buffer{}
+	require.Nil(t, init.Encode(buf))
+
+	frameDur := ti.videoTimescale / 30
+	var segments []io.ReadSeeker
+	for s := first; s < first+n; s++ {
+		frag, err := mp4.CreateMultiTrackFragment(uint32(s+1), []uint32{ti.videoID, ti.audioID})
+		require.Nil(t, err)
+		for i := 0; i < testSegmentFrames; i++ {
+			flags, cto := mp4.NonSyncSampleFlags, int32(frameDur)
+			if i%30 == 0 {
+				flags, cto = mp4.SyncSampleFlags, 0
+			}
+			data := testSampleData(testVideoID, s*testSegmentFrames+i)
+			require.Nil(t, frag.AddFullSampleToTrack(mp4.FullSample{
+				Sample:     mp4.NewSample(flags, frameDur, uint32(len(data)), cto),
+				DecodeTime: uint64(i) * uint64(frameDur),
+				Data:       data,
+			}, ti.videoID))
+		}
+		for i := 0; i < testSegmentAudios; i++ {
+			data := testSampleData(testAudioID, s*testSegmentAudios+i)
+			require.Nil(t, frag.AddFullSampleToTrack(mp4.FullSample{
+				Sample:     mp4.NewSample(mp4.SyncSampleFlags, 960, uint32(len(data)), 0),
+				DecodeTime: uint64(i * 960),
+				Data:       data,
+			}, ti.audioID))
+		}
+		seg := &bytes.Buffer{}
+		require.Nil(t, frag.Encode(seg))
+		segments = append(segments, bytes.NewReader(seg.Bytes()))
+	}
+	return buf.Bytes(), segments
+}
+
+// mkTestRecording returns init segments and segments of a recording, which has n segments in every attempt
+func mkTestRecording(t *testing.T, attempts, n int) ([]io.Reader, []Segment) {
+	var (
+		inits    []io.Reader
+		segments []Segment
+	)
+	for a := 0; a < attempts; a++ {
+		init, segs := mkTestSegments(t, testInits[a], a*n, n)
+		inits = append(inits, bytes.NewReader(init))
+		for _, seg := range segs {
+			segments = append(segments, Segment{Init: a, Open: openTestSegment(seg)})
+		}
+	}
+	return inits, segments
+}
+
+// testSegment is a segment in memory, which counts how many segments are open
+type testSegment struct {
+	io.ReadSeeker
+	open *int
+}
+
+func (s testSegment) Close() error {
+	*s.open--
+	return nil
+}
+
+// openSegments counts segments opened by openTestSegment, and not closed yet
+var openSegments int
+
+func openTestSegment(seg io.ReadSeeker) func() (io.ReadSeekCloser, error) {
+	return func() (io.ReadSeekCloser, error) {
+		if openSegments > 0 {
+			return nil, fmt.Errorf("%d segments are open", openSegments)
+		}
+		if _, err := seg.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		openSegments++
+		return testSegment{ReadSeeker: seg, open: &openSegments}, nil
+	}
+}
+
+// remuxTest remuxes 2 segments recorded in given attempts
+func remuxTest(t *testing.T, attempts int, start, end time.Duration) *mp4.File {
+	inits, segments := mkTestRecording(t, attempts, 2/attempts)
+	p, err := Remux(inits, segments, start, end)
+	require.Nil(t, err)
+	// segments are opened one at a time, and closed after read
+	assert.Zero(t, openSegments)
+
+	// concatenated like it is served. Segments are in memory, so they are still readable after closed.
+	parts := []storage.Part{{Data: p.Header}}
+	for _, c := range p.Chunks {
+		data, err := segments[c.Segment].Open()
+		require.Nil(t, err)
+		require.Nil(t, data.Close())
+		parts = append(parts, storage.Part{Reader: data, Offset: c.Offset, Size: c.Size})
+	}
+	content, err := io.ReadAll(storage.NewConcatReader(context.Background(), nil, parts))
+	require.Nil(t, err)
+	assert.Equal(t, len(p.Header), bytes.Index(content, []byte("mdat"))+4)
+
+	f, err := mp4.DecodeFile(bytes.NewReader(content))
+	require.Nil(t, err)
+	require.NotNil(t, f.Moov)
+	assert.Nil(t, f.Moov.Mvex)
+	assert.Empty(t, f.Segments)
+	return f
+}
+
+// assertSamples checks that samples of the track are the ones since first in the source
+func assertSamples(t *testing.T, f *mp4.File, trak *mp4.TrakBox, first, count int) {
+	require.Equal(t, uint32(count), trak.GetNrSamples())
+
+	buf := &bytes.Buffer{}
+	require.Nil(t, f.CopySampleData(buf, nil, trak, 1, uint32(count), nil))
+	var expected []byte
+	for i := first; i < first+count; i++ {
+		expected = append(expected, testSampleData(trak.Tkhd.TrackID, i)...)
+	}
+	assert.Equal(t, expected, buf.Bytes())
+}
+
+func TestRemux(t *testing.T) {
+	f := remuxTest(t, 1, 0, 0)
+	video, audio := f.Moov.Traks[0], f.Moov.Traks[1]
+
+	assertSamples(t, f, video, 0, 2*testSegmentFrames)
+	assert.Equal(t, []uint32{1, 31, 61, 91}, video.Mdia.Minf.Stbl.Stss.SampleNumber)
+	assert.NotNil(t, video.Mdia.Minf.Stbl.Ctts)
+	assert.Equal(t, uint64(2*testSegmentFrames*3000), video.Mdia.Mdhd.Duration)
+
+	assertSamples(t, f, audio, 0, 2*testSegmentAudios)
+	assert.Nil(t, audio.Mdia.Minf.Stbl.Stss)
+	assert.Nil(t, audio.Mdia.Minf.Stbl.Ctts)
+	assert.Equal(t, uint64(2*testSegmentAudios*960), audio.Mdia.Mdhd.Duration)
+
+	assert.Equal(t, uint64(4*f.Moov.Mvhd.Timescale), f.Moov.Mvhd.Duration)
+}
+
+func TestRemuxRange(t *testing.T) {
+	// extended to keyframes at 1s and 3s
+	f := remuxTest(t, 1, 1500*time.Millisecond, 2500*time.Millisecond)
+	video, audio := f.Moov.Traks[0], f.Moov.Traks[1]
+
+	assertSamples(t, f, video, 30, 60)
+	assert.Equal(t, []uint32{1, 31}, video.Mdia.Minf.Stbl.Stss.SampleNumber)
+	assertSamples(t, f, audio, 50, 100)
+	assert.Equal(t, uint64(2*f.Moov.Mvhd.Timescale), f.Moov.Mvhd.Duration)
+
+	// to the end
+	f = remuxTest(t, 1, 3500*time.Millisecond, 0)
+	assertSamples(t, f, f.Moov.Traks[0], 90, 30)
+	assertSamples(t, f, f.Moov.Traks[1], 150, 50)
+
+	inits, segments := mkTestRecording(t, 1, 1)
+	_, err := Remux(inits, segments, 10*time.Second, 0)
+	assert.NotNil(t, err)
+}
+
+// resumed recording is remuxed into the tracks of its first init segment
+func TestRemuxResumed(t *testing.T) {
+	f := remuxTest(t, 2, 0, 0)
+	video, audio := f.Moov.Traks[0], f.Moov.Traks[1]
+	assert.Equal(t, uint32(testVideoID), video.Tkhd.TrackID)
+	assert.Equal(t, uint32(90000), video.Mdia.Mdhd.Timescale)
+
+	assertSamples(t, f, video, 0, 2*testSegmentFrames)
+	assert.Equal(t, []uint32{1, 31, 61, 91}, video.Mdia.Minf.Stbl.Stss.SampleNumber)
+	// rescaled from timescale of the second attempt
+	assert.Equal(t, uint64(2*testSegmentFrames*3000), video.Mdia.Mdhd.Duration)
+	assertSamples(t, f, audio, 0, 2*testSegmentAudios)
+
+	// samples of the second attempt are described by its own sample entry
+	stbl := video.Mdia.Minf.Stbl
+	require.Len(t, stbl.Stsd.Children, 2)
+	assert.Equal(t, uint16(1280), stbl.Stsd.Children[1].(*mp4.VisualSampleEntryBox).Width)
+	// mp4ff decodes sample description of the first stsc entry as 0 once they differ
+	require.Len(t, stbl.Stsc.SampleDescriptionID, len(stbl.Stsc.Entries))
+	assert.Equal(t, uint32(2), stbl.Stsc.SampleDescriptionID[len(stbl.Stsc.Entries)-1])
+	require.Len(t, audio.Mdia.Minf.Stbl.Stsd.Children, 1)
+
+	// range across the discontinuity
+	f = remuxTest(t, 2, 1500*time.Millisecond, 2500*time.Millisecond)
+	assertSamples(t, f, f.Moov.Traks[0], 30, 60)
+	assertSamples(t, f, f.Moov.Traks[1], 50, 100)
+
+	// tracks can't be mapped
+	inits, segments := mkTestRecording(t, 1, 1)
+	init, _ := mkTestSegments(t, testInit{videoID: 1, audioID: 2, videoTimescale: 90000}, 0, 0)
+	f2, err := mp4.DecodeFile(bytes.NewReader(init))
+	require.Nil(t, err)
+	f2.Moov.Traks[1].Mdia.Hdlr.HandlerType = "vide"
+	buf := &bytes.Buffer{}
+	require.Nil(t, f2.Encode(buf))
+	_, err = Remux(append(inits, buf), append(segments, Segment{Init: 1, Open: segments[0].Open}), 0, 0)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Part is a part of concatenated content, either Data in memory, or Size bytes since Offset of Reader, or of the
+// object Key in the store, e.g. a segment listed before
+type Part struct {
+	Data   []byte
+	Reader io.ReadSeeker
+	Key    string
+	Offset int64
+	Size   int64
+}
+
+// ConcatReader reads parts as one content. Seek doesn't read any part, so a range of the content is read from
+// the parts in it only, e.g. served by http.ServeContent. Objects are opened once they are read, so that no
+// request is sent for objects out of the range, and only the last one read is kept open, so that a long
+// recording doesn't hold all of its segments open.
+type ConcatReader struct {
+	ctx    context.Context
+	store  Store
+	key    string // key of the opened object
+	object Object
+
+	parts   []Part
+	starts  []int64 // offsets of parts in the content
+	size    int64
+	offset  int64
+	reading int // part whose reader is at offset, -1 if none
+}
+
+// NewConcatReader creates ConcatReader of parts, whose objects are opened from store. Store may be nil if no
+// part is an object.
+func NewConcatReader(ctx context.Context, store Store, parts []Part) *ConcatReader {
+	c := &ConcatReader{
+		ctx:     ctx,
+		store:   store,
+		parts:   make([]Part, len(parts)),
+		starts:  make([]int64, len(parts)),
+		reading: -1,
+	}
+	for i, p := range parts {
+		if p.Reader == nil && p.Key == "" {
+			p.Size = int64(len(p.Data))
+		}
+		c.parts[i] = p
+		c.starts[i] = c.size
+		c.size += p.Size
+	}
+	return c
+}
+
+// Size returns size of the whole content
+func (c *ConcatReader) Size() int64 {
+	return c.size
+}
+
+// reader returns reader of the part, the object is opened if it is not yet, and the one opened before is closed
+func (c *ConcatReader) reader(part *Part) (io.ReadSeeker, error) {
+	if part.Key == "" {
+		return part.Reader, nil
+	}
+	if c.object != nil && c.key == part.Key {
+		return c.object, nil
+	}
+	if c.store == nil {
+		return nil, fmt.Errorf("no store to open %s", part.Key)
+	}
+	if err := c.Close(); err != nil {
+		return nil, err
+	}
+	obj, err := c.store.Open(c.ctx, part.Key)
+	if err != nil {
+		return nil, err
+	}
+	c.key, c.object = part.Key, obj
+	return obj, nil
+}
+
+func (c *ConcatReader) Read(p []byte) (int, error) {
+	if c.offset >= c.size {
+		return 0, io.EOF
+	}
+	// the last part starting at or before offset, parts of 0 bytes are skipped
+	i := sort.Search(len(c.starts), func(i int) bool { return c.starts[i] > c.offset }) - 1
+	part := &c.parts[i]
+	pos := c.offset - c.starts[i]
+	if left := part.Size - pos; int64(len(p)) > left {
+		p = p[:left]
+	}
+
+	if part.Reader == nil && part.Key == "" {
+		n := copy(p, part.Data[pos:])
+		c.offset += int64(n)
+		return n, nil
+	}
+
+	r, err := c.reader(part)
+	if err != nil {
+		return 0, err
+	}
+	if c.reading != i {
+		_, err = r.Seek(part.Offset+pos, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+		c.reading = i
+	}
+	n, err := r.Read(p)
+	c.offset += int64(n)
+	if errors.Is(err, io.EOF) {
+		if n == 0 {
+			// the part is shorter than its size
+			return 0, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
+
+func (c *ConcatReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += c.offset
+	case io.SeekEnd:
+		offset += c.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek concatenated content: negative position")
+	}
+	if offset != c.offset {
+		c.reading = -1
+	}
+	c.offset = offset
+	return offset, nil
+}
+
+// Close closes the object opened for reading
+func (c *ConcatReader) Close() error {
+	if c.object == nil {
+		return nil
+	}
+	err := c.object.Close()
+	c.key, c.object = "", nil
+	return err
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Local keeps objects as files under the directory, e.g. media dir on a shared filesystem
+type Local struct {
+	dir string
+}
+
+// NewLocal creates local store in the directory
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+func (l *Local) path(key string) string {
+	return filepath.Join(l.dir, filepath.FromSlash(JoinKey(key)))
+}
+
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	fname := l.path(key)
+	err := os.MkdirAll(filepath.Dir(fname), 0777)
+	if err != nil {
+		return err
+	}
+
+	// write into temporary file, so readers never see a partial object
+	f, err := os.CreateTemp(filepath.Dir(fname), "."+filepath.Base(fname)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fname)
+}
+
+func (l *Local) Open(ctx context.Context, key string) (Object, error) {
+	f, err := os.Open(l.path(key))
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if stat.IsDir() {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: key, Err: ErrNotExist}
+	}
+	return &localObject{File: f, info: ObjectInfo{Key: JoinKey(key), Size: stat.Size(), ModTime: stat.ModTime()}}, nil
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	stat, err := os.Stat(l.path(key))
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() {
+		return nil, &fs.PathError{Op: "stat", Path: key, Err: ErrNotExist}
+	}
+	return &ObjectInfo{Key: JoinKey(key), Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (l *Local) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	// only the directory which keys with the prefix are in, e.g. of the job
+	root := l.dir
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		root = l.path(prefix[:i])
+	}
+
+	var objs []ObjectInfo
+	err := filepath.WalkDir(root, func(fname string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.dir, fname)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objs = append(objs, ObjectInfo{Key: key, Size: stat.Size(), ModTime: stat.ModTime()})
+		return nil
+	})
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Key < objs[j].Key })
+	return objs, err
+}
+
+func (l *Local) Remove(ctx context.Context, key string) error {
+	err := os.Remove(l.path(key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) RemoveAll(ctx context.Context, prefix string) error {
+	objs, err := l.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, o := range objs {
+		err = l.Remove(ctx, o.Key)
+		if err != nil {
+			return err
+		}
+	}
+	// remove the directory if the prefix is one, e.g. "12/"
+	if dir := strings.TrimSuffix(prefix, "/"); dir != "" && dir != prefix {
+		return os.RemoveAll(l.path(dir))
+	}
+	return nil
+}
+
+type localObject struct {
+	*os.File
+	info ObjectInfo
+}
+
+func (o *localObject) Info() ObjectInfo {
+	return o.info
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// storage types
+const (
+	TypeLocal = "local"
+	TypeS3    = "s3"
+)
+
+// ErrNotExist is returned when the object doesn't exist. It is fs.ErrNotExist, so errors.Is and os.IsNotExist
+// work for objects of all stores.
+var ErrNotExist = fs.ErrNotExist
+
+// Store keeps recorded media by key, which is the path under the directory of the job, prefixed by job ID,
+// e.g. "12/index.m3u8".
+type Store interface {
+	// Put saves the content of given size as the object, replacing the existing one
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Open returns the object for reading
+	Open(ctx context.Context, key string) (Object, error)
+	// Stat returns the information of the object
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// List returns objects with the prefix, sorted by key
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Remove removes the object. It isn't an error if the object doesn't exist.
+	Remove(ctx context.Context, key string) error
+	// RemoveAll removes objects with the prefix. It isn't an error if there is none.
+	RemoveAll(ctx context.Context, prefix string) error
+}
+
+// Object is an opened object. Seek doesn't cost a request, so objects can be served by http.ServeContent.
+type Object interface {
+	io.ReadSeekCloser
+	Info() ObjectInfo
+}
+
+// ObjectInfo is the information of an object
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Config is configuration of the store
+type Config struct {
+	Type string // local or s3
+
+	Dir string // directory of local store
+
+	Endpoint  string // url of S3 compatible service, e.g. http://minio:9000
+	Region    string
+	Bucket    string
+	Prefix    string // prefix of all keys in the bucket
+	AccessKey string
+	SecretKey string
+}
+
+// New creates the store by configuration
+func New(c Config) (Store, error) {
+	switch c.Type {
+	case TypeLocal, "":
+		if c.Dir == "" {
+			return nil, fmt.Errorf("directory of local store is required")
+		}
+		return NewLocal(c.Dir), nil
+	case TypeS3:
+		return NewS3(c)
+	}
+	return nil, fmt.Errorf("unknown storage type %s", c.Type)
+}
+
+// MediaKey returns key of the file of the job. name is the path under the job directory.
+func MediaKey(jobID int, name string) string {
+	return JoinKey(strconv.Itoa(jobID), name)
+}
+
+// JoinKey joins elements into a key. Leading "/" and ".." are removed, so the key is always under the prefix.
+func JoinKey(elem ...string) string {
+	return strings.TrimPrefix(path.Clean("/"+path.Join(elem...)), "/")
+}
+
+// ReadAll reads whole content of the object
+func ReadAll(ctx context.Context, s Store, key string) ([]byte, error) {
+	obj, err := s.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	return io.ReadAll(obj)
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStore checks behavior which every store has
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	for key, content := range map[string]string{
+		"12/index.m3u8": "#EXTM3U\n",
+		"12/init.mp4":   "init",
+		"12/0.m4s":      "segment 0",
+		"12/flv/a.flv":  "flv",
+		"120/0.m4s":     "other job",
+	} {
+		require.Nil(t, s.Put(ctx, key, strings.NewReader(content), int64(len(content))), key)
+	}
+
+	content, err := ReadAll(ctx, s, "12/0.m4s")
+	require.Nil(t, err)
+	assert.Equal(t, "segment 0", string(content))
+
+	// replaced by later put
+	require.Nil(t, s.Put(ctx, "12/index.m3u8", strings.NewReader("#EXTM3U\n#EXT-X-ENDLIST\n"), 23))
+	content, err = ReadAll(ctx, s, "12/index.m3u8")
+	require.Nil(t, err)
+	assert.Equal(t, "#EXTM3U\n#EXT-X-ENDLIST\n", string(content))
+
+	obj, err := s.Open(ctx, "12/0.m4s")
+	require.Nil(t, err)
+	assert.Equal(t, int64(9), obj.Info().Size)
+	pos, err := obj.Seek(-1, io.SeekEnd)
+	require.Nil(t, err)
+	assert.Equal(t, int64(8), pos)
+	content, err = io.ReadAll(obj)
+	require.Nil(t, err)
+	assert.Equal(t, "0", string(content))
+	_, err = obj.Seek(2, io.SeekStart)
+	require.Nil(t, err)
+	content, err = io.ReadAll(obj)
+	require.Nil(t, err)
+	assert.Equal(t, "gment 0", string(content))
+	require.Nil(t, obj.Close())
+
+	info, err := s.Stat(ctx, "12/init.mp4")
+	require.Nil(t, err)
+	assert.Equal(t, "12/init.mp4", info.Key)
+	assert.Equal(t, int64(4), info.Size)
+
+	_, err = s.Stat(ctx, "12/missing.m4s")
+	assert.True(t, errors.Is(err, ErrNotExist), "%v", err)
+	_, err = s.Open(ctx, "12/missing.m4s")
+	assert.True(t, os.IsNotExist(err) || errors.Is(err, ErrNotExist), "%v", err)
+
+	objs, err := s.List(ctx, "12/")
+	require.Nil(t, err)
+	var keys []string
+	for _, o := range objs {
+		keys = append(keys, o.Key)
+	}
+	assert.Equal(t, []string{"12/0.m4s", "12/flv/a.flv", "12/index.m3u8", "12/init.mp4"}, keys)
+
+	require.Nil(t, s.Remove(ctx, "12/flv/a.flv"))
+	_, err = s.Stat(ctx, "12/flv/a.flv")
+	assert.True(t, errors.Is(err, ErrNotExist), "%v", err)
+	// removing missing object isn't an error
+	assert.Nil(t, s.Remove(ctx, "12/flv/a.flv"))
+
+	require.Nil(t, s.RemoveAll(ctx, "12/"))
+	objs, err = s.List(ctx, "12/")
+	require.Nil(t, err)
+	assert.Empty(t, objs)
+	// other job with the same prefix of ID isn't removed
+	_, err = s.Stat(ctx, "120/0.m4s")
+	assert.Nil(t, err)
+
+	// removing nothing isn't an error
+	assert.Nil(t, s.RemoveAll(ctx, "12/"))
+}
+
+func TestLocal(t *testing.T) {
+	testStore(t, NewLocal(t.TempDir()))
+}
+
+func TestJoinKey(t *testing.T) {
+	assert.Equal(t, "12/index.m3u8", JoinKey("12", "index.m3u8"))
+	assert.Equal(t, "12/flv/a.flv", JoinKey("/12/", "flv/a.flv"))
+	assert.Equal(t, "passwd", JoinKey("12", "../../passwd"))
+	assert.Equal(t, "12/index.m3u8", MediaKey(12, "index.m3u8"))
+}
+
+func TestConcatReader(t *testing.T) {
+	c := NewConcatReader(context.Background(), nil, []Part{
+		{Data: []byte("init")},
+		{Reader: strings.NewReader("xxsegment 0"), Offset: 2, Size: 9},
+		{Data: nil},
+		{Reader: strings.NewReader("segment 1"), Size: 9},
+	})
+	assert.Equal(t, int64(22), c.Size())
+
+	content, err := io.ReadAll(c)
+	require.Nil(t, err)
+	assert.Equal(t, "initsegment 0segment 1", string(content))
+
+	// range across parts
+	pos, err := c.Seek(-12, io.SeekEnd)
+	require.Nil(t, err)
+	assert.Equal(t, int64(10), pos)
+	content, err = io.ReadAll(io.LimitReader(c, 5))
+	require.Nil(t, err)
+	assert.Equal(t, "t 0se", string(content))
+
+	_, err = c.Seek(-1, io.SeekStart)
+	assert.NotNil(t, err)
+
+	// part is shorter than its size
+	c = NewConcatReader(context.Background(), nil, []Part{{Reader: strings.NewReader("short"), Size: 10}})
+	_, err = io.ReadAll(c)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+// countingStore counts objects opened, and the ones not closed yet
+type countingStore struct {
+	Store
+	opened []string
+	open   int
+}
+
+// countedObject counts itself closed in its store
+type countedObject struct {
+	Object
+	store *countingStore
+}
+
+func (o countedObject) Close() error {
+	o.store.open--
+	return o.Object.Close()
+}
+
+func (s *countingStore) Open(ctx context.Context, key string) (Object, error) {
+	s.opened = append(s.opened, key)
+	obj, err := s.Store.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	s.open++
+	return countedObject{Object: obj, store: s}, nil
+}
+
+func TestConcatReaderObjects(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStore{Store: NewLocal(t.TempDir())}
+	for _, key := range []string{"1/0.m4s", "1/1.m4s"} {
+		content := "segment " + key[2:3]
+		require.Nil(t, store.Put(ctx, key, strings.NewReader(content), int64(len(content))))
+	}
+
+	c := NewConcatReader(ctx, store, []Part{
+		{Data: []byte("init")},
+		{Key: "1/0.m4s", Size: 9},
+		{Key: "1/1.m4s", Offset: 8, Size: 1},
+		{Key: "1/1.m4s", Size: 7},
+	})
+	assert.Equal(t, int64(21), c.Size())
+
+	// only objects in the range are opened
+	_, err := c.Seek(4, io.SeekStart)
+	require.Nil(t, err)
+	content, err := io.ReadAll(io.LimitReader(c, 9))
+	require.Nil(t, err)
+	assert.Equal(t, "segment 0", string(content))
+	assert.Equal(t, []string{"1/0.m4s"}, store.opened)
+
+	// object is opened once for its parts
+	content, err = io.ReadAll(c)
+	require.Nil(t, err)
+	assert.Equal(t, "1segment", string(content))
+	assert.Equal(t, []string{"1/0.m4s", "1/1.m4s"}, store.opened)
+	// the object read before is closed
+	assert.Equal(t, 1, store.open)
+	assert.Nil(t, c.Close())
+	assert.Zero(t, store.open)
+}
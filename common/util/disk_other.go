@@ -0,0 +1,10 @@
+//go:build !unix
+
+package util
+
+import "errors"
+
+// FreeSpace returns bytes available to unprivileged users in the filesystem of the path
+func FreeSpace(path string) (uint64, error) {
+	return 0, errors.New("free space is not supported on this platform")
+}
@@ -0,0 +1,30 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSize(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.MkdirAll(filepath.Join(dir, "flv"), 0777))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "0.m4s"), make([]byte, 100), 0644))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "flv", "a.flv"), make([]byte, 20), 0644))
+
+	size, err := DirSize(dir)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(120), size)
+
+	_, err = DirSize(filepath.Join(dir, "missing"))
+	assert.True(t, os.IsNotExist(err), "%v", err)
+}
+
+func TestFreeSpace(t *testing.T) {
+	free, err := FreeSpace(t.TempDir())
+	require.Nil(t, err)
+	assert.NotZero(t, free)
+}
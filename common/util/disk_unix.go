@@ -0,0 +1,15 @@
+//go:build unix
+
+package util
+
+import "syscall"
+
+// FreeSpace returns bytes available to unprivileged users in the filesystem of the path
+func FreeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(path, &st)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,89 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// callback sign methods
+const (
+	// SignMethodMD5 signs the callback by md5(key + t), same as Tencent live callback
+	SignMethodMD5 = "md5"
+	// SignMethodHMACSHA256 signs the callback by hmac-sha256(key, t), and the whole body by
+	// hmac-sha256(key, body) in CallbackSignatureHeader
+	SignMethodHMACSHA256 = "hmac-sha256"
+
+	CallbackSignatureHeader = "X-Callback-Signature"
+)
+
+var (
+	ErrInvalidSignMethod = errors.New("invalid callback sign method")
+	ErrInvalidSign       = errors.New("invalid callback sign")
+	ErrSignExpired       = errors.New("callback sign is expired")
+)
+
+// CheckSignMethod returns error if the method is not supported. Empty method is md5.
+func CheckSignMethod(method string) error {
+	switch method {
+	case "", SignMethodMD5, SignMethodHMACSHA256:
+		return nil
+	}
+	return ErrInvalidSignMethod
+}
+
+// MkCallbackSign returns sign of the callback which expires at unix time t
+func MkCallbackSign(method, key string, t int64) string {
+	ts := strconv.FormatInt(t, 10)
+	if method == SignMethodHMACSHA256 {
+		return hmacSHA256(key, []byte(ts))
+	}
+	sum := md5.Sum([]byte(key + ts))
+	return hex.EncodeToString(sum[:])
+}
+
+// MkCallbackBodySign returns sign of the whole callback body, which is sent in CallbackSignatureHeader
+func MkCallbackBodySign(key string, body []byte) string {
+	return hmacSHA256(key, body)
+}
+
+func hmacSHA256(key string, content []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(content)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyCallbackSign checks the callback body is signed by the key, and not expired at now. signature is
+// value of CallbackSignatureHeader, which is required by hmac-sha256 method only.
+func VerifyCallbackSign(method, key string, body []byte, signature string, now time.Time) error {
+	err := CheckSignMethod(method)
+	if err != nil {
+		return err
+	}
+
+	signed := struct {
+		Sign string `json:"sign"`
+		T    int64  `json:"t"`
+	}{}
+	err = json.Unmarshal(body, &signed)
+	if err != nil {
+		return err
+	}
+
+	expected := MkCallbackSign(method, key, signed.T)
+	if !hmac.Equal([]byte(signed.Sign), []byte(expected)) {
+		return ErrInvalidSign
+	}
+	if method == SignMethodHMACSHA256 && !hmac.Equal([]byte(signature), []byte(MkCallbackBodySign(key, body))) {
+		return ErrInvalidSign
+	}
+	if now.Unix() > signed.T {
+		return ErrSignExpired
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMkCallbackSign(t *testing.T) {
+	// md5("key1700000000")
+	assert.Equal(t, "06d851185e908b68ddf9a345983ed7b3", MkCallbackSign(SignMethodMD5, "key", 1700000000))
+	assert.Equal(t, MkCallbackSign(SignMethodMD5, "key", 1700000000), MkCallbackSign("", "key", 1700000000))
+	assert.NotEqual(t, MkCallbackSign(SignMethodMD5, "key", 1700000000),
+		MkCallbackSign(SignMethodHMACSHA256, "key", 1700000000))
+}
+
+func TestVerifyCallbackSign(t *testing.T) {
+	now := time.Now()
+	expire := now.Add(time.Minute).Unix()
+	mkBody := func(method, key string, t int64) []byte {
+		body, _ := json.Marshal(map[string]interface{}{
+			"event_type": 332,
+			"sign":       MkCallbackSign(method, key, t),
+			"t":          t,
+		})
+		return body
+	}
+
+	for _, method := range []string{SignMethodMD5, SignMethodHMACSHA256} {
+		body := mkBody(method, "key", expire)
+		signature := MkCallbackBodySign("key", body)
+		require.Nil(t, VerifyCallbackSign(method, "key", body, signature, now), method)
+
+		assert.ErrorIs(t, VerifyCallbackSign(method, "other", body, signature, now), ErrInvalidSign, method)
+		assert.ErrorIs(t, VerifyCallbackSign(method, "key", body, signature, now.Add(2*time.Minute)),
+			ErrSignExpired, method)
+	}
+
+	// body is changed after signed
+	body := mkBody(SignMethodHMACSHA256, "key", expire)
+	signature := MkCallbackBodySign("key", body)
+	body = bytes.Replace(body, []byte("332"), []byte("100"), 1)
+	assert.ErrorIs(t, VerifyCallbackSign(SignMethodHMACSHA256, "key", body, signature, now), ErrInvalidSign)
+
+	assert.ErrorIs(t, VerifyCallbackSign("sha1", "key", body, "", now), ErrInvalidSignMethod)
+}
@@ -0,0 +1,48 @@
+package util
+
+import (
+	"io"
+)
+
+const tailChunkSize = 4096
+
+// TailOffset returns the offset where the last given number of lines start in the reader
+// with given size. A trailing newline at the end doesn't start a new line.
+func TailOffset(r io.ReaderAt, size int64, lines int) (int64, error) {
+	if lines <= 0 {
+		return size, nil
+	}
+
+	buf := make([]byte, tailChunkSize)
+	end := size
+	// skip the newline of the last line
+	skipLast := true
+	for end > 0 {
+		start := end - tailChunkSize
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:end-start]
+		_, err := r.ReadAt(chunk, start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		for i := len(chunk) - 1; i >= 0; i-- {
+			if chunk[i] != '\n' {
+				skipLast = false
+				continue
+			}
+			if skipLast {
+				skipLast = false
+				continue
+			}
+			lines--
+			if lines == 0 {
+				return start + int64(i) + 1, nil
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
@@ -0,0 +1,37 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTailOffset(t *testing.T) {
+	content := "line1\nline2\nline3\n"
+	r := strings.NewReader(content)
+	size := int64(len(content))
+
+	for _, c := range []struct {
+		lines    int
+		expected string
+	}{
+		{0, ""},
+		{1, "line3\n"},
+		{2, "line2\nline3\n"},
+		{3, content},
+		{10, content},
+	} {
+		offset, err := TailOffset(r, size, c.lines)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expected, content[offset:], "tail %d lines", c.lines)
+	}
+
+	// last line without newline, and lines across chunks
+	long := strings.Repeat("x", tailChunkSize+10)
+	content = "first\n" + long + "\nlast"
+	r = strings.NewReader(content)
+	offset, err := TailOffset(r, int64(len(content)), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, long+"\nlast", content[offset:])
+}
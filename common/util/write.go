@@ -11,6 +11,7 @@ import (
 var (
 	ErrInvalidSourceURL = errors.New("invalid source URL")
 	ErrNotExist         = errors.New("required item not exist")
+	ErrNotOwner         = errors.New("job is not owned by the runner")
 )
 
 func WriteError(w http.ResponseWriter, err error) {
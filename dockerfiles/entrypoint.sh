@@ -12,7 +12,8 @@ echo uid: $UID
 
 service mysql start
 
-bash -c mysql -u root -p'' < ./migrations/0.sql
+# schema is migrated by cd-manager at startup
+mysql -u root -p'' -e 'CREATE DATABASE IF NOT EXISTS clusterd'
 
 # update password
 mysql -u root -p'' < ./dockerfiles/entrypoint.sql
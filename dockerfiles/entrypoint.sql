@@ -1,3 +1,6 @@
 ALTER USER 'root'@'localhost' IDENTIFIED WITH 'mysql_native_password' BY 'rootroot';
 
 FLUSH PRIVILEGES;
+
+-- migrations run in the database given by --db-name
+CREATE DATABASE IF NOT EXISTS clusterd;
@@ -7,6 +7,7 @@ require (
 	github.com/bluenviron/gohlslib v1.3.3
 	github.com/go-sql-driver/mysql v1.7.1
 	github.com/gorilla/mux v1.8.0
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.9.3
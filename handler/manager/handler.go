@@ -11,9 +11,14 @@ import (
 	"time"
 
 	"github.com/leslie-wang/clusterd/common/db"
+	callbackdb "github.com/leslie-wang/clusterd/common/db/callback"
 	"github.com/leslie-wang/clusterd/common/db/job"
+	"github.com/leslie-wang/clusterd/common/db/migrate"
 	"github.com/leslie-wang/clusterd/common/db/record"
+	"github.com/leslie-wang/clusterd/common/db/retention"
 	"github.com/leslie-wang/clusterd/common/logger"
+	"github.com/leslie-wang/clusterd/common/storage"
+	"github.com/leslie-wang/clusterd/common/util"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
@@ -28,10 +33,23 @@ type Config struct {
 	DBPass           string
 	DBName           string
 	ParamQuery       bool
+	SkipMigrate      bool
 	ScheduleInterval time.Duration
+	JobLeaseTimeout  time.Duration
+	MaxJobRequeue    int
 	NotifyURL        string
+	CallbackKey      string        // sign key of callbacks to NotifyURL
+	SignMethod       string        // method to sign callbacks
+	StreamSourceURL  string        // pull url of stream recorded by record rule
+	AutoRecordMaxDur time.Duration // max duration of recording started by record rule
+	CallbackWorkers  int           // number of workers delivering callbacks
 	BaseURL          string
 	MediaDir         string
+	Storage          storage.Config // store of recorded media, local store is in MediaDir by default
+
+	RetentionInterval time.Duration    // how often expired recorded files are removed, 0 disables it
+	RetentionCallback bool             // notify record_file_expired when files are removed
+	DomainQuotas      map[string]int64 // <domain, bytes of recorded files kept>, "*" for domains not listed
 
 	LogDir       string
 	MaxLogSize   int
@@ -44,13 +62,27 @@ type Handler struct {
 	r    *mux.Router
 	lock *sync.Mutex
 
-	db       *sql.DB
-	recordDB *record.DB
-	jobDB    *job.DB
+	streamLock    *sync.Mutex // serializes stream events, so that one stream is recorded once by rule
+	retentionLock *sync.Mutex // serializes scheduling retentions of finished recordings
+
+	db          *sql.DB
+	recordDB    *record.DB
+	jobDB       *job.DB
+	callbackDB  *callbackdb.DB
+	retentionDB *retention.DB
+
+	store     storage.Store  // where runners upload recorded media
+	downloads *downloadCache // parts of recently served downloads
+
+	callbackWake chan struct{} // wakes up callback delivery once new callback is saved
+
+	stop      chan struct{}   // closed by Close to stop background loops
+	loops     *sync.WaitGroup // background loops which are running
+	closeOnce *sync.Once
 
 	logger *logger.Logger
 
-	runners map[string]time.Time // <runner_name, last checkin time>
+	runners map[string]*types.Runner // <runner_name, runner>
 }
 
 var defaultLogger *logger.Logger
@@ -63,21 +95,66 @@ func NewHandler(c Config) (*Handler, error) {
 	}
 
 	h := &Handler{
-		cfg:     c,
-		lock:    &sync.Mutex{},
-		runners: map[string]time.Time{},
-		logger:  logger.New(c.MaxLogSize, c.MaxLogBackup, filepath.Join(c.LogDir, "cd-manager.log")),
+		cfg:           c,
+		lock:          &sync.Mutex{},
+		streamLock:    &sync.Mutex{},
+		retentionLock: &sync.Mutex{},
+		callbackWake:  make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		loops:         &sync.WaitGroup{},
+		closeOnce:     &sync.Once{},
+		downloads:     newDownloadCache(),
+		runners:       map[string]*types.Runner{},
+		logger:        logger.New(c.MaxLogSize, c.MaxLogBackup, filepath.Join(c.LogDir, "cd-manager.log")),
+	}
+	if h.cfg.CallbackWorkers <= 0 {
+		h.cfg.CallbackWorkers = 1
 	}
 
 	defaultLogger = h.logger
 
-	return h, h.init()
+	err = h.init()
+	if err != nil {
+		return nil, err
+	}
+
+	h.goLoop(h.leaseLoop)
+	h.goLoop(h.callbackLoop)
+	h.goLoop(h.retentionLoop)
+
+	return h, nil
+}
+
+// goLoop runs the background loop until the handler is closed
+func (h *Handler) goLoop(loop func()) {
+	h.loops.Add(1)
+	go func() {
+		defer h.loops.Done()
+		loop()
+	}()
+}
+
+// Close stops background loops, and closes the DB after they return. It is fine to close more than once.
+func (h *Handler) Close() (err error) {
+	h.closeOnce.Do(func() {
+		close(h.stop)
+		h.loops.Wait()
+		err = h.db.Close()
+	})
+	return
+}
+
+// isPollingRequest checks whether the request is sent by runners periodically
+func isPollingRequest(r *http.Request) bool {
+	return strings.Contains(r.RequestURI, types.URLJobRunner) ||
+		strings.Contains(r.RequestURI, types.URLJobHeartbeat) ||
+		(r.Method == http.MethodPost && strings.Contains(r.RequestURI, types.URLJobLog))
 }
 
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Do stuff here
-		if !strings.Contains(r.RequestURI, types.URLJobRunner) {
+		if !isPollingRequest(r) {
 			defaultLogger.Debugf("%s - %s", r.Method, r.RequestURI)
 		}
 		// Call the next handler, which can be another middleware in the chain, or the final handler.
@@ -91,12 +168,24 @@ func (h *Handler) CreateRouter() *mux.Router {
 
 		// recording
 		h.r.HandleFunc(types.URLRecord, h.record).Methods(http.MethodPost)
+		h.r.HandleFunc(types.URLStream, h.streamEvent).Methods(http.MethodPost)
 
 		// job related
 		h.r.HandleFunc(types.URLJob, h.listJobs).Methods(http.MethodGet)
 		h.r.HandleFunc(types.MkIDURLByBase(types.URLJobRunner), h.acquireJob).Methods(http.MethodPost)
+		h.r.HandleFunc(types.MkIDURLByBase(types.URLJobHeartbeat), h.heartbeat).Methods(http.MethodPost)
 		h.r.HandleFunc(types.MkIDURLByBase(types.URLJob), h.reportJob).Methods(http.MethodPost)
 		h.r.HandleFunc(types.MkIDURLByBase(types.URLJob), h.getJob).Methods(http.MethodGet)
+		h.r.HandleFunc(types.MkIDURLByBase(types.URLJobLog), h.uploadJobLog).Methods(http.MethodPost)
+		h.r.HandleFunc(types.MkIDURLByBase(types.URLJobLog), h.jobLog).Methods(http.MethodGet)
+		h.r.HandleFunc(types.MkIDURLByBase(types.URLJobLog), h.jobLogSize).Methods(http.MethodHead)
+
+		// runner registry
+		h.r.HandleFunc(types.URLRunner, h.listRunners).Methods(http.MethodGet)
+		h.r.HandleFunc(types.MkIDURLByBase(types.URLRunner), h.getRunner).Methods(http.MethodGet)
+
+		// retention
+		h.r.HandleFunc(types.URLRetention, h.listRetentions).Methods(http.MethodGet)
 
 		// playback
 		h.r.HandleFunc(types.MkIDURLByBase(types.URLPlay)+"/{filename}", h.playback).Methods(http.MethodGet)
@@ -112,6 +201,19 @@ func (h *Handler) CreateRouter() *mux.Router {
 
 // init will initialize the handler with corresponding handle function
 func (h *Handler) init() (err error) {
+	err = util.CheckSignMethod(h.cfg.SignMethod)
+	if err != nil {
+		return
+	}
+
+	if h.cfg.Storage.Dir == "" {
+		h.cfg.Storage.Dir = h.cfg.MediaDir
+	}
+	h.store, err = storage.New(h.cfg.Storage)
+	if err != nil {
+		return
+	}
+
 	// prepare DB
 	h.db, err = db.OpenDB(types.Config{
 		Driver: h.cfg.Driver,
@@ -123,14 +225,40 @@ func (h *Handler) init() (err error) {
 	if err != nil {
 		return
 	}
-	h.jobDB = job.NewDB(h.db)
+
+	if h.cfg.SkipMigrate {
+		err = migrate.Check(h.db, h.cfg.Driver)
+	} else {
+		var applied []int
+		applied, err = migrate.Up(h.db, h.cfg.Driver)
+		if len(applied) != 0 {
+			h.logger.Infof("applied DB migrations %v", applied)
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	h.jobDB = job.NewDB(h.db, h.cfg.Driver)
 	err = h.jobDB.Prepare()
 	if err != nil {
 		return
 	}
 
-	h.recordDB = record.NewDB(h.db)
-	return h.recordDB.Prepare()
+	h.recordDB = record.NewDB(h.db, h.cfg.Driver)
+	err = h.recordDB.Prepare()
+	if err != nil {
+		return
+	}
+
+	h.callbackDB = callbackdb.NewDB(h.db, h.cfg.Driver)
+	err = h.callbackDB.Prepare()
+	if err != nil {
+		return
+	}
+
+	h.retentionDB = retention.NewDB(h.db, h.cfg.Driver)
+	return h.retentionDB.Prepare()
 }
 
 func (h *Handler) newTx() (*sql.Tx, error) {
@@ -0,0 +1,52 @@
+package manager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/leslie-wang/clusterd/common/db"
+	"github.com/leslie-wang/clusterd/types"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestHandler creates handler with sqlite DB and local store in a temporary directory
+func newTestHandler(t *testing.T) *Handler {
+	dir := t.TempDir()
+	h, err := NewHandler(Config{
+		Driver:    db.Sqlite,
+		DBAddress: filepath.Join(dir, types.ClusterDBName+".db"),
+		BaseURL:   "http://localhost:8088",
+		MediaDir:  filepath.Join(dir, "media"),
+		LogDir:    filepath.Join(dir, "log"),
+	})
+	require.Nil(t, err)
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+// serve sends the request to handler's router, and returns the response
+func serve(h *Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.CreateRouter().ServeHTTP(w, r)
+	return w
+}
+
+func get(h *Handler, url string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, url, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	return serve(h, r)
+}
+
+// recordAPI calls the action of record API with query and body
+func recordAPI(h *Handler, action, query, body string) *httptest.ResponseRecorder {
+	url := types.URLRecord + "?" + Action + "=" + action
+	if query != "" {
+		url += "&" + query
+	}
+	return serve(h, httptest.NewRequest(http.MethodPost, url, strings.NewReader(body)))
+}
@@ -2,6 +2,8 @@ package manager
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"time"
@@ -21,7 +23,10 @@ func (h *Handler) listJobs(w http.ResponseWriter, r *http.Request) {
 	util.WriteBody(w, jobs)
 }
 
-func (h *Handler) getCallbackURL(job *types.Job) string {
+// getCallback returns where and how to notify events of the job. Notify url of the task is used for all
+// events, otherwise each type of events is notified to its url in callback template of the task, and the
+// manager's notify url if the template has none. Events are signed by the key of the template either way.
+func (h *Handler) getCallback(job *types.Job) *callback {
 	cb, err := h.recordDB.GetCallbackRuleByRecordTaskID(job.RefID)
 	if err != nil {
 		h.logger.Warnf("retrieve job %d's callback info: %s", job.ID, err)
@@ -33,13 +38,55 @@ func (h *Handler) getCallbackURL(job *types.Job) string {
 		h.logger.Warnf("unmarshal job record: %v", err)
 	}
 
-	callbackURL := h.cfg.NotifyURL
+	task, err := h.recordDB.GetRecordTask(job.RefID)
+	if err != nil {
+		h.logger.Warnf("retrieve job %d's record task: %s", job.ID, err)
+	}
+
+	c := &callback{
+		url:    h.cfg.NotifyURL,
+		urls:   map[types.LiveCallbackEventType]string{},
+		key:    h.cfg.CallbackKey,
+		method: h.cfg.SignMethod,
+		taskID: job.RefID,
+	}
+	if task != nil {
+		c.domain = stringValue(task.DomainName)
+		c.app = stringValue(task.AppName)
+		c.stream = stringValue(task.StreamName)
+	}
+	if cb != nil && cb.CallbackKey != nil {
+		c.key = *cb.CallbackKey
+	}
+	// url given by task overrides where events are notified, they are still signed by the template
 	if record.NotifyURL != "" {
-		callbackURL = record.NotifyURL
-	} else if cb != nil && cb.RecordStatusNotifyUrl != nil {
-		callbackURL = *cb.RecordNotifyUrl
+		c.url = record.NotifyURL
+		return c
 	}
-	return callbackURL
+	if cb == nil {
+		return c
+	}
+
+	for typ, u := range map[types.LiveCallbackEventType]*string{
+		types.LiveCallbackEventTypePushStart:    cb.StreamBeginNotifyUrl,
+		types.LiveCallbackEventTypePushStop:     cb.StreamEndNotifyUrl,
+		types.LiveCallbackEventTypeRecordFile:   cb.RecordNotifyUrl,
+		types.LiveCallbackEventTypeException:    cb.PushExceptionNotifyUrl,
+		types.LiveCallbackEventTypeRecordStatus: cb.RecordStatusNotifyUrl,
+	} {
+		if u != nil && *u != "" {
+			c.urls[typ] = *u
+		}
+	}
+	return c
+}
+
+// stringValue returns the string, or empty if it is nil
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
 }
 
 func (h *Handler) reportJob(w http.ResponseWriter, r *http.Request) {
@@ -55,6 +102,11 @@ func (h *Handler) reportJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if job == nil {
+		util.WriteError(w, util.ErrNotExist)
+		return
+	}
+
 	status := &types.JobStatus{}
 	err = json.NewDecoder(r.Body).Decode(status)
 	if err != nil {
@@ -62,8 +114,15 @@ func (h *Handler) reportJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if job.ExitCode == nil && status.Runner != "" && (job.RunningHost == nil || *job.RunningHost != status.Runner) {
+		// job has been requeued after lease expired, ignore the report from previous runner
+		h.logger.Warnf("ignore job %d status %d from %s, which is not the owner", jobID, status.Type, status.Runner)
+		util.WriteError(w, util.ErrNotOwner)
+		return
+	}
+
 	sessionID := strconv.Itoa(jobID)
-	callbackURL := h.getCallbackURL(job)
+	cb := h.getCallback(job)
 
 	if job.ExitCode != nil {
 		event := &types.LiveCallbackRecordStatusEvent{
@@ -79,55 +138,124 @@ func (h *Handler) reportJob(w http.ResponseWriter, r *http.Request) {
 		} else {
 			event.RecordEvent = types.LiveRecordStatusStartFailed
 		}
-		go notify(callbackURL, sessionID, event)
+		h.notify(cb, sessionID, event)
 		return
 	}
 
 	switch status.Type {
 	case types.RecordJobStart:
-		go notify(callbackURL, sessionID, &types.LiveCallbackRecordStatusEvent{
+		attempts, err := h.jobDB.ListAttempts(jobID)
+		if err != nil {
+			util.WriteError(w, err)
+			return
+		}
+		var event types.LiveRecordStatusEvent = types.LiveRecordStatusStartSucceeded
+		if len(attempts) != 0 {
+			// started again after previous attempts ended
+			event = types.LiveRecordStatusResumed
+		}
+		h.notify(cb, sessionID, &types.LiveCallbackRecordStatusEvent{
 			SessionID:   sessionID,
-			RecordEvent: types.LiveRecordStatusStartSucceeded,
+			RecordEvent: event,
 		})
 	case types.RecordMp4FileCreated:
-		go notify(callbackURL, sessionID, &types.LiveCallbackRecordStatusEvent{
+		h.notify(cb, sessionID, &types.LiveCallbackRecordStatusEvent{
 			SessionID:   sessionID,
 			RecordEvent: types.LiveRecordMp4FileCreated,
 			DownloadURL: h.mkDownloadURL(jobID, status.Mp4Filename),
 			Size:        status.Size,
 			Duration:    status.Duration,
 		})
+		h.notifyRecordFile(cb, sessionID, status)
+	case types.RecordJobPaused:
+		h.notify(cb, sessionID, &types.LiveCallbackRecordStatusEvent{
+			SessionID:    sessionID,
+			RecordEvent:  types.LiveRecordStatusPaused,
+			RecordDetail: "paused by api",
+			Size:         status.Size,
+			Duration:     status.Duration,
+		})
+	case types.RecordJobResumed:
+		h.notify(cb, sessionID, &types.LiveCallbackRecordStatusEvent{
+			SessionID:    sessionID,
+			RecordEvent:  types.LiveRecordStatusResumed,
+			RecordDetail: "resumed by api",
+		})
 	case types.RecordJobEnd:
-		go notify(callbackURL, sessionID, &types.LiveCallbackRecordStatusEvent{
+		h.notify(cb, sessionID, &types.LiveCallbackRecordStatusEvent{
 			SessionID:   sessionID,
 			RecordEvent: types.LiveRecordStatusEnded,
 			DownloadURL: h.mkDownloadURL(jobID, ""),
 			Size:        status.Size,
 			Duration:    status.Duration,
 		})
+		h.notifyRecordFile(cb, sessionID, status)
+		_, err = h.addAttempt(job, status.ExitCode, "")
+		if err != nil {
+			util.WriteError(w, err)
+			return
+		}
 		err = h.jobDB.CompleteAndArchive(int64(jobID), &status.ExitCode)
 		if err != nil {
 			util.WriteError(w, err)
 			return
 		}
 
-		// TODO: save stdout and stderr
+		h.saveStatusLog(jobID, types.LogStreamStdout, status.Stdout)
+		h.saveStatusLog(jobID, types.LogStreamStderr, status.Stderr)
 		util.WriteBody(w, status)
 	case types.RecordJobException:
-		go notify(callbackURL, sessionID, &types.LiveCallbackRecordStatusEvent{
-			SessionID:   sessionID,
-			RecordEvent: types.LiveRecordStatusError,
-			DownloadURL: h.mkDownloadURL(jobID, ""),
-			Size:        status.Size,
-			Duration:    status.Duration,
+		h.saveStatusLog(jobID, types.LogStreamStdout, status.Stdout)
+		h.saveStatusLog(jobID, types.LogStreamStderr, status.Stderr)
+
+		detail := fmt.Sprintf("ffmpeg exited with code %d", status.ExitCode)
+		if status.Detail != "" {
+			detail = status.Detail
+		}
+		attempts, err := h.addAttempt(job, status.ExitCode, detail)
+		if err != nil {
+			util.WriteError(w, err)
+			return
+		}
+		h.notifyException(cb, sessionID, types.LiveAbnormalRecordFailed, detail)
+
+		// recording stops for good when media dir runs out of space, instead of being retried into it
+		if delay, ok := h.retryDelay(job, attempts); ok && status.ExitCode != types.ExitCodeNoSpace {
+			retried, err := h.jobDB.Retry(jobID, *job.RunningHost, time.Now().Add(delay))
+			if err != nil {
+				util.WriteError(w, err)
+				return
+			}
+			if retried {
+				h.logger.Warnf("job %d failed with code %d, retry in %s", jobID, status.ExitCode, delay)
+				h.notify(cb, sessionID, &types.LiveCallbackRecordStatusEvent{
+					SessionID:    sessionID,
+					RecordEvent:  types.LiveRecordStatusPaused,
+					RecordDetail: fmt.Sprintf("%s, retry in %s", detail, delay),
+					DownloadURL:  h.mkDownloadURL(jobID, ""),
+					Size:         status.Size,
+					Duration:     status.Duration,
+				})
+				util.WriteBody(w, status)
+				return
+			}
+		}
+
+		h.notify(cb, sessionID, &types.LiveCallbackRecordStatusEvent{
+			SessionID:    sessionID,
+			RecordEvent:  types.LiveRecordStatusError,
+			RecordDetail: detail,
+			DownloadURL:  h.mkDownloadURL(jobID, ""),
+			Size:         status.Size,
+			Duration:     status.Duration,
 		})
+		// what is recorded before the failure is still kept
+		h.notifyRecordFile(cb, sessionID, status)
 		err = h.jobDB.CompleteAndArchive(int64(jobID), &status.ExitCode)
 		if err != nil {
 			util.WriteError(w, err)
 			return
 		}
-
-		// TODO: save stdout and stderr
 		util.WriteBody(w, status)
 	}
 }
@@ -144,12 +272,33 @@ func (h *Handler) getJob(w http.ResponseWriter, r *http.Request) {
 		util.WriteError(w, err)
 		return
 	}
+	if job != nil {
+		job.Attempts, err = h.jobDB.ListAttempts(jobID)
+		if err != nil {
+			util.WriteError(w, err)
+			return
+		}
+	}
 	util.WriteBody(w, job)
 }
 
 func (h *Handler) acquireJob(w http.ResponseWriter, r *http.Request) {
 	runner := mux.Vars(r)[types.ID]
 
+	// runner registers itself while acquiring job. body is optional for old runners.
+	hb := &types.JobHeartbeat{}
+	err := json.NewDecoder(r.Body).Decode(hb)
+	if err != nil && err != io.EOF {
+		util.WriteError(w, err)
+		return
+	}
+	h.registerRunner(runner, hb)
+
+	if hb.Capacity > 0 && len(hb.Jobs) >= hb.Capacity {
+		// runner is full
+		return
+	}
+
 	job, err := h.jobDB.Acquire(runner, time.Now().Add(h.cfg.ScheduleInterval))
 	if err != nil {
 		util.WriteError(w, err)
@@ -0,0 +1,284 @@
+package manager
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/leslie-wang/clusterd/common/util"
+	"github.com/leslie-wang/clusterd/types"
+)
+
+var errInvalidLogStream = errors.New("log stream must be stdout or stderr")
+
+// jobLogFilename returns where the job log of given stream is kept on manager
+func (h *Handler) jobLogFilename(id int, stream string) string {
+	return filepath.Join(h.cfg.LogDir, "jobs", strconv.Itoa(id), stream+".log")
+}
+
+func parseLogStream(r *http.Request) (string, error) {
+	stream := r.URL.Query().Get(types.LogStream)
+	switch stream {
+	case "":
+		// ffmpeg writes its logs into stderr
+		return types.LogStreamStderr, nil
+	case types.LogStreamStdout, types.LogStreamStderr:
+		return stream, nil
+	}
+	return "", errInvalidLogStream
+}
+
+// uploadJobLog writes the log content uploaded by runner at given offset
+func (h *Handler) uploadJobLog(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)[types.ID])
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+
+	stream, err := parseLogStream(r)
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.URL.Query().Get(types.LogOffset), 10, 64)
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+
+	fname := h.jobLogFilename(id, stream)
+	err = os.MkdirAll(filepath.Dir(fname), 0755)
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+	if offset > stat.Size() {
+		util.WriteError(w, errors.New("log offset is beyond the uploaded content"))
+		return
+	}
+
+	// runner may upload the same content again after failure, so overwrite from offset
+	err = f.Truncate(offset)
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+	_, err = io.Copy(f, r.Body)
+	if err != nil {
+		util.WriteError(w, err)
+	}
+}
+
+// jobLogSize replies the size of uploaded log as Content-Length, which is 0 if nothing is uploaded yet.
+// Runner continues uploading after it, so logs of previous attempts are kept.
+func (h *Handler) jobLogSize(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)[types.ID])
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+
+	stream, err := parseLogStream(r)
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+
+	var size int64
+	stat, err := os.Stat(h.jobLogFilename(id, stream))
+	if err == nil {
+		size = stat.Size()
+	} else if !os.IsNotExist(err) {
+		util.WriteError(w, err)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+}
+
+// saveStatusLog keeps the log carried by job status, if runner didn't upload any log of the stream
+func (h *Handler) saveStatusLog(id int, stream, content string) {
+	if content == "" {
+		return
+	}
+
+	fname := h.jobLogFilename(id, stream)
+	if _, err := os.Stat(fname); err == nil {
+		return
+	}
+
+	err := os.MkdirAll(filepath.Dir(fname), 0755)
+	if err == nil {
+		err = os.WriteFile(fname, []byte(content), 0644)
+	}
+	if err != nil {
+		h.logger.Warnf("save job %d %s log: %s", id, stream, err)
+	}
+}
+
+// jobLog serves the job log. "tail" limits the output to last lines, and "follow" keeps
+// streaming new content until the job is finished.
+func (h *Handler) jobLog(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)[types.ID])
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+
+	stream, err := parseLogStream(r)
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	tail := 0
+	if val := q.Get(types.LogTail); val != "" {
+		tail, err = strconv.Atoi(val)
+		if err != nil {
+			util.WriteError(w, err)
+			return
+		}
+	}
+	follow := false
+	if val := q.Get(types.LogFollow); val != "" {
+		follow, err = strconv.ParseBool(val)
+		if err != nil {
+			util.WriteError(w, err)
+			return
+		}
+	}
+
+	job, err := h.jobDB.Get(id)
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+	if job == nil {
+		util.WriteError(w, util.ErrNotExist)
+		return
+	}
+
+	f, err := os.Open(h.jobLogFilename(id, stream))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			util.WriteError(w, err)
+			return
+		}
+		if job.EndTime != nil {
+			util.WriteError(w, util.ErrNotExist)
+			return
+		}
+		// runner hasn't uploaded anything yet
+		if !follow {
+			return
+		}
+		f, err = h.waitJobLog(r, id, stream)
+		if err != nil {
+			util.WriteError(w, err)
+			return
+		}
+		if f == nil {
+			return
+		}
+	}
+	defer f.Close()
+
+	if tail > 0 {
+		stat, err := f.Stat()
+		if err != nil {
+			util.WriteError(w, err)
+			return
+		}
+		offset, err := util.TailOffset(f, stat.Size(), tail)
+		if err != nil {
+			util.WriteError(w, err)
+			return
+		}
+		_, err = f.Seek(offset, io.SeekStart)
+		if err != nil {
+			util.WriteError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	flusher, _ := w.(http.Flusher)
+	for {
+		_, err = io.Copy(w, f)
+		if err != nil {
+			h.logger.Warnf("serve job %d %s log: %s", id, stream, err)
+			return
+		}
+		if !follow {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		job, err = h.jobDB.Get(id)
+		if err != nil || job == nil || job.EndTime != nil {
+			// drain the content uploaded before archive
+			_, _ = io.Copy(w, f)
+			return
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// waitJobLog waits the log file is uploaded while following a running job
+func (h *Handler) waitJobLog(r *http.Request, id int, stream string) (*os.File, error) {
+	for {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+			return nil, nil
+		}
+
+		f, err := os.Open(h.jobLogFilename(id, stream))
+		if err == nil {
+			return f, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		job, err := h.jobDB.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if job == nil || job.EndTime != nil {
+			return nil, nil
+		}
+	}
+}
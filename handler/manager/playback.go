@@ -1,16 +1,24 @@
 package manager
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/leslie-wang/clusterd/common/hls"
 	"github.com/leslie-wang/clusterd/common/mp4processor"
+	"github.com/leslie-wang/clusterd/common/storage"
 	"github.com/leslie-wang/clusterd/common/util"
 	"github.com/leslie-wang/clusterd/types"
 )
@@ -39,29 +47,46 @@ func (h *Handler) playback(w http.ResponseWriter, r *http.Request) {
 		filename = defaultIndexFile
 	}
 
-	http.ServeFile(w, r, filepath.Join(h.cfg.MediaDir, jobID, filename))
+	obj, err := h.store.Open(r.Context(), storage.JoinKey(jobID, filename))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	defer obj.Close()
+
+	http.ServeContent(w, r, filename, obj.Info().ModTime, obj)
 }
 
-func (h *Handler) mkNewInitfile(f string, duration uint64) (*os.File, error) {
-	newInitFile, err := os.CreateTemp("", "init-mp4-")
+// writeStoreError writes error of the store, 404 if the file doesn't exist
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrNotExist) {
+		http.Error(w, util.ErrNotExist.Error(), http.StatusNotFound)
+		return
+	}
+	util.WriteError(w, err)
+}
+
+// mkNewInitfile returns the init segment with duration of the whole recording
+func (h *Handler) mkNewInitfile(ctx context.Context, key string, duration uint64) ([]byte, error) {
+	obj, err := h.store.Open(ctx, key)
 	if err != nil {
 		return nil, err
 	}
-	err = mp4processor.RewriteDuration(f, newInitFile,
-		duration, duration)
+	defer obj.Close()
+
+	buf := &bytes.Buffer{}
+	err = mp4processor.RewriteInitDuration(obj, buf, duration, duration)
 	if err != nil {
 		return nil, err
 	}
-
-	_, err = newInitFile.Seek(0, io.SeekStart)
-	return newInitFile, err
+	return buf.Bytes(), nil
 }
 
 func (h *Handler) download(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	jobID := vars[types.ID]
+	ctx := r.Context()
 
-	dir := filepath.Join(h.cfg.MediaDir, jobID)
 	filename := vars["filename"]
 	if filename == "" {
 		filename = defaultIndexFile
@@ -69,74 +94,248 @@ func (h *Handler) download(w http.ResponseWriter, r *http.Request) {
 		filename = strings.Trim(filename, filepath.Ext(filename)) + ".m3u8"
 	}
 
+	mode := r.URL.Query().Get(types.DownloadMode)
+	if mode != "" && mode != types.DownloadModeFragmented && mode != types.DownloadModeProgressive {
+		util.WriteError(w, fmt.Errorf("invalid download mode %s", mode))
+		return
+	}
+
 	h.logger.Infof("Serving %s", filename)
 
-	mediaPL, err := hls.ParseMediaPlaylist(filepath.Join(dir, filename))
+	content, err := storage.ReadAll(ctx, h.store, storage.JoinKey(jobID, filename))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	mediaPL, err := hls.UnmarshalMediaPlaylist(content)
 	if err != nil {
 		util.WriteError(w, err)
 		return
 	}
 
-	duration := hls.CalculateDuration(mediaPL)
+	if mode == "" {
+		mode = types.DownloadModeFragmented
+	}
+	etag := downloadETag(content, mode, r.URL.Query())
 
-	initFile := defaultInitFile
-	if mediaPL.Map != nil && mediaPL.Map.URI != "" {
-		initFile = mediaPL.Map.URI
+	// a download is usually requested in ranges, e.g. by a player seeking, so it is built once
+	cacheKey := jobID + etag
+	parts, ok := h.downloads.get(cacheKey)
+	if !ok {
+		segments := hls.MediaSegments(content)
+		if mode == types.DownloadModeProgressive {
+			parts, err = h.progressiveParts(ctx, jobID, segments, r.URL.Query())
+		} else {
+			parts, err = h.fragmentedParts(ctx, jobID, segments, hls.CalculateDuration(mediaPL))
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		h.downloads.add(cacheKey, parts)
 	}
+	h.serveDownload(w, r, etag, parts)
+}
 
-	f, err := h.mkNewInitfile(filepath.Join(dir, initFile), duration)
+// fragmentedParts returns parts of fragmented mp4 concatenated by segments. Every attempt of a resumed recording
+// is initialized by its own init segment, which is put in front of its segments. Sizes of segments are listed
+// at once, and they are opened only if the requested range covers them.
+func (h *Handler) fragmentedParts(ctx context.Context, jobID string, segments []hls.Segment,
+	duration uint64) ([]storage.Part, error) {
+	objs, err := h.store.List(ctx, storage.JoinKey(jobID)+"/")
 	if err != nil {
-		util.WriteError(w, err)
-		return
+		return nil, err
 	}
-	defer func() {
-		err := f.Close()
-		if err != nil {
-			h.logger.Warnf("close %s: %s", f.Name(), err)
-			return
+	sizes := map[string]int64{}
+	for _, obj := range objs {
+		sizes[obj.Key] = obj.Size
+	}
+
+	var (
+		parts []storage.Part
+		init  string
+	)
+	for i, seg := range segments {
+		if i == 0 || seg.Init != init {
+			init = seg.Init
+			initSeg, err := h.mkNewInitfile(ctx, storage.JoinKey(jobID, initFileOf(seg)), duration)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, storage.Part{Data: initSeg})
 		}
-		err = os.Remove(f.Name())
-		if err != nil {
-			h.logger.Warnf("remove %s: %v", f.Name(), err)
+
+		key := storage.JoinKey(jobID, seg.URI)
+		size, ok := sizes[key]
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", key, storage.ErrNotExist)
 		}
-	}()
+		parts = append(parts, storage.Part{Key: key, Size: size})
+	}
+	return parts, nil
+}
 
-	files := []*os.File{f}
+// downloadETag returns strong etag of the download. Segments of a finished recording don't change, so the
+// content changes only with the playlist, e.g. once the recording is resumed.
+func downloadETag(content []byte, mode string, q url.Values) string {
+	hash := sha256.New()
+	hash.Write(content)
+	fmt.Fprintf(hash, "\n%s", mode)
+	if mode == types.DownloadModeProgressive {
+		fmt.Fprintf(hash, "\n%s\n%s", q.Get(types.DownloadStart), q.Get(types.DownloadEnd))
+	}
+	return fmt.Sprintf(`"%x"`, hash.Sum(nil)[:16])
+}
+
+// serveDownload serves the mp4 concatenated by parts, with byte ranges and conditional requests by etag
+func (h *Handler) serveDownload(w http.ResponseWriter, r *http.Request, etag string, parts []storage.Part) {
+	content := storage.NewConcatReader(r.Context(), h.store, parts)
+	defer content.Close()
+
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Content-Disposition", "attachment")
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "", time.Time{}, content)
+}
+
+// initFileOf returns the init segment of the media segment
+func initFileOf(seg hls.Segment) string {
+	if seg.Init != "" {
+		return seg.Init
+	}
+	return defaultInitFile
+}
 
-	stat, err := f.Stat()
+// parseDownloadRange returns the time range to download, end 0 is the end of the recording
+func parseDownloadRange(q url.Values) (start, end time.Duration, err error) {
+	parse := func(key string) (time.Duration, error) {
+		val := q.Get(key)
+		if val == "" {
+			return 0, nil
+		}
+		sec, err := strconv.ParseFloat(val, 64)
+		if err != nil || sec < 0 {
+			return 0, fmt.Errorf("invalid %s %s", key, val)
+		}
+		return time.Duration(sec * float64(time.Second)), nil
+	}
+
+	start, err = parse(types.DownloadStart)
 	if err != nil {
-		util.WriteError(w, err)
 		return
 	}
+	end, err = parse(types.DownloadEnd)
+	if err != nil {
+		return
+	}
+	if end != 0 && end <= start {
+		err = fmt.Errorf("end %s is not after start %s", end, start)
+	}
+	return
+}
 
-	contentLength := stat.Size()
+// progressiveParts returns parts of progressive mp4 remuxed from segments within the time range. Segments out of
+// the range are skipped by their durations in the playlist, then samples are trimmed to keyframes around the range.
+func (h *Handler) progressiveParts(ctx context.Context, jobID string, segments []hls.Segment,
+	q url.Values) ([]storage.Part, error) {
+	start, end, err := parseDownloadRange(q)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, seg := range mediaPL.Segments {
-		f, err := os.Open(filepath.Join(dir, seg.URI))
-		if err != nil {
-			util.WriteError(w, err)
-			return
+	var (
+		segs    []mp4processor.Segment
+		keys    []string
+		inits   []io.Reader
+		initOf  = map[string]int{} // <init segment, index in inits>
+		elapsed time.Duration      // since the beginning of the recording
+		offset  time.Duration      // beginning of the first segment in the range
+	)
+	for _, seg := range segments {
+		segStart := elapsed
+		elapsed += seg.Duration
+		if elapsed <= start {
+			continue
+		}
+		if end != 0 && segStart >= end {
+			break
+		}
+		if len(segs) == 0 {
+			offset = segStart
 		}
-		defer f.Close()
-		files = append(files, f)
 
-		stat, err = f.Stat()
-		if err != nil {
-			util.WriteError(w, err)
-			return
+		// every attempt of a resumed recording has its own init segment
+		initFile := initFileOf(seg)
+		init, ok := initOf[initFile]
+		if !ok {
+			data, err := storage.ReadAll(ctx, h.store, storage.JoinKey(jobID, initFile))
+			if err != nil {
+				return nil, err
+			}
+			init = len(inits)
+			initOf[initFile] = init
+			inits = append(inits, bytes.NewReader(data))
 		}
-		contentLength += stat.Size()
+
+		// segments are opened one at a time by remuxing, a long recording has too many to keep open
+		key := storage.JoinKey(jobID, seg.URI)
+		segs = append(segs, mp4processor.Segment{Init: init, Open: func() (io.ReadSeekCloser, error) {
+			return h.store.Open(ctx, key)
+		}})
+		keys = append(keys, key)
+	}
+	if len(segs) == 0 {
+		return nil, fmt.Errorf("no recorded content since %s", start)
 	}
 
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", contentLength))
-	w.Header().Set("Content-Type", "video/mp4")
-	w.Header().Set("Content-Disposition", "attachment")
+	start -= offset
+	if end != 0 {
+		end -= offset
+	}
+	mp4, err := mp4processor.Remux(inits, segs, start, end)
+	if err != nil {
+		return nil, err
+	}
 
-	for i, f := range files {
-		_, err = io.Copy(w, f)
-		if err != nil {
-			h.logger.Warnf("Download recorded content %s's %d segmenbt: %s", jobID, i+1, err)
-			return
-		}
+	// segments are opened again only if the requested range covers them
+	parts := []storage.Part{{Data: mp4.Header}}
+	for _, c := range mp4.Chunks {
+		parts = append(parts, storage.Part{Key: keys[c.Segment], Offset: c.Offset, Size: c.Size})
+	}
+	return parts, nil
+}
+
+const downloadCacheSize = 32
+
+// downloadCache keeps parts of recently served downloads by job and etag
+type downloadCache struct {
+	lock  *sync.Mutex
+	keys  []string // in order of being added
+	parts map[string][]storage.Part
+}
+
+func newDownloadCache() *downloadCache {
+	return &downloadCache{lock: &sync.Mutex{}, parts: map[string][]storage.Part{}}
+}
+
+func (c *downloadCache) get(key string) ([]storage.Part, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	parts, ok := c.parts[key]
+	return parts, ok
+}
+
+// add keeps parts of the download, the earliest one is dropped once it is full
+func (c *downloadCache) add(key string, parts []storage.Part) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.parts[key]; ok {
+		return
+	}
+	if len(c.keys) >= downloadCacheSize {
+		delete(c.parts, c.keys[0])
+		c.keys = c.keys[1:]
 	}
+	c.keys = append(c.keys, key)
+	c.parts[key] = parts
 }
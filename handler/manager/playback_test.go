@@ -0,0 +1,266 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Eyevinn/mp4ff/mp4"
+	"github.com/leslie-wang/clusterd/common/storage"
+	"github.com/leslie-wang/clusterd/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testJobID         = "1"
+	testSegmentFrames = 60 // 2s of 30fps video, keyframe every 30 frames
+)
+
+// resumedPlaylist is recorded in two attempts, the second one is initialized by init_1.mp4
+const resumedPlaylist = `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:2.000000,
+0.m4s
+#EXTINF:2.000000,
+1.m4s
+#EXT-X-DISCONTINUITY
+#EXT-X-MAP:URI="init_1.mp4"
+#EXTINF:2.000000,
+2.m4s
+#EXTINF:2.000000,
+3.m4s
+#EXT-X-ENDLIST
+`
+
+// testAttempt is how ffmpeg of an attempt writes video, whose track id and timescale may change after resume
+type testAttempt struct {
+	init      string
+	trackID   uint32
+	timescale uint32
+	segments  []int
+}
+
+var testAttempts = []testAttempt{
+	{init: "init.mp4", trackID: 1, timescale: 90000, segments: []int{0, 1}},
+	{init: "init_1.mp4", trackID: 2, timescale: 15360, segments: []int{2, 3}},
+}
+
+func testSampleData(i int) []byte {
+	data := make([]byte, 4+i%5)
+	data[0], data[1] = byte(i>>8), byte(i)
+	return data
+}
+
+// putResumedRecording puts media of resumedPlaylist into the store. Timestamps restart in every attempt.
+func putResumedRecording(t *testing.T, h *Handler) map[string][]byte {
+	files := map[string][]byte{"index.m3u8": []byte(resumedPlaylist)}
+	for _, a := range testAttempts {
+		init := mp4.CreateEmptyInit()
+		init.AddEmptyTrack(a.timescale, "video", "und")
+		trak := init.Moov.Trak
+		trak.Tkhd.TrackID = a.trackID
+		init.Moov.Mvex.Trex.TrackID = a.trackID
+		trak.Tkhd.Width, trak.Tkhd.Height = 640<<16, 360<<16
+		trak.Mdia.Minf.Stbl.Stsd.AddChild(mp4.CreateVisualSampleEntryBox("avc1", 640, 360, nil))
+		buf := &bytes.Buffer{}
+		require.Nil(t, init.Encode(buf))
+		files[a.init] = buf.Bytes()
+
+		dur := a.timescale / 30
+		for n, s := range a.segments {
+			frag, err := mp4.CreateFragment(uint32(s+1), a.trackID)
+			require.Nil(t, err)
+			for i := 0; i < testSegmentFrames; i++ {
+				flags := mp4.NonSyncSampleFlags
+				if i%30 == 0 {
+					flags = mp4.SyncSampleFlags
+				}
+				data := testSampleData(s*testSegmentFrames + i)
+				require.Nil(t, frag.AddFullSampleToTrack(mp4.FullSample{
+					Sample:     mp4.NewSample(flags, dur, uint32(len(data)), 0),
+					DecodeTime: uint64((n*testSegmentFrames + i) * int(dur)),
+					Data:       data,
+				}, a.trackID))
+			}
+			buf = &bytes.Buffer{}
+			require.Nil(t, frag.Encode(buf))
+			files[fmt.Sprintf("%d.m4s", s)] = buf.Bytes()
+		}
+	}
+
+	for name, data := range files {
+		require.Nil(t, h.store.Put(context.Background(), storage.JoinKey(testJobID, name),
+			bytes.NewReader(data), int64(len(data))))
+	}
+	return files
+}
+
+func TestDownloadResumed(t *testing.T) {
+	h := newTestHandler(t)
+	files := putResumedRecording(t, h)
+
+	w := get(h, types.URLDownload+"/"+testJobID, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	body := w.Body.Bytes()
+
+	// every attempt is initialized by its own init segment
+	var expected []byte
+	for _, a := range testAttempts {
+		// ftyp and moov
+		init := body[len(expected):]
+		ftypSize := binary.BigEndian.Uint32(init)
+		init = init[:ftypSize+binary.BigEndian.Uint32(init[ftypSize:])]
+		f, err := mp4.DecodeFile(bytes.NewReader(init))
+		require.Nil(t, err)
+		require.NotNil(t, f.Moov)
+		assert.Equal(t, a.trackID, f.Moov.Trak.Tkhd.TrackID)
+		// duration of the whole recording
+		assert.Equal(t, uint64(8000), f.Moov.Mvhd.Duration)
+
+		expected = append(expected, init...)
+		for _, s := range a.segments {
+			expected = append(expected, files[fmt.Sprintf("%d.m4s", s)]...)
+		}
+		assert.True(t, bytes.HasPrefix(body, expected))
+	}
+	assert.Equal(t, len(expected), len(body))
+	assert.Equal(t, 2, strings.Count(string(body), "moov"))
+}
+
+func TestDownloadProgressiveResumed(t *testing.T) {
+	h := newTestHandler(t)
+	putResumedRecording(t, h)
+
+	w := get(h, types.URLDownload+"/"+testJobID+"?"+types.DownloadMode+"="+types.DownloadModeProgressive, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	f, err := mp4.DecodeFile(bytes.NewReader(w.Body.Bytes()))
+	require.Nil(t, err)
+	require.Len(t, f.Moov.Traks, 1)
+	trak := f.Moov.Trak
+	// samples of the second attempt are rescaled into the track of the first one
+	assert.Equal(t, uint32(1), trak.Tkhd.TrackID)
+	assert.Equal(t, uint32(90000), trak.Mdia.Mdhd.Timescale)
+	assert.Equal(t, uint64(4*testSegmentFrames*3000), trak.Mdia.Mdhd.Duration)
+
+	n := 4 * testSegmentFrames
+	require.Equal(t, uint32(n), trak.GetNrSamples())
+	buf := &bytes.Buffer{}
+	require.Nil(t, f.CopySampleData(buf, nil, trak, 1, uint32(n), nil))
+	var expected []byte
+	for i := 0; i < n; i++ {
+		expected = append(expected, testSampleData(i)...)
+	}
+	assert.Equal(t, expected, buf.Bytes())
+}
+
+// countingStore records objects opened, and the most of them open at the same time
+type countingStore struct {
+	storage.Store
+	opened  []string
+	open    int
+	maxOpen int
+}
+
+// countedObject counts itself closed in its store
+type countedObject struct {
+	storage.Object
+	store *countingStore
+}
+
+func (o countedObject) Close() error {
+	o.store.open--
+	return o.Object.Close()
+}
+
+func (s *countingStore) Open(ctx context.Context, key string) (storage.Object, error) {
+	s.opened = append(s.opened, key)
+	obj, err := s.Store.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	s.open++
+	s.maxOpen = max(s.maxOpen, s.open)
+	return countedObject{Object: obj, store: s}, nil
+}
+
+// objects are opened one at a time while the download is built and served
+func TestDownloadProgressiveOpensOneAtATime(t *testing.T) {
+	h := newTestHandler(t)
+	putResumedRecording(t, h)
+	store := &countingStore{Store: h.store}
+	h.store = store
+
+	w := get(h, types.URLDownload+"/"+testJobID+"?"+types.DownloadMode+"="+types.DownloadModeProgressive, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 1, store.maxOpen)
+	assert.Zero(t, store.open)
+}
+
+// download is built once, then ranges of it open only the objects they cover
+func TestDownloadOpensRange(t *testing.T) {
+	h := newTestHandler(t)
+	putResumedRecording(t, h)
+	store := &countingStore{Store: h.store}
+	h.store = store
+	playlist := storage.JoinKey(testJobID, "index.m3u8")
+
+	for _, mode := range []string{types.DownloadModeFragmented, types.DownloadModeProgressive} {
+		url := types.URLDownload + "/" + testJobID + "?" + types.DownloadMode + "=" + mode
+		w := get(h, url, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		full := w.Body.Bytes()
+
+		// the last byte is in the last segment
+		store.opened = nil
+		w = get(h, url, http.Header{"Range": {"bytes=-1"}})
+		require.Equal(t, http.StatusPartialContent, w.Code, mode)
+		assert.Equal(t, full[len(full)-1:], w.Body.Bytes())
+		assert.Equal(t, []string{playlist, storage.JoinKey(testJobID, "3.m4s")}, store.opened, mode)
+
+		// the first bytes are built in memory
+		store.opened = nil
+		w = get(h, url, http.Header{"Range": {"bytes=0-7"}})
+		require.Equal(t, http.StatusPartialContent, w.Code, mode)
+		assert.Equal(t, full[:8], w.Body.Bytes())
+		assert.Equal(t, []string{playlist}, store.opened, mode)
+	}
+}
+
+// ranges beyond the end are rejected, and the ones across segments are served from every segment
+func TestDownloadRangeNotSatisfiable(t *testing.T) {
+	h := newTestHandler(t)
+	files := putResumedRecording(t, h)
+
+	urls := []string{types.URLPlay + "/" + testJobID + "/index.m3u8"}
+	for _, mode := range []string{types.DownloadModeFragmented, types.DownloadModeProgressive} {
+		urls = append(urls, types.URLDownload+"/"+testJobID+"?"+types.DownloadMode+"="+mode)
+	}
+	for _, url := range urls {
+		w := get(h, url, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		full := w.Body.Bytes()
+		if strings.HasSuffix(url, ".m3u8") {
+			assert.Equal(t, files["index.m3u8"], full)
+		}
+
+		w = get(h, url, http.Header{"Range": {fmt.Sprintf("bytes=%d-", len(full))}})
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code, url)
+		assert.Equal(t, fmt.Sprintf("bytes */%d", len(full)), w.Header().Get("Content-Range"), url)
+
+		start, end := len(full)/4, len(full)*3/4
+		w = get(h, url, http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", start, end)}})
+		require.Equal(t, http.StatusPartialContent, w.Code, url)
+		assert.Equal(t, fmt.Sprintf("bytes %d-%d/%d", start, end, len(full)), w.Header().Get("Content-Range"), url)
+		assert.Equal(t, full[start:end+1], w.Body.Bytes(), url)
+	}
+}
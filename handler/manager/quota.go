@@ -0,0 +1,61 @@
+package manager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/leslie-wang/clusterd/common/model"
+)
+
+// anyDomain is the key of quota for domains without their own quota
+const anyDomain = "*"
+
+// domainQuota returns bytes of recorded files the domain can keep, or 0 if it is unlimited
+func (h *Handler) domainQuota(domain string) int64 {
+	if quota, ok := h.cfg.DomainQuotas[domain]; ok {
+		return quota
+	}
+	return h.cfg.DomainQuotas[anyDomain]
+}
+
+// checkDomainQuota returns error if recorded files of the domain have used up its quota. Files of finished
+// recordings are counted until they expire, and the ones being recorded are not.
+func (h *Handler) checkDomainQuota(domain string) error {
+	quota := h.domainQuota(domain)
+	if quota <= 0 {
+		return nil
+	}
+
+	err := h.scheduleRetentions()
+	if err != nil {
+		return err
+	}
+	used, err := h.retentionDB.DomainUsage(domain)
+	if err != nil {
+		return err
+	}
+	if used >= quota {
+		return fmt.Errorf("%s: domain %s has used %d bytes of its %d bytes storage quota", model.LIMITEXCEEDED,
+			domain, used, quota)
+	}
+	return nil
+}
+
+// ParseDomainQuotas parses quotas like "test.play.com=1024", whose size is in megabytes. Domain "*" is for
+// domains without their own quota.
+func ParseDomainQuotas(values []string) (map[string]int64, error) {
+	quotas := map[string]int64{}
+	for _, v := range values {
+		domain, size, ok := strings.Cut(v, "=")
+		if !ok || domain == "" {
+			return nil, fmt.Errorf("invalid domain quota %s, need domain=megabytes", v)
+		}
+		mb, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || mb < 0 {
+			return nil, fmt.Errorf("invalid domain quota %s, need domain=megabytes", v)
+		}
+		quotas[domain] = mb << 20
+	}
+	return quotas, nil
+}
@@ -1,14 +1,14 @@
 package manager
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
+	"time"
 
-	"github.com/leslie-wang/clusterd/common"
 	"github.com/leslie-wang/clusterd/common/model"
 	"github.com/leslie-wang/clusterd/common/util"
 	"github.com/leslie-wang/clusterd/types"
@@ -31,6 +31,8 @@ const (
 	ActionCreateRecordTask   = "CreateRecordTask"
 	ActionDeleteRecordTask   = "DeleteRecordTask"
 	ActionStopRecordTask     = "StopRecordTask"
+	ActionPauseRecordTask    = "PauseRecordTask"
+	ActionResumeRecordTask   = "ResumeRecordTask"
 
 	ActionDeleteRecordFile = "DeleteRecordFile"
 
@@ -43,6 +45,9 @@ const (
 	ActionDescribeLiveCallbackTemplates = "DescribeLiveCallbackTemplates"
 	ActionDeleteLiveCallbackTemplate    = "DeleteLiveCallbackTemplate"
 	ActionModifyLiveCallbackTemplate    = "ModifyLiveCallbackTemplate"
+
+	ActionDescribeCallbackRecordsList = "DescribeCallbackRecordsList"
+	ActionReplayCallbackRecord        = "ReplayCallbackRecord"
 )
 
 // Template - Generic
@@ -108,6 +113,11 @@ const (
 	EndTime    = "EndTime"
 	StartTime  = "StartTime"
 	StreamType = "StreamType"
+	Priority   = "Priority"
+
+	RetryMaxAttempts = "RetryMaxAttempts"
+	RetryBackoff     = "RetryBackoff"
+	RetryWindow      = "RetryWindow"
 )
 
 /*
@@ -131,6 +141,8 @@ func (h *Handler) record(w http.ResponseWriter, r *http.Request) {
 		resp, err = h.handleListLiveRecordTemplates()
 	case ActionDeleteLiveRecordTemplate:
 		resp, err = h.handleDeleteLiveRecordTemplate(q)
+	case ActionModifyLiveRecordTemplate:
+		resp, err = h.handleModifyLiveRecordTemplate(q, r.Body)
 
 	case ActionCreateLiveRecordRule:
 		resp, err = h.handleCreateLiveRecordRule(q)
@@ -146,6 +158,11 @@ func (h *Handler) record(w http.ResponseWriter, r *http.Request) {
 	case ActionDeleteRecordTask:
 		resp, err = h.handleDeleteRecordTask(q)
 	case ActionStopRecordTask:
+		resp, err = h.handleStopRecordTask(q)
+	case ActionPauseRecordTask:
+		resp, err = h.handlePauseRecordTask(q)
+	case ActionResumeRecordTask:
+		resp, err = h.handleResumeRecordTask(q)
 
 	case ActionDescribeLiveCallbackRules:
 		resp, err = h.handleDescribeLiveCallbackRules()
@@ -162,6 +179,13 @@ func (h *Handler) record(w http.ResponseWriter, r *http.Request) {
 		resp, err = h.handleDescribeLiveCallbackTemplate(q)
 	case ActionDeleteLiveCallbackTemplate:
 		resp, err = h.handleDeleteLiveCallbackTemplate(q)
+	case ActionModifyLiveCallbackTemplate:
+		resp, err = h.handleModifyLiveCallbackTemplate(q, r.Body)
+
+	case ActionDescribeCallbackRecordsList:
+		resp, err = h.handleDescribeCallbackRecordsList(q)
+	case ActionReplayCallbackRecord:
+		resp, err = h.handleReplayCallbackRecord(q)
 
 	case ActionDeleteRecordFile:
 		err = h.handleDeleteRecordFile(q)
@@ -198,11 +222,9 @@ func (h *Handler) handleDeleteRecordFile(q url.Values) error {
 		return err
 	}
 
-	storePath := r.StorePath
-	if storePath == "" {
-		storePath = h.cfg.MediaDir
+	err = h.removeRecordFiles(context.Background(), id, r)
+	if err != nil {
+		return err
 	}
-	dir := common.MkStoragePath(storePath, tid)
-
-	return os.RemoveAll(dir)
+	return h.retentionDB.MarkJobExpired(id, time.Now())
 }
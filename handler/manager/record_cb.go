@@ -3,8 +3,15 @@ package manager
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
+
+	"github.com/leslie-wang/clusterd/common/util"
+	"github.com/leslie-wang/clusterd/types"
 )
 
 /*
@@ -21,28 +28,315 @@ func (h *Handler) notifyPushEvent(url string, typ types.LiveCallbackEventType) {
 
 const (
 	retryNotifyCount    = 12
-	retryNotifyInterval = time.Minute
+	defaultNotifyDelay  = 10 * time.Second
+	maxNotifyDelay      = 10 * time.Minute
+	notifyTimeout       = 10 * time.Second
+	notifyPollInterval  = 5 * time.Second
+	notifyBatchSize     = 100
+	maxNotifyResponse   = 1024
+	keepDeliveredNotify = 7 * 24 * time.Hour
+	// callback sign expires after this duration since sent
+	callbackSignExpiry = 10 * time.Minute
 )
 
-func notify(url string, sessionID string, event interface{}) {
+// result code of callback which isn't answered by http status
+const (
+	notifyResultOK    = 0
+	notifyResultError = 1
+)
+
+var notifyClient = &http.Client{Timeout: notifyTimeout}
+
+// callback is where and how the events of a job are notified
+type callback struct {
+	url    string                                 // where to notify events without url in urls
+	urls   map[types.LiveCallbackEventType]string // where to notify each type of events
+	key    string                                 // events are not signed if key is empty
+	method string                                 // sign method
+	taskID int64                                  // record task of the recording
+	domain string                                 // push domain of the recording
+	app    string                                 // push path of the recording
+	stream string                                 // stream name of the recording, to look up callbacks by stream
+}
+
+// urlOf returns where to notify the type of events, or empty if they are not notified
+func (cb *callback) urlOf(typ types.LiveCallbackEventType) string {
+	if u, ok := cb.urls[typ]; ok {
+		return u
+	}
+	return cb.url
+}
+
+// notify saves the event into callback outbox. Workers deliver events of one session in order, and retry
+// until the receiver accepts it.
+func (h *Handler) notify(cb *callback, sessionID string, event interface{}) {
+	typ := setEventType(event)
+	url := cb.urlOf(typ)
+	if url == "" {
+		return
+	}
+
 	content, err := json.Marshal(event)
 	if err != nil {
-		defaultLogger.Warnf("generate json while notifying %v: %s", event, err)
+		h.logger.Warnf("generate json while notifying %v: %s", event, err)
+		return
+	}
+
+	err = h.callbackDB.Insert(&types.Callback{
+		SessionID:  sessionID,
+		StreamName: cb.stream,
+		URL:        url,
+		SignKey:    cb.key,
+		SignMethod: cb.method,
+		EventType:  typ,
+		Content:    string(content),
+		NextTime:   time.Now(),
+	})
+	if err != nil {
+		h.logger.Warnf("save callback %s to %s: %s", string(content), url, err)
 		return
 	}
-	defaultLogger.Infof("jobd %s notify %s: %s", sessionID, url, string(content))
-	buf := bytes.NewBuffer(content)
-	for i := 0; i < retryNotifyCount; i++ {
-		resp, err := http.Post(url, "application/json", buf)
-		if err == nil && resp.StatusCode == http.StatusOK {
-			resp.Body.Close()
+	h.logger.Infof("jobd %s notify %s: %s", sessionID, url, string(content))
+	h.wakeCallbacks()
+}
+
+// notifyException notifies the abnormal event of the recording, besides the status change caused by it
+func (h *Handler) notifyException(cb *callback, sessionID string, typ int, desc string) {
+	now := time.Now()
+	h.notify(cb, sessionID, &types.LiveCallbackStreamExceptionEvent{
+		StreamID: cb.stream,
+		DataTime: int(now.Unix()),
+		AbnormalEvent: []types.LiveCallbackAbnormalEvent{{
+			Type:  typ,
+			Count: 1,
+			Detail: []types.LiveCallbackAbnormalDetail{{
+				Desc:      desc,
+				OccurTime: now.UTC().Format(time.RFC3339),
+			}},
+			DescEN: desc,
+		}},
+	})
+}
+
+// notifyRecordFile notifies the file created by the recording, which is either a mp4 file cut from the
+// recording, or the whole recording when it ends. Nothing is notified if nothing is recorded.
+func (h *Handler) notifyRecordFile(cb *callback, sessionID string, status *types.JobStatus) {
+	if status.Duration == 0 {
+		return
+	}
+
+	// media time is unknown if it isn't recorded in HLS, then assume the file ends now
+	start, end := status.MediaStartTime, status.MediaEndTime
+	if end == 0 {
+		end = time.Now().UnixMilli()
+		start = end - int64(status.Duration)
+	}
+
+	// the same file has the same id, even if the report is resent
+	fileID := mkRecordFileID(status.ID, status.FileIndex)
+	h.notify(cb, sessionID, &types.LiveCallbackRecordFileEvent{
+		App:            cb.domain,
+		AppName:        cb.app,
+		StreamID:       cb.stream,
+		ChannelID:      cb.stream,
+		FileID:         fileID,
+		RecordFileID:   fileID,
+		FileFormat:     types.RecordFormatMP4,
+		TaskID:         strconv.FormatInt(cb.taskID, 10),
+		StartTime:      start / 1000,
+		EndTime:        end / 1000,
+		StartTimeUsec:  int(start%1000) * 1000,
+		EndTimeUsec:    int(end%1000) * 1000,
+		Duration:       int64(status.Duration / 1000),
+		FileSize:       status.Size,
+		VideoURL:       h.mkDownloadURL(status.ID, status.Mp4Filename),
+		MediaStartTime: start,
+	})
+}
+
+// mkRecordFileID returns id of the file of the job. index is N of dlN.m3u8 which the file is cut from, or 0
+// for the whole recording.
+func mkRecordFileID(jobID, index int) string {
+	return fmt.Sprintf("%d-%d", jobID, index)
+}
+
+// wakeCallbacks lets callback loop deliver due callbacks now, instead of waiting for next poll
+func (h *Handler) wakeCallbacks() {
+	select {
+	case h.callbackWake <- struct{}{}:
+	default:
+	}
+}
+
+// setEventType fills event type of the event by its structure, and returns it
+func setEventType(event interface{}) types.LiveCallbackEventType {
+	switch e := event.(type) {
+	case *types.LiveCallbackStreamEvent:
+		return e.EventType
+	case *types.LiveCallbackRecordFileEvent:
+		e.EventType = types.LiveCallbackEventTypeRecordFile
+		return e.EventType
+	case *types.LiveCallbackStreamExceptionEvent:
+		e.EventType = types.LiveCallbackEventTypeException
+		return e.EventType
+	case *types.LiveCallbackRecordStatusEvent:
+		e.EventType = types.LiveCallbackEventTypeRecordStatus
+		return e.EventType
+	}
+	return types.LiveCallbackEventTypeRecordStatus
+}
+
+// callbackLoop delivers due callbacks in the outbox, and removes old delivered ones
+func (h *Handler) callbackLoop() {
+	var (
+		wg    sync.WaitGroup
+		queue = make(chan *types.Callback)
+	)
+	// workers are done once queue is closed
+	defer close(queue)
+	for i := 0; i < h.cfg.CallbackWorkers; i++ {
+		go func() {
+			for cb := range queue {
+				h.deliver(cb)
+				wg.Done()
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(notifyPollInterval)
+	defer ticker.Stop()
+
+	var lastPurge time.Time
+	for {
+		for {
+			cbs, err := h.callbackDB.ListDue(time.Now(), notifyBatchSize)
+			if err != nil {
+				h.logger.Warnf("list due callbacks: %s", err)
+				break
+			}
+			// at most one callback of each session is listed, so that they are delivered in order
+			wg.Add(len(cbs))
+			for i := range cbs {
+				queue <- &cbs[i]
+			}
+			wg.Wait()
+			if len(cbs) == 0 {
+				break
+			}
+		}
+
+		if time.Since(lastPurge) > time.Hour {
+			lastPurge = time.Now()
+			n, err := h.callbackDB.RemoveDelivered(lastPurge.Add(-keepDeliveredNotify))
+			if err != nil {
+				h.logger.Warnf("remove delivered callbacks: %s", err)
+			} else if n != 0 {
+				h.logger.Infof("removed %d delivered callbacks", n)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-h.callbackWake:
+		case <-h.stop:
 			return
-		} else if err != nil {
-			defaultLogger.Warnf("notify %s: %s", string(content), err)
+		}
+	}
+}
+
+// deliver posts the callback once, and saves the result. Failed callback is retried with exponential backoff,
+// until it fails for retryNotifyCount times.
+func (h *Handler) deliver(cb *types.Callback) {
+	code, response := notifyResultOK, ""
+
+	// sign again for each attempt, so that it isn't expired
+	content, signature, err := signCallback(cb)
+	if err == nil {
+		code, response = post(cb.URL, content, signature)
+	} else {
+		code, response = notifyResultError, err.Error()
+	}
+
+	status, next := types.CallbackDelivered, time.Now()
+	if code != notifyResultOK {
+		attempts := cb.Attempts + 1
+		if attempts >= retryNotifyCount {
+			status = types.CallbackFailed
+			h.logger.Warnf("failed to notify %s after %d attempts: %s", cb.URL, attempts, response)
 		} else {
-			defaultLogger.Warnf("notify %s got %d", string(content), resp.StatusCode)
+			status = types.CallbackPending
+			next = next.Add(notifyDelay(attempts))
+			h.logger.Warnf("notify %s got %d: %s", cb.URL, code, response)
 		}
-		time.Sleep(retryNotifyInterval)
 	}
-	defaultLogger.Warnf("failed to notify %s after retry", string(content))
+
+	err = h.callbackDB.UpdateAttempt(cb.ID, status, code, response, next)
+	if err != nil {
+		h.logger.Warnf("save callback %d result: %s", cb.ID, err)
+	}
+}
+
+// post sends the content to url, and returns result code and response of the receiver
+func post(url string, content []byte, signature string) (int, string) {
+	// new request for each attempt, so that the body is read from the beginning
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(content))
+	if err != nil {
+		return notifyResultError, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if signature != "" {
+		req.Header.Set(util.CallbackSignatureHeader, signature)
+	}
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return notifyResultError, err.Error()
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxNotifyResponse))
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, string(body)
+	}
+	return notifyResultOK, string(body)
+}
+
+// notifyDelay returns how long to wait before next attempt, after the callback failed for given times
+func notifyDelay(failures int) time.Duration {
+	delay := defaultNotifyDelay
+	for i := 1; i < failures && delay < maxNotifyDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxNotifyDelay {
+		delay = maxNotifyDelay
+	}
+	return delay
+}
+
+// signCallback fills sign and t of the event, and returns its content, and signature of the content if the
+// sign method signs whole body. Every event is signed, including the ones saved before it has the fields.
+func signCallback(cb *types.Callback) ([]byte, string, error) {
+	if cb.SignKey == "" {
+		return []byte(cb.Content), "", nil
+	}
+
+	d := json.NewDecoder(bytes.NewReader([]byte(cb.Content)))
+	d.UseNumber()
+	event := map[string]interface{}{}
+	err := d.Decode(&event)
+	if err != nil {
+		return nil, "", err
+	}
+	t := time.Now().Add(callbackSignExpiry).Unix()
+	event["sign"] = util.MkCallbackSign(cb.SignMethod, cb.SignKey, t)
+	event["t"] = json.Number(strconv.FormatInt(t, 10))
+
+	content, err := json.Marshal(event)
+	if err != nil {
+		return nil, "", err
+	}
+	if cb.SignMethod != util.SignMethodHMACSHA256 {
+		return content, "", nil
+	}
+	return content, util.MkCallbackBodySign(cb.SignKey, content), nil
 }
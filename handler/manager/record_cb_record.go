@@ -0,0 +1,220 @@
+package manager
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/db/record"
+	"github.com/leslie-wang/clusterd/common/model"
+	"github.com/leslie-wang/clusterd/common/util"
+	"github.com/leslie-wang/clusterd/types"
+)
+
+// Callback record
+const (
+	CallbackID = "CallbackId"
+	SessionID  = "SessionId"
+	Status     = "Status"
+	EventType  = "EventType"
+	ResultCode = "ResultCode"
+	PageNum    = "PageNum"
+	PageSize   = "PageSize"
+)
+
+const (
+	defaultCallbackPageSize = 20
+	maxCallbackPageSize     = 100
+)
+
+// beijing is the time zone of query time without zone, as Tencent API does
+var beijing = time.FixedZone("CST", 8*60*60)
+
+// handleDescribeCallbackRecordsList lists callbacks in the outbox. Besides Tencent API's filters, it also
+// filters by SessionId and Status, or returns only the callback given by CallbackId.
+func (h *Handler) handleDescribeCallbackRecordsList(q url.Values) (*model.DescribeCallbackRecordsListResponse, error) {
+	if val := q.Get(CallbackID); val != "" {
+		return h.describeCallbackRecord(val)
+	}
+
+	f := &types.CallbackFilter{
+		SessionID:  q.Get(SessionID),
+		StreamName: q.Get(StreamName),
+	}
+	var err error
+	f.StartTime, err = parseCallbackTime(q.Get(StartTime))
+	if err != nil {
+		return nil, err
+	}
+	f.EndTime, err = parseCallbackTime(q.Get(EndTime))
+	if err != nil {
+		return nil, err
+	}
+	if val := q.Get(EventType); val != "" {
+		typ, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, errors.New(model.INVALIDPARAMETERVALUE)
+		}
+		t := types.LiveCallbackEventType(typ)
+		f.EventType = &t
+	}
+	if val := q.Get(Status); val != "" {
+		status, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, errors.New(model.INVALIDPARAMETERVALUE)
+		}
+		s := types.CallbackStatus(status)
+		f.Status = &s
+	}
+	if val := q.Get(ResultCode); val != "" {
+		code, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, errors.New(model.INVALIDPARAMETERVALUE)
+		}
+		f.ResultCode = &code
+	}
+
+	pageNum, pageSize := uint64(1), uint64(defaultCallbackPageSize)
+	if val := q.Get(PageNum); val != "" {
+		pageNum, err = strconv.ParseUint(val, 10, 64)
+		if err != nil || pageNum == 0 {
+			return nil, errors.New(model.INVALIDPARAMETERVALUE)
+		}
+	}
+	if val := q.Get(PageSize); val != "" {
+		pageSize, err = strconv.ParseUint(val, 10, 64)
+		if err != nil || pageSize == 0 || pageSize > maxCallbackPageSize {
+			return nil, errors.New(model.INVALIDPARAMETERVALUE)
+		}
+	}
+	f.Offset = int((pageNum - 1) * pageSize)
+	f.Limit = int(pageSize)
+
+	cbs, total, err := h.callbackDB.List(f)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*model.CallbackEventInfo, 0, len(cbs))
+	for i := range cbs {
+		list = append(list, mkCallbackEventInfo(&cbs[i]))
+	}
+	totalNum := uint64(total)
+	totalPage := (totalNum + pageSize - 1) / pageSize
+	return &model.DescribeCallbackRecordsListResponse{
+		Response: &model.DescribeCallbackRecordsListResponseParams{
+			DataInfoList: list,
+			PageNum:      &pageNum,
+			PageSize:     &pageSize,
+			TotalNum:     &totalNum,
+			TotalPage:    &totalPage,
+		},
+	}, nil
+}
+
+func (h *Handler) describeCallbackRecord(val string) (*model.DescribeCallbackRecordsListResponse, error) {
+	id, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return nil, errors.New(model.INVALIDPARAMETERVALUE)
+	}
+
+	cb, err := h.callbackDB.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if cb == nil {
+		return nil, util.ErrNotExist
+	}
+
+	one := uint64(1)
+	return &model.DescribeCallbackRecordsListResponse{
+		Response: &model.DescribeCallbackRecordsListResponseParams{
+			DataInfoList: []*model.CallbackEventInfo{mkCallbackEventInfo(cb)},
+			PageNum:      &one,
+			PageSize:     &one,
+			TotalNum:     &one,
+			TotalPage:    &one,
+		},
+	}, nil
+}
+
+// handleReplayCallbackRecord sends the delivered or failed callback again
+func (h *Handler) handleReplayCallbackRecord(q url.Values) (*model.ReplayCallbackRecordResponse, error) {
+	val := q.Get(CallbackID)
+	if val == "" {
+		return nil, errors.New(model.INVALIDPARAMETERVALUE)
+	}
+
+	id, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return nil, errors.New(model.INVALIDPARAMETERVALUE)
+	}
+
+	cb, err := h.callbackDB.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if cb == nil {
+		return nil, util.ErrNotExist
+	}
+
+	replayed, err := h.callbackDB.Replay(id)
+	if err != nil {
+		return nil, err
+	}
+	if !replayed {
+		h.logger.Infof("callback %d is pending already", id)
+	}
+
+	h.wakeCallbacks()
+	return &model.ReplayCallbackRecordResponse{Response: &model.ReplayCallbackRecordResponseParams{}}, nil
+}
+
+// parseCallbackTime parses query time in UTC like 2006-01-02T15:04:05Z, or in Beijing time like
+// 2006-01-02 15:04:05
+func parseCallbackTime(val string) (*time.Time, error) {
+	if val == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		t, err = time.ParseInLocation(time.DateTime, val, beijing)
+	}
+	if err != nil {
+		return nil, errors.New(model.INVALIDPARAMETERVALUE)
+	}
+	return &t, nil
+}
+
+func mkCallbackEventInfo(cb *types.Callback) *model.CallbackEventInfo {
+	eventType := uint64(cb.EventType)
+	status := uint64(cb.Status)
+	attempts := uint64(cb.Attempts)
+	info := &model.CallbackEventInfo{
+		EventTime:  record.FormatTime(&cb.CreateTime),
+		EventType:  &eventType,
+		Request:    &cb.Content,
+		CallbackId: &cb.ID,
+		SessionId:  &cb.SessionID,
+		Url:        &cb.URL,
+		Status:     &status,
+		Attempts:   &attempts,
+	}
+	if cb.StreamName != "" {
+		info.StreamId = &cb.StreamName
+	}
+	if cb.Attempts != 0 {
+		info.Response = &cb.Response
+		info.ResponseTime = record.FormatTime(cb.UpdateTime)
+	}
+	if cb.ResultCode != nil {
+		code := uint64(*cb.ResultCode)
+		info.ResultCode = &code
+	}
+	if cb.Status == types.CallbackPending {
+		info.NextTime = record.FormatTime(&cb.NextTime)
+	}
+	return info
+}
@@ -2,6 +2,7 @@ package manager
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"io"
@@ -9,6 +10,7 @@ import (
 	"strconv"
 
 	"github.com/leslie-wang/clusterd/common/model"
+	"github.com/leslie-wang/clusterd/common/util"
 )
 
 func (h *Handler) handleDescribeLiveCallbackTemplate(q url.Values) (*model.DescribeLiveCallbackTemplateResponse, error) {
@@ -76,6 +78,9 @@ func (h *Handler) handleCreateLiveCallbackTemplate(q url.Values, request io.Read
 		if err != nil {
 			return nil, err
 		}
+		if t.TemplateName == nil {
+			return nil, errors.New(model.INVALIDPARAMETERVALUE)
+		}
 	} else {
 		t = &model.CreateLiveCallbackTemplateRequestParams{}
 		err = json.NewDecoder(request).Decode(t)
@@ -96,13 +101,105 @@ func (h *Handler) handleCreateLiveCallbackTemplate(q url.Values, request io.Read
 	}, nil
 }
 
+// handleModifyLiveCallbackTemplate changes the given fields of the template, and keeps the others. Callbacks
+// are sent by the modified template since then, including the ones of running tasks.
+func (h *Handler) handleModifyLiveCallbackTemplate(q url.Values, request io.ReadCloser) (*model.ModifyLiveCallbackTemplateResponse, error) {
+	defer request.Close()
+
+	m := &model.ModifyLiveCallbackTemplateRequestParams{}
+	if h.cfg.ParamQuery {
+		t, err := h.parseLiveCallbackTemplate(q)
+		if err != nil {
+			return nil, err
+		}
+		val := q.Get(TemplateID)
+		if val != "" {
+			id, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			m.TemplateId = &id
+		}
+		m.TemplateName = t.TemplateName
+		m.Description = t.Description
+		m.StreamBeginNotifyUrl = t.StreamBeginNotifyUrl
+		m.StreamEndNotifyUrl = t.StreamEndNotifyUrl
+		m.RecordNotifyUrl = t.RecordNotifyUrl
+		m.RecordStatusNotifyUrl = t.RecordStatusNotifyUrl
+		m.SnapshotNotifyUrl = t.SnapshotNotifyUrl
+		m.PornCensorshipNotifyUrl = t.PornCensorshipNotifyUrl
+		m.CallbackKey = t.CallbackKey
+		m.StreamMixNotifyUrl = t.StreamMixNotifyUrl
+		m.PushExceptionNotifyUrl = t.PushExceptionNotifyUrl
+		m.AudioAuditNotifyUrl = t.AudioAuditNotifyUrl
+	} else {
+		err := json.NewDecoder(request).Decode(m)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if m.TemplateId == nil {
+		return nil, errors.New(model.INVALIDPARAMETERVALUE)
+	}
+
+	t, err := h.recordDB.GetCallbackTemplateByID(*m.TemplateId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, util.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if m.TemplateName != nil {
+		t.TemplateName = m.TemplateName
+	}
+	if m.Description != nil {
+		t.Description = m.Description
+	}
+	if m.StreamBeginNotifyUrl != nil {
+		t.StreamBeginNotifyUrl = m.StreamBeginNotifyUrl
+	}
+	if m.StreamEndNotifyUrl != nil {
+		t.StreamEndNotifyUrl = m.StreamEndNotifyUrl
+	}
+	if m.RecordNotifyUrl != nil {
+		t.RecordNotifyUrl = m.RecordNotifyUrl
+	}
+	if m.RecordStatusNotifyUrl != nil {
+		t.RecordStatusNotifyUrl = m.RecordStatusNotifyUrl
+	}
+	if m.SnapshotNotifyUrl != nil {
+		t.SnapshotNotifyUrl = m.SnapshotNotifyUrl
+	}
+	if m.PornCensorshipNotifyUrl != nil {
+		t.PornCensorshipNotifyUrl = m.PornCensorshipNotifyUrl
+	}
+	if m.CallbackKey != nil {
+		t.CallbackKey = m.CallbackKey
+	}
+	if m.StreamMixNotifyUrl != nil {
+		t.StreamMixNotifyUrl = m.StreamMixNotifyUrl
+	}
+	if m.PushExceptionNotifyUrl != nil {
+		t.PushExceptionNotifyUrl = m.PushExceptionNotifyUrl
+	}
+	if m.AudioAuditNotifyUrl != nil {
+		t.AudioAuditNotifyUrl = m.AudioAuditNotifyUrl
+	}
+
+	err = h.recordDB.UpdateCallbackTemplate(*m.TemplateId, t)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ModifyLiveCallbackTemplateResponse{Response: &model.ModifyLiveCallbackTemplateResponseParams{}}, nil
+}
+
 func (h *Handler) parseLiveCallbackTemplate(q url.Values) (*model.CreateLiveCallbackTemplateRequestParams, error) {
 	t := &model.CreateLiveCallbackTemplateRequestParams{}
 	name := q.Get(TemplateName)
-	if name == "" {
-		return nil, errors.New(model.INVALIDPARAMETERVALUE)
+	if name != "" {
+		t.TemplateName = &name
 	}
-	t.TemplateName = &name
 
 	desc := q.Get(Description)
 	if desc != "" {
@@ -0,0 +1,141 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/model"
+	"github.com/leslie-wang/clusterd/common/util"
+	"github.com/leslie-wang/clusterd/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testEvents are events of every type which are notified
+func testEvents() []interface{} {
+	return []interface{}{
+		&types.LiveCallbackStreamEvent{EventType: types.LiveCallbackEventTypePushStart, StreamID: "s"},
+		&types.LiveCallbackStreamEvent{EventType: types.LiveCallbackEventTypePushStop, StreamID: "s"},
+		&types.LiveCallbackRecordFileEvent{StreamID: "s", FileID: "1-0"},
+		&types.LiveCallbackStreamExceptionEvent{StreamID: "s"},
+		&types.LiveCallbackRecordStatusEvent{StreamID: "s", RecordEvent: types.LiveRecordStatusEnded},
+	}
+}
+
+func TestSignCallback(t *testing.T) {
+	for _, method := range []string{util.SignMethodMD5, util.SignMethodHMACSHA256} {
+		for _, event := range testEvents() {
+			typ := setEventType(event)
+			name := fmt.Sprintf("%s event %d", method, typ)
+			content, err := json.Marshal(event)
+			require.Nil(t, err)
+
+			signed, signature, err := signCallback(&types.Callback{
+				SignKey: "key", SignMethod: method, EventType: typ, Content: string(content),
+			})
+			require.Nil(t, err, name)
+			assert.Nil(t, util.VerifyCallbackSign(method, "key", signed, signature, time.Now()), name)
+			assert.ErrorIs(t, util.VerifyCallbackSign(method, "other", signed, signature, time.Now()),
+				util.ErrInvalidSign, name)
+		}
+	}
+
+	// saved before the event has sign
+	signed, _, err := signCallback(&types.Callback{SignKey: "key", Content: `{"event_type":321,"stream_id":"s"}`})
+	require.Nil(t, err)
+	assert.Nil(t, util.VerifyCallbackSign(util.SignMethodMD5, "key", signed, "", time.Now()))
+
+	// not signed without key
+	content := `{"event_type":321,"sign":"","t":0}`
+	signed, signature, err := signCallback(&types.Callback{Content: content})
+	require.Nil(t, err)
+	assert.Equal(t, content, string(signed))
+	assert.Empty(t, signature)
+}
+
+type receivedCallback struct {
+	path      string
+	body      []byte
+	signature string
+}
+
+// TestRouteCallbacks checks events are delivered to the url of their type, and signed
+func TestRouteCallbacks(t *testing.T) {
+	received := make(chan receivedCallback, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedCallback{path: r.URL.Path, body: body, signature: r.Header.Get(util.CallbackSignatureHeader)}
+	}))
+	defer srv.Close()
+
+	for _, method := range []string{util.SignMethodMD5, util.SignMethodHMACSHA256} {
+		routeCallbacks(t, srv.URL, method, received)
+	}
+}
+
+// routeCallbacks delivers events signed by method, with a handler closed before the next method's is created
+func routeCallbacks(t *testing.T, url, method string, received <-chan receivedCallback) {
+	h := newTestHandler(t)
+	defer h.Close()
+	cb := &callback{
+		url: url + "/default",
+		urls: map[types.LiveCallbackEventType]string{
+			types.LiveCallbackEventTypeException:    url + "/exception",
+			types.LiveCallbackEventTypeRecordStatus: url + "/status",
+		},
+		key:    "key",
+		method: method,
+		stream: "s",
+	}
+	h.notifyException(cb, "1", types.LiveAbnormalRecordFailed, "exit 1")
+	for _, event := range testEvents() {
+		h.notify(cb, "1", event)
+	}
+
+	// delivered in order of the session
+	for _, path := range []string{"/exception", "/default", "/default", "/default", "/exception", "/status"} {
+		select {
+		case got := <-received:
+			assert.Equal(t, path, got.path, method)
+			assert.Nil(t, util.VerifyCallbackSign(method, "key", got.body, got.signature, time.Now()),
+				"%s %s: %s", method, got.path, got.body)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s callback to %s is not delivered", method, path)
+		}
+	}
+}
+
+// notify url of the task overrides where events are notified, but not how they are signed by the template
+func TestGetCallbackNotifyURLOfTask(t *testing.T) {
+	h := newTestHandler(t)
+
+	w := recordAPI(h, ActionCreateLiveCallbackTemplate, "",
+		`{"TemplateName":"t","CallbackKey":"template-key","RecordStatusNotifyUrl":"http://template/status"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	resp := &model.CreateLiveCallbackTemplateResponse{}
+	require.Nil(t, json.NewDecoder(w.Body).Decode(resp))
+	tid := strconv.FormatInt(*resp.Response.TemplateId, 10)
+	w = recordAPI(h, ActionCreateLiveCallbackRule, DomainName+"=test.com&"+AppName+"=live&"+TemplateID+"="+tid, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	for notifyURL, expected := range map[string]string{"": "http://template/status", "http://task": "http://task"} {
+		extra := ""
+		if notifyURL != "" {
+			extra = `,"NotifyURL":"` + notifyURL + `"`
+		}
+		id, err := strconv.Atoi(createTestRecordTask(t, h, extra))
+		require.Nil(t, err)
+		job, err := h.jobDB.Get(id)
+		require.Nil(t, err)
+
+		cb := h.getCallback(job)
+		assert.Equal(t, "template-key", cb.key, notifyURL)
+		assert.Equal(t, expected, cb.urlOf(types.LiveCallbackEventTypeRecordStatus), notifyURL)
+	}
+}
@@ -0,0 +1,135 @@
+package manager
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/model"
+	"github.com/leslie-wang/clusterd/types"
+)
+
+const (
+	// defaultRecordInterval is seconds of each file if the template doesn't set RecordInterval
+	defaultRecordInterval = 1800
+	minRecordInterval     = 30
+	maxRecordInterval     = 7200
+	maxStorageTime        = 1500 * 24 * 3600
+	maxFlowContinue       = 1800
+)
+
+// getRecordTemplate returns the template given by id, or nil if id is not given, in which case the default is
+// recorded. It is InvalidParameter if the template doesn't exist, rather than recording something unexpected.
+func (h *Handler) getRecordTemplate(id *uint64) (*model.CreateLiveRecordTemplateRequestParams, error) {
+	if id == nil || *id == 0 {
+		return nil, nil
+	}
+
+	t, err := h.recordDB.GetRecordTemplateByID(int64(*id))
+	if errors.Is(err, sql.ErrNoRows) {
+		h.logger.Warnf("record template %d doesn't exist", *id)
+		return nil, errors.New(model.INVALIDPARAMETER)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t.CreateLiveRecordTemplateRequestParams, nil
+}
+
+// mkRecordOutputs returns formats to record by the template. Without template, HLS is recorded and kept
+// forever.
+func mkRecordOutputs(tmpl *model.CreateLiveRecordTemplateRequestParams) ([]types.RecordOutput, error) {
+	if tmpl == nil {
+		return []types.RecordOutput{{Format: types.RecordFormatHLS}}, nil
+	}
+
+	params := []struct {
+		format string
+		param  *model.RecordParam
+	}{
+		{types.RecordFormatHLS, tmpl.HlsParam},
+		{types.RecordFormatMP4, tmpl.Mp4Param},
+		{types.RecordFormatFLV, tmpl.FlvParam},
+		{types.RecordFormatAAC, tmpl.AacParam},
+		{types.RecordFormatMP3, tmpl.Mp3Param},
+	}
+
+	var outputs []types.RecordOutput
+	for _, p := range params {
+		if p.param == nil || p.param.Enable == nil || *p.param.Enable != 1 {
+			continue
+		}
+
+		o := types.RecordOutput{Format: p.format}
+		if p.param.StorageTime != nil {
+			if *p.param.StorageTime < 0 || *p.param.StorageTime > maxStorageTime {
+				return nil, fmt.Errorf("invalid %s storage time. Need >= 0, or <= %d", p.format, maxStorageTime)
+			}
+			o.StorageTime = *p.param.StorageTime
+		}
+		// HLS is one file from start to end of the recording
+		if p.format != types.RecordFormatHLS {
+			o.Interval = defaultRecordInterval
+			if p.param.RecordInterval != nil {
+				if *p.param.RecordInterval < minRecordInterval || *p.param.RecordInterval > maxRecordInterval {
+					return nil, fmt.Errorf("invalid %s record interval. Need >= %d, or <= %d", p.format,
+						minRecordInterval, maxRecordInterval)
+				}
+				o.Interval = uint(*p.param.RecordInterval)
+			}
+		}
+		outputs = append(outputs, o)
+	}
+
+	if len(outputs) == 0 {
+		return nil, errors.New("no record format is enabled in template")
+	}
+	return outputs, nil
+}
+
+// applyRecordTemplate sets the record by the template
+func applyRecordTemplate(record *types.JobRecord, tmpl *model.CreateLiveRecordTemplateRequestParams) error {
+	outputs, err := mkRecordOutputs(tmpl)
+	if err != nil {
+		return err
+	}
+	record.Outputs = outputs
+
+	// mp4 files are cut from HLS, unless the task asks for its own duration
+	for _, o := range outputs {
+		if o.Format == types.RecordFormatMP4 && record.Mp4FileDuration == 0 {
+			record.Mp4FileDuration = o.Interval
+		}
+	}
+
+	if tmpl == nil || tmpl.HlsSpecialParam == nil || tmpl.HlsSpecialParam.FlowContinueDuration == nil {
+		return nil
+	}
+	d := *tmpl.HlsSpecialParam.FlowContinueDuration
+	if d > maxFlowContinue {
+		return fmt.Errorf("invalid flow continue duration. Need <= %d", maxFlowContinue)
+	}
+	if d > 0 && record.Retry == nil {
+		// continue the same HLS if the stream comes back in time
+		window := time.Duration(d) * time.Second
+		record.Retry = &types.RetryPolicy{
+			MaxAttempts: int(window/defaultRetryBackoff) + 1,
+			Backoff:     defaultRetryBackoff,
+			Window:      window,
+		}
+	}
+	return nil
+}
+
+// reapplyRecordTemplate sets the record by the modified template. Settings which the record got from the old
+// template are replaced, and the ones given by the task are kept, even if they equal the old template's.
+func reapplyRecordTemplate(record *types.JobRecord, tmpl *model.CreateLiveRecordTemplateRequestParams) error {
+	if !record.TaskMp4FileDuration {
+		record.Mp4FileDuration = 0
+	}
+	if !record.TaskRetry {
+		record.Retry = nil
+	}
+	return applyRecordTemplate(record, tmpl)
+}
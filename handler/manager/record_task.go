@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/url"
 	"strconv"
@@ -67,6 +68,120 @@ func (h *Handler) handleDeleteRecordTask(q url.Values) (*model.DeleteLiveRecordR
 	return &model.DeleteLiveRecordRuleResponse{Response: &model.DeleteLiveRecordRuleResponseParams{}}, tx.Commit()
 }
 
+func (h *Handler) handleStopRecordTask(q url.Values) (*model.StopRecordTaskResponse, error) {
+	tid := q.Get(TaskID)
+	if tid == "" {
+		return nil, errors.New(model.INVALIDPARAMETERVALUE)
+	}
+
+	id, err := strconv.Atoi(tid)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.stopRecordTask(id)
+	if err != nil {
+		return nil, err
+	}
+	return &model.StopRecordTaskResponse{Response: &model.StopRecordTaskResponseParams{}}, nil
+}
+
+func (h *Handler) stopRecordTask(id int) error {
+	job, err := h.jobDB.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if job == nil {
+		return util.ErrNotExist
+	}
+
+	if job.EndTime != nil {
+		// already finished, nothing to stop
+		return nil
+	}
+
+	if job.RunningHost == nil {
+		// not picked up by any runner yet, so end it directly
+		err = h.jobDB.CompleteAndArchive(int64(id), &recordSuccess)
+		if err != nil {
+			return err
+		}
+		tid := strconv.Itoa(id)
+		h.notify(h.getCallback(job), tid, &types.LiveCallbackRecordStatusEvent{
+			SessionID:   tid,
+			RecordEvent: types.LiveRecordStatusEnded,
+		})
+		return nil
+	}
+
+	// runner will stop ffmpeg after seeing the stop time, and report the final status
+	return h.jobDB.Stop(id)
+}
+
+// handlePauseRecordTask asks the runner to stop writing segments without ending the recording
+func (h *Handler) handlePauseRecordTask(q url.Values) (*model.PauseRecordTaskResponse, error) {
+	id, err := h.getUnfinishedRecordTask(q)
+	if err != nil {
+		return nil, err
+	}
+
+	// runner sends record_paused callback after ffmpeg is stopped
+	paused, err := h.jobDB.Pause(id)
+	if err != nil {
+		return nil, err
+	}
+	if !paused {
+		h.logger.Infof("record task %d is paused already", id)
+	}
+	return &model.PauseRecordTaskResponse{Response: &model.PauseRecordTaskResponseParams{}}, nil
+}
+
+// handleResumeRecordTask asks the runner to continue the paused recording in the same playlist
+func (h *Handler) handleResumeRecordTask(q url.Values) (*model.ResumeRecordTaskResponse, error) {
+	id, err := h.getUnfinishedRecordTask(q)
+	if err != nil {
+		return nil, err
+	}
+
+	// runner sends record_resumed callback after ffmpeg is started again
+	resumed, err := h.jobDB.Resume(id)
+	if err != nil {
+		return nil, err
+	}
+	if !resumed {
+		h.logger.Infof("record task %d is not paused", id)
+	}
+	return &model.ResumeRecordTaskResponse{Response: &model.ResumeRecordTaskResponseParams{}}, nil
+}
+
+// getUnfinishedRecordTask returns job ID of the task in query, which must not be finished or stopped
+func (h *Handler) getUnfinishedRecordTask(q url.Values) (int, error) {
+	tid := q.Get(TaskID)
+	if tid == "" {
+		return 0, errors.New(model.INVALIDPARAMETERVALUE)
+	}
+
+	id, err := strconv.Atoi(tid)
+	if err != nil {
+		return 0, err
+	}
+
+	job, err := h.jobDB.Get(id)
+	if err != nil {
+		return 0, err
+	}
+
+	if job == nil {
+		return 0, util.ErrNotExist
+	}
+
+	if job.EndTime != nil || job.StopTime != nil {
+		return 0, errors.New(model.FAILEDOPERATION)
+	}
+	return id, nil
+}
+
 func (h *Handler) handleCreateRecordTask(q url.Values, request io.ReadCloser) (*model.CreateRecordTaskResponse, error) {
 	defer request.Close()
 
@@ -89,30 +204,49 @@ func (h *Handler) handleCreateRecordTask(q url.Values, request io.ReadCloser) (*
 		}
 	}
 
+	id, err := h.createRecordTask(task)
+	if err != nil {
+		return nil, err
+	}
+
+	tid := strconv.FormatInt(id, 10)
+	playbackURL := h.mkPlaybackURL(int(id))
+	return &model.CreateRecordTaskResponse{Response: &model.CreateRecordTaskResponseParams{
+		TaskId:      &tid,
+		PlaybackURL: &playbackURL,
+	}}, nil
+}
+
+// createRecordTask saves the task, and queues its record job
+func (h *Handler) createRecordTask(task *types.LiveRecordTask) (int64, error) {
 	if len(task.RecordStreams) == 0 || task.RecordStreams[0].SourceURL == "" {
-		return nil, errors.New("sourceURL can not be empty")
+		return 0, errors.New("sourceURL can not be empty")
 	}
 
 	hlsSegDuration := task.HlsSegmentDuration
 	if hlsSegDuration == 0 {
 		hlsSegDuration = 6 // 6 second segment duration by default
 	} else if task.HlsSegmentDuration > 60 || task.HlsSegmentDuration < 6 {
-		return nil, errors.New("invalid hls segment duration. Need >= 6s, or <=60")
+		return 0, errors.New("invalid hls segment duration. Need >= 6s, or <=60")
 	}
 
-	tx, err := h.newTx()
+	if task.DomainName == nil {
+		return 0, errors.New("domainName can not be empty")
+	}
+	err := h.checkDomainQuota(*task.DomainName)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	defer tx.Rollback()
 
-	if task.DomainName == nil {
-		return nil, errors.New("domainName can not be empty")
+	tx, err := h.newTx()
+	if err != nil {
+		return 0, err
 	}
+	defer tx.Rollback()
 
 	id, err := h.recordDB.InsertRecordTask(tx, task)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
 	record := &types.JobRecord{
@@ -122,27 +256,50 @@ func (h *Handler) handleCreateRecordTask(q url.Values, request io.ReadCloser) (*
 		EndTime:            task.EndTime,
 		Mp4FileDuration:    task.Mp4FileDuration,
 		HlsSegmentDuration: hlsSegDuration,
+		StorageTime:        task.StorageTime,
+		DomainName:         *task.DomainName,
+	}
+	if task.StorageTime < 0 || task.StorageTime > maxStorageTime {
+		return 0, fmt.Errorf("invalid storage time. Need >= 0, or <= %d", maxStorageTime)
 	}
 	if task.RecordTimeout != "" {
 		timeout, err := time.ParseDuration(task.RecordTimeout)
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
 		record.RecordTimeout = timeout.Microseconds()
 	}
 
+	record.Retry, err = mkRetryPolicy(task.CreateRecordTaskRequestParams)
+	if err != nil {
+		return 0, err
+	}
+	record.TaskMp4FileDuration = record.Mp4FileDuration != 0
+	record.TaskRetry = record.Retry != nil
+
+	tmpl, err := h.getRecordTemplate(task.TemplateId)
+	if err != nil {
+		return 0, err
+	}
+	err = applyRecordTemplate(record, tmpl)
+	if err != nil {
+		return 0, err
+	}
+
 	if task.StartTime != nil && *task.StartTime != 0 {
 		record.StartTime = task.StartTime
 	}
 	content, err := json.Marshal(record)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
 	job := &types.Job{
 		RefID:    id,
 		Category: types.CategoryRecord,
 		Metadata: string(content),
+		Priority: task.Priority,
+		Tenant:   mkTenant(task.DomainName, task.AppName),
 	}
 
 	if task.StartTime != nil {
@@ -155,15 +312,10 @@ func (h *Handler) handleCreateRecordTask(q url.Values, request io.ReadCloser) (*
 
 	err = h.jobDB.Insert(tx, job)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	tid := strconv.FormatInt(id, 10)
-	playbackURL := h.mkPlaybackURL(int(id))
-	return &model.CreateRecordTaskResponse{Response: &model.CreateRecordTaskResponseParams{
-		TaskId:      &tid,
-		PlaybackURL: &playbackURL,
-	}}, tx.Commit()
+	return id, tx.Commit()
 }
 
 func (h *Handler) parseRecordTask(q url.Values) (*model.CreateRecordTaskRequestParams, error) {
@@ -214,5 +366,75 @@ func (h *Handler) parseRecordTask(q url.Values) (*model.CreateRecordTaskRequestP
 		r.TemplateId = &data
 	}
 
+	val = q.Get(Priority)
+	if val != "" {
+		data, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, err
+		}
+		r.Priority = data
+	}
+
+	val = q.Get(RetryMaxAttempts)
+	if val != "" {
+		data, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, err
+		}
+		r.RetryMaxAttempts = data
+	}
+	r.RetryBackoff = q.Get(RetryBackoff)
+	r.RetryWindow = q.Get(RetryWindow)
+
+	val = q.Get(StorageTime)
+	if val != "" {
+		data, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		r.StorageTime = data
+	}
+
 	return r, nil
 }
+
+// mkTenant returns the tenant which jobs of the stream are scheduled fairly by
+func mkTenant(domain, app *string) string {
+	if domain == nil {
+		return ""
+	}
+	if app == nil || *app == "" {
+		return *domain
+	}
+	return *domain + "/" + *app
+}
+
+// mkRetryPolicy returns retry policy of the task, or nil if the task is not retried
+func mkRetryPolicy(task *model.CreateRecordTaskRequestParams) (*types.RetryPolicy, error) {
+	if task.RetryMaxAttempts < 0 {
+		return nil, errors.New("invalid retry max attempts. Need >= 0")
+	}
+	if task.RetryMaxAttempts == 0 {
+		return nil, nil
+	}
+
+	p := &types.RetryPolicy{MaxAttempts: task.RetryMaxAttempts, Backoff: defaultRetryBackoff}
+	if task.RetryBackoff != "" {
+		backoff, err := time.ParseDuration(task.RetryBackoff)
+		if err != nil {
+			return nil, err
+		}
+		if backoff <= 0 {
+			return nil, errors.New("invalid retry backoff. Need > 0")
+		}
+		p.Backoff = backoff
+	}
+	if task.RetryWindow != "" {
+		window, err := time.ParseDuration(task.RetryWindow)
+		if err != nil {
+			return nil, err
+		}
+		p.Window = window
+	}
+	return p, nil
+}
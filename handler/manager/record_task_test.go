@@ -0,0 +1,124 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/model"
+	"github.com/leslie-wang/clusterd/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRecordTask = `{"DomainName":"test.com","AppName":"live","StreamName":"s",` +
+	`"EndTime":4102444800,"RecordStreams":[{"SourceURL":"rtmp://localhost/live/s"}]`
+
+// createTestRecordTask creates record task with extra fields of the request, and returns its id
+func createTestRecordTask(t *testing.T, h *Handler, extra string) string {
+	w := recordAPI(h, ActionCreateRecordTask, "", testRecordTask+extra+"}")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	resp := &model.CreateRecordTaskResponse{}
+	require.Nil(t, json.NewDecoder(w.Body).Decode(resp))
+	return *resp.Response.TaskId
+}
+
+func TestCreateRecordTaskWithoutTemplate(t *testing.T) {
+	h := newTestHandler(t)
+
+	w := recordAPI(h, ActionCreateRecordTask, "", testRecordTask+`,"TemplateId":999}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, model.INVALIDPARAMETER, strings.TrimSpace(w.Body.String()))
+
+	// nothing is saved
+	tasks, err := h.recordDB.ListRecordTasks(context.Background())
+	require.Nil(t, err)
+	assert.Empty(t, tasks)
+	jobs, err := h.jobDB.List()
+	require.Nil(t, err)
+	assert.Empty(t, jobs)
+
+	// default template
+	assert.NotEmpty(t, createTestRecordTask(t, h, ""))
+}
+
+// getTestJob returns the job like runner polls it
+func getTestJob(t *testing.T, h *Handler, id string) *types.Job {
+	w := get(h, types.URLJob+"/"+id, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	job := &types.Job{}
+	require.Nil(t, json.NewDecoder(w.Body).Decode(job))
+	return job
+}
+
+// reportTestJob reports status of the job like runner does
+func reportTestJob(t *testing.T, h *Handler, id string, status types.JobStatusType) {
+	jobID, err := strconv.Atoi(id)
+	require.Nil(t, err)
+	body, err := json.Marshal(&types.JobStatus{ID: jobID, Runner: "runner", Type: status})
+	require.Nil(t, err)
+	w := serve(h, httptest.NewRequest(http.MethodPost, types.URLJob+"/"+id, bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func TestPauseResumeRecordTask(t *testing.T) {
+	received := make(chan *types.LiveCallbackRecordStatusEvent, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := &types.LiveCallbackRecordStatusEvent{}
+		if json.NewDecoder(r.Body).Decode(event) == nil && event.RecordEvent != "" {
+			received <- event
+		}
+	}))
+	defer srv.Close()
+	expectEvent := func(event types.LiveRecordStatusEvent) {
+		select {
+		case got := <-received:
+			assert.Equal(t, event, got.RecordEvent)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s callback is not delivered", event)
+		}
+	}
+
+	h := newTestHandler(t)
+	id := createTestRecordTask(t, h, `,"NotifyURL":"`+srv.URL+`"`)
+	w := serve(h, httptest.NewRequest(http.MethodPost, types.URLJobRunner+"runner", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Nil(t, getTestJob(t, h, id).PauseTime)
+
+	action := func(action string) *httptest.ResponseRecorder {
+		return recordAPI(h, action, TaskID+"="+id, "")
+	}
+
+	// pausing twice keeps the first pause
+	require.Equal(t, http.StatusOK, action(ActionPauseRecordTask).Code)
+	paused := getTestJob(t, h, id).PauseTime
+	require.NotNil(t, paused)
+	require.Equal(t, http.StatusOK, action(ActionPauseRecordTask).Code)
+	assert.Equal(t, paused.Unix(), getTestJob(t, h, id).PauseTime.Unix())
+	reportTestJob(t, h, id, types.RecordJobPaused)
+	expectEvent(types.LiveRecordStatusPaused)
+
+	// resuming twice is fine as well
+	require.Equal(t, http.StatusOK, action(ActionResumeRecordTask).Code)
+	assert.Nil(t, getTestJob(t, h, id).PauseTime)
+	require.Equal(t, http.StatusOK, action(ActionResumeRecordTask).Code)
+	assert.Nil(t, getTestJob(t, h, id).PauseTime)
+	reportTestJob(t, h, id, types.RecordJobResumed)
+	expectEvent(types.LiveRecordStatusResumed)
+
+	// stopped task can't be paused or resumed
+	require.Equal(t, http.StatusOK, action(ActionStopRecordTask).Code)
+	for _, a := range []string{ActionPauseRecordTask, ActionResumeRecordTask} {
+		w = action(a)
+		assert.Equal(t, http.StatusInternalServerError, w.Code, a)
+		assert.Equal(t, model.FAILEDOPERATION, strings.TrimSpace(w.Body.String()), a)
+	}
+	w = recordAPI(h, ActionPauseRecordTask, "", "")
+	assert.Equal(t, model.INVALIDPARAMETERVALUE, strings.TrimSpace(w.Body.String()))
+}
@@ -2,13 +2,17 @@ package manager
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/url"
 	"strconv"
 
+	"github.com/leslie-wang/clusterd/common/db/record"
 	"github.com/leslie-wang/clusterd/common/model"
+	"github.com/leslie-wang/clusterd/common/util"
+	"github.com/leslie-wang/clusterd/types"
 )
 
 func (h *Handler) handleGetLiveRecordTemplate(q url.Values) (*model.DescribeLiveRecordTemplateResponse, error) {
@@ -40,6 +44,7 @@ func (h *Handler) handleGetLiveRecordTemplate(q url.Values) (*model.DescribeLive
 				Mp3Param:        item.Mp3Param,
 				RemoveWatermark: item.RemoveWatermark,
 				FlvSpecialParam: item.FlvSpecialParam,
+				UpdateTime:      record.FormatTime(item.UpdateTime),
 			},
 		},
 	}, nil
@@ -70,6 +75,7 @@ func (h *Handler) handleListLiveRecordTemplates() (*model.DescribeLiveRecordTemp
 			Mp3Param:        item.Mp3Param,
 			RemoveWatermark: item.RemoveWatermark,
 			FlvSpecialParam: item.FlvSpecialParam,
+			UpdateTime:      record.FormatTime(item.UpdateTime),
 		})
 	}
 	return resp, nil
@@ -100,6 +106,9 @@ func (h *Handler) handleCreateLiveRecordTemplate(q url.Values, request io.ReadCl
 
 	if h.cfg.ParamQuery {
 		t, err = h.parseLiveRecordTemplate(q)
+		if err == nil && t.TemplateName == nil {
+			err = errors.New(model.INVALIDPARAMETERVALUE)
+		}
 	} else {
 		t = &model.CreateLiveRecordTemplateRequestParams{}
 		err = json.NewDecoder(request).Decode(t)
@@ -108,6 +117,12 @@ func (h *Handler) handleCreateLiveRecordTemplate(q url.Values, request io.ReadCl
 		return nil, err
 	}
 
+	// reject what can't be recorded, instead of failing tasks using it later
+	err = applyRecordTemplate(&types.JobRecord{}, t)
+	if err != nil {
+		return nil, err
+	}
+
 	id, err := h.recordDB.InsertRecordTemplate(t)
 	if err != nil {
 		return nil, err
@@ -120,13 +135,143 @@ func (h *Handler) handleCreateLiveRecordTemplate(q url.Values, request io.ReadCl
 	}, nil
 }
 
+// handleModifyLiveRecordTemplate changes the given fields of the template, and keeps the others. Each given
+// record param replaces the whole param. Tasks waiting to start are recorded by the modified template.
+func (h *Handler) handleModifyLiveRecordTemplate(q url.Values, request io.ReadCloser) (*model.ModifyLiveRecordTemplateResponse, error) {
+	defer request.Close()
+
+	m := &model.ModifyLiveRecordTemplateRequestParams{}
+	if h.cfg.ParamQuery {
+		t, err := h.parseLiveRecordTemplate(q)
+		if err != nil {
+			return nil, err
+		}
+		val := q.Get(TemplateID)
+		if val != "" {
+			id, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			m.TemplateId = &id
+		}
+		m.TemplateName = t.TemplateName
+		m.Description = t.Description
+		m.FlvParam = t.FlvParam
+		m.HlsParam = t.HlsParam
+		m.Mp4Param = t.Mp4Param
+		m.AacParam = t.AacParam
+		m.HlsSpecialParam = t.HlsSpecialParam
+		m.Mp3Param = t.Mp3Param
+		m.RemoveWatermark = t.RemoveWatermark
+		m.FlvSpecialParam = t.FlvSpecialParam
+	} else {
+		err := json.NewDecoder(request).Decode(m)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if m.TemplateId == nil {
+		return nil, errors.New(model.INVALIDPARAMETERVALUE)
+	}
+	id := *m.TemplateId
+
+	item, err := h.recordDB.GetRecordTemplateByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, util.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	old := item.CreateLiveRecordTemplateRequestParams
+	t := modifyRecordTemplate(old, m)
+	err = applyRecordTemplate(&types.JobRecord{}, t)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := h.newTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = h.recordDB.UpdateRecordTemplate(tx, id, t)
+	if err != nil {
+		return nil, err
+	}
+
+	// running jobs keep recording by the old template
+	jobs, err := h.recordDB.ListWaitingJobsByTemplate(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		rec := &types.JobRecord{}
+		err = json.Unmarshal([]byte(job.Metadata), rec)
+		if err != nil {
+			return nil, err
+		}
+		err = reapplyRecordTemplate(rec, t)
+		if err != nil {
+			return nil, err
+		}
+		content, err := json.Marshal(rec)
+		if err != nil {
+			return nil, err
+		}
+		_, err = h.jobDB.UpdateMetadata(tx, job.ID, string(content))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &model.ModifyLiveRecordTemplateResponse{Response: &model.ModifyLiveRecordTemplateResponseParams{}}, tx.Commit()
+}
+
+// modifyRecordTemplate returns copy of the template with given fields of the modification
+func modifyRecordTemplate(t *model.CreateLiveRecordTemplateRequestParams,
+	m *model.ModifyLiveRecordTemplateRequestParams) *model.CreateLiveRecordTemplateRequestParams {
+	modified := *t
+	if m.TemplateName != nil {
+		modified.TemplateName = m.TemplateName
+	}
+	if m.Description != nil {
+		modified.Description = m.Description
+	}
+	if m.FlvParam != nil {
+		modified.FlvParam = m.FlvParam
+	}
+	if m.HlsParam != nil {
+		modified.HlsParam = m.HlsParam
+	}
+	if m.Mp4Param != nil {
+		modified.Mp4Param = m.Mp4Param
+	}
+	if m.AacParam != nil {
+		modified.AacParam = m.AacParam
+	}
+	if m.HlsSpecialParam != nil {
+		modified.HlsSpecialParam = m.HlsSpecialParam
+	}
+	if m.Mp3Param != nil {
+		modified.Mp3Param = m.Mp3Param
+	}
+	if m.RemoveWatermark != nil {
+		modified.RemoveWatermark = m.RemoveWatermark
+	}
+	if m.FlvSpecialParam != nil {
+		modified.FlvSpecialParam = m.FlvSpecialParam
+	}
+	return &modified
+}
+
 func (h *Handler) parseLiveRecordTemplate(q url.Values) (*model.CreateLiveRecordTemplateRequestParams, error) {
 	t := &model.CreateLiveRecordTemplateRequestParams{}
 	val := q.Get(TemplateName)
-	if val == "" {
-		return nil, errors.New(model.INVALIDPARAMETERVALUE)
+	if val != "" {
+		t.TemplateName = &val
 	}
-	t.TemplateName = &val
 
 	val = q.Get(Description)
 	if val != "" {
@@ -0,0 +1,44 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/leslie-wang/clusterd/common/model"
+	"github.com/leslie-wang/clusterd/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mp4 duration given by task is kept when template is modified, even if it is the old template's
+func TestModifyRecordTemplateKeepsTaskSettings(t *testing.T) {
+	h := newTestHandler(t)
+
+	w := recordAPI(h, ActionCreateLiveRecordTemplate, "",
+		`{"TemplateName":"t","Mp4Param":{"Enable":1,"RecordInterval":600}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	resp := &model.CreateLiveRecordTemplateResponse{}
+	require.Nil(t, json.NewDecoder(w.Body).Decode(resp))
+	tid := strconv.FormatInt(*resp.Response.TemplateId, 10)
+
+	byTemplate := createTestRecordTask(t, h, `,"TemplateId":`+tid)
+	byTask := createTestRecordTask(t, h, `,"TemplateId":`+tid+`,"Mp4FileDuration":600`)
+
+	w = recordAPI(h, ActionModifyLiveRecordTemplate, "",
+		`{"TemplateId":`+tid+`,"Mp4Param":{"Enable":1,"RecordInterval":900}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	mp4FileDuration := func(tid string) uint {
+		id, err := strconv.Atoi(tid)
+		require.Nil(t, err)
+		job, err := h.jobDB.Get(id)
+		require.Nil(t, err)
+		record := &types.JobRecord{}
+		require.Nil(t, json.Unmarshal([]byte(job.Metadata), record))
+		return record.Mp4FileDuration
+	}
+	assert.Equal(t, uint(900), mp4FileDuration(byTemplate))
+	assert.Equal(t, uint(600), mp4FileDuration(byTask))
+}
@@ -0,0 +1,315 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common"
+	"github.com/leslie-wang/clusterd/common/storage"
+	"github.com/leslie-wang/clusterd/common/util"
+	"github.com/leslie-wang/clusterd/types"
+)
+
+const (
+	// retentionBatchSize is how many recordings are scheduled, or retentions are expired, in one query
+	retentionBatchSize = 100
+	// defaultRetentionListLimit is how many retentions are listed by dry run if limit is not given
+	defaultRetentionListLimit = 20
+	// retentionTimeout is the longest time to remove files of one retention
+	retentionTimeout = 10 * time.Minute
+)
+
+// retentionLoop removes recorded files when they are kept for their storage time
+func (h *Handler) retentionLoop() {
+	if h.cfg.RetentionInterval <= 0 {
+		h.logger.Warnf("retention of recorded files is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(h.cfg.RetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-h.stop:
+			return
+		}
+		h.expireRetentions(time.Now())
+	}
+}
+
+// expireRetentions schedules retentions of newly finished recordings, then removes files expired at now
+func (h *Handler) expireRetentions(now time.Time) {
+	err := h.scheduleRetentions()
+	if err != nil {
+		h.logger.Warnf("schedule retention of recordings: %s", err)
+	}
+
+	// failed retentions stay due ahead of the others, so they are skipped when listing the next batch
+	failed := 0
+	for {
+		rs, err := h.retentionDB.ListDue(now, failed, retentionBatchSize)
+		if err != nil {
+			h.logger.Warnf("list expired retentions: %s", err)
+			return
+		}
+		for i := range rs {
+			err = h.expireRetention(&rs[i], now)
+			if err != nil {
+				// it is tried again by next round, and doesn't block expiring the others
+				h.logger.Warnf("expire %s files of job %d: %s", rs[i].Format, rs[i].JobID, err)
+				failed++
+			}
+		}
+		if len(rs) < retentionBatchSize {
+			return
+		}
+	}
+}
+
+// scheduleRetentions saves when files of finished recordings expire. Expiry is computed once the recording
+// ends, so later changes of the template don't apply to recorded files.
+func (h *Handler) scheduleRetentions() error {
+	// recordings are scheduled once, even by concurrent sweeper and api
+	h.retentionLock.Lock()
+	defer h.retentionLock.Unlock()
+
+	// failed recordings stay unscheduled ahead of the others, so they are skipped when listing the next batch
+	failed := 0
+	for {
+		jobs, err := h.retentionDB.ListUnscheduled(failed, retentionBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			err = h.scheduleRetention(&job)
+			if err != nil {
+				// it is tried again by next round, and doesn't block scheduling the others
+				h.logger.Warnf("schedule retention of job %d: %s", job.ID, err)
+				failed++
+			}
+		}
+		if len(jobs) < retentionBatchSize {
+			return nil
+		}
+	}
+}
+
+// scheduleRetention saves retentions of the finished recording
+func (h *Handler) scheduleRetention(job *types.Job) error {
+	record := &types.JobRecord{}
+	err := json.Unmarshal([]byte(job.Metadata), record)
+	if err != nil {
+		h.logger.Warnf("unmarshal job %d record: %s", job.ID, err)
+	}
+	rs := mkRetentions(job.ID, *job.EndTime, record)
+	err = h.sizeRetentions(rs)
+	if err != nil {
+		return err
+	}
+	return h.retentionDB.Insert(rs)
+}
+
+// sizeRetentions sets size of files of each retention, which counts in storage quota of the domain
+func (h *Handler) sizeRetentions(rs []types.RecordRetention) error {
+	ctx, cancel := context.WithTimeout(context.Background(), retentionTimeout)
+	defer cancel()
+
+	for i := range rs {
+		files, err := h.retentionFiles(ctx, &rs[i])
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			rs[i].Size += f.Size
+		}
+	}
+	return nil
+}
+
+// mkRetentions returns retentions of the recording ended at given time. Storage time of the task overrides the
+// template's. HLS and MP4 share the files, so they are kept as long as the longer one of them.
+func mkRetentions(jobID int, end time.Time, record *types.JobRecord) []types.RecordRetention {
+	outputs := record.Outputs
+	if len(outputs) == 0 {
+		outputs = []types.RecordOutput{{Format: types.RecordFormatHLS}}
+	}
+
+	var (
+		formats      []string
+		storageTimes = map[string]int64{}
+	)
+	for _, o := range outputs {
+		format := retentionFormat(o.Format)
+		st := o.StorageTime
+		if record.StorageTime > 0 {
+			st = record.StorageTime
+		}
+		prev, ok := storageTimes[format]
+		if !ok {
+			formats = append(formats, format)
+			storageTimes[format] = st
+		} else if prev != 0 && (st == 0 || st > prev) {
+			// 0 is forever
+			storageTimes[format] = st
+		}
+	}
+
+	rs := make([]types.RecordRetention, 0, len(formats))
+	for _, format := range formats {
+		r := types.RecordRetention{JobID: jobID, Format: format, DomainName: record.DomainName}
+		if st := storageTimes[format]; st > 0 {
+			expire := end.Add(time.Duration(st) * time.Second)
+			r.ExpireTime = &expire
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+// retentionFormat returns the format which files of given format are expired with
+func retentionFormat(format string) string {
+	if format == types.RecordFormatMP4 {
+		return types.RecordFormatHLS
+	}
+	return format
+}
+
+// retentionFiles lists files of the retention in the store. Files other than HLS are in the directory named
+// by their format, and HLS files are all the others of the job.
+func (h *Handler) retentionFiles(ctx context.Context, r *types.RecordRetention) ([]storage.ObjectInfo, error) {
+	if r.Format != types.RecordFormatHLS {
+		return h.store.List(ctx, storage.MediaKey(r.JobID, r.Format)+"/")
+	}
+
+	prefix := storage.MediaKey(r.JobID, "") + "/"
+	objs, err := h.store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	files := objs[:0]
+	for _, o := range objs {
+		dir, _, ok := strings.Cut(strings.TrimPrefix(o.Key, prefix), "/")
+		if ok && retentionFormat(dir) != types.RecordFormatHLS {
+			continue
+		}
+		files = append(files, o)
+	}
+	return files, nil
+}
+
+// expireRetention removes files of the retention, and the whole recording once files of all formats are
+// removed
+func (h *Handler) expireRetention(r *types.RecordRetention, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), retentionTimeout)
+	defer cancel()
+
+	files, err := h.retentionFiles(ctx, r)
+	if err != nil {
+		return err
+	}
+	var size uint64
+	for _, f := range files {
+		err = h.store.Remove(ctx, f.Key)
+		if err != nil {
+			return err
+		}
+		size += uint64(f.Size)
+	}
+	err = h.retentionDB.MarkExpired(r.ID, now)
+	if err != nil {
+		return err
+	}
+	h.logger.Infof("expired %d %s files of job %d", len(files), r.Format, r.JobID)
+
+	job, err := h.jobDB.Get(r.JobID)
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		return nil
+	}
+	record := &types.JobRecord{}
+	err = json.Unmarshal([]byte(job.Metadata), record)
+	if err != nil {
+		return err
+	}
+
+	n, err := h.retentionDB.CountUnexpired(r.JobID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		err = h.removeRecordFiles(ctx, r.JobID, record)
+		if err != nil {
+			return err
+		}
+	}
+
+	if h.cfg.RetentionCallback {
+		h.notify(h.getCallback(job), strconv.Itoa(job.ID), &types.LiveCallbackRecordStatusEvent{
+			SessionID:    strconv.Itoa(job.ID),
+			RecordEvent:  types.LiveRecordFileExpired,
+			RecordDetail: fmt.Sprintf("%s files expired", r.Format),
+			Size:         size,
+		})
+	}
+	return nil
+}
+
+// removeRecordFiles removes all files of the recording, including the ones in the path of the task
+func (h *Handler) removeRecordFiles(ctx context.Context, id int, r *types.JobRecord) error {
+	if r.StorePath != "" {
+		// recorded into the path of the task besides the store
+		err := os.RemoveAll(common.MkStoragePath(r.StorePath, strconv.Itoa(id)))
+		if err != nil {
+			return err
+		}
+	}
+	return h.store.RemoveAll(ctx, storage.MediaKey(id, "")+"/")
+}
+
+// listRetentions lists files which expire next, without removing them
+func (h *Handler) listRetentions(w http.ResponseWriter, r *http.Request) {
+	limit := defaultRetentionListLimit
+	if val := r.URL.Query().Get(types.RetentionLimit); val != "" {
+		var err error
+		limit, err = strconv.Atoi(val)
+		if err != nil || limit <= 0 {
+			util.WriteError(w, fmt.Errorf("invalid limit %s", val))
+			return
+		}
+	}
+
+	// recordings just finished are listed as well
+	err := h.scheduleRetentions()
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+	rs, err := h.retentionDB.ListPending(limit)
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+	for i := range rs {
+		files, err := h.retentionFiles(r.Context(), &rs[i])
+		if err != nil {
+			util.WriteError(w, err)
+			return
+		}
+		rs[i].Files = len(files)
+		rs[i].Size = 0
+		for _, f := range files {
+			rs[i].Size += f.Size
+		}
+	}
+	util.WriteBody(w, rs)
+}
@@ -0,0 +1,116 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/storage"
+	"github.com/leslie-wang/clusterd/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingStore fails to remove or list objects of jobs which are failing
+type failingStore struct {
+	storage.Store
+	failing func(jobID int) bool
+}
+
+func (s *failingStore) fails(key string) bool {
+	id, _, _ := strings.Cut(key, "/")
+	jobID, err := strconv.Atoi(id)
+	return err == nil && s.failing != nil && s.failing(jobID)
+}
+
+func (s *failingStore) Remove(ctx context.Context, key string) error {
+	if s.fails(key) {
+		return errors.New("remove failed")
+	}
+	return s.Store.Remove(ctx, key)
+}
+
+func (s *failingStore) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	if s.fails(prefix) {
+		return nil, errors.New("list failed")
+	}
+	return s.Store.List(ctx, prefix)
+}
+
+// failed retention is tried again by next round, and doesn't block expiring the others
+func TestExpireRetentionsSkipFailed(t *testing.T) {
+	h := newTestHandler(t)
+	store := &failingStore{Store: h.store, failing: func(jobID int) bool { return jobID == 2 }}
+	h.store = store
+
+	now := time.Now()
+	expire := now.Add(-time.Hour)
+	ctx := context.Background()
+	for id := 1; id <= 3; id++ {
+		require.Nil(t, h.store.Put(ctx, storage.MediaKey(id, "0.ts"), strings.NewReader("ts"), 2))
+		require.Nil(t, h.retentionDB.Insert([]types.RecordRetention{
+			{JobID: id, Format: types.RecordFormatHLS, DomainName: "test.com", ExpireTime: &expire},
+		}))
+	}
+
+	expired := func(id int) bool {
+		rs, err := h.retentionDB.ListByJob(id)
+		require.Nil(t, err)
+		require.Len(t, rs, 1)
+		_, err = h.store.Stat(ctx, storage.MediaKey(id, "0.ts"))
+		assert.Equal(t, rs[0].ExpiredTime != nil, errors.Is(err, storage.ErrNotExist), "job %d", id)
+		return rs[0].ExpiredTime != nil
+	}
+
+	h.expireRetentions(now)
+	assert.True(t, expired(1))
+	assert.False(t, expired(2))
+	assert.True(t, expired(3))
+
+	store.failing = nil
+	h.expireRetentions(now)
+	assert.True(t, expired(2))
+}
+
+// archiveTestRecording archives a finished record job
+func archiveTestRecording(t *testing.T, h *Handler) int {
+	tx, err := h.newTx()
+	require.Nil(t, err)
+	job := &types.Job{Category: types.CategoryRecord, Metadata: "{}"}
+	require.Nil(t, h.jobDB.Insert(tx, job))
+	require.Nil(t, tx.Commit())
+	require.Nil(t, h.jobDB.CompleteAndArchive(int64(job.ID), &recordSuccess))
+	return job.ID
+}
+
+// failed recording is scheduled by next round, and doesn't block scheduling the others, even a whole batch of them
+func TestScheduleRetentionsSkipFailed(t *testing.T) {
+	h := newTestHandler(t)
+	store := &failingStore{Store: h.store}
+	h.store = store
+
+	var ids []int
+	for i := 0; i < retentionBatchSize+2; i++ {
+		ids = append(ids, archiveTestRecording(t, h))
+	}
+	last := ids[len(ids)-1]
+	store.failing = func(jobID int) bool { return jobID != last }
+
+	scheduled := func(id int) bool {
+		rs, err := h.retentionDB.ListByJob(id)
+		require.Nil(t, err)
+		return len(rs) > 0
+	}
+	require.Nil(t, h.scheduleRetentions())
+	assert.False(t, scheduled(ids[0]))
+	assert.True(t, scheduled(last))
+
+	store.failing = nil
+	require.Nil(t, h.scheduleRetentions())
+	for _, id := range ids {
+		assert.True(t, scheduled(id), "job %d", id)
+	}
+}
@@ -0,0 +1,85 @@
+package manager
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/leslie-wang/clusterd/types"
+)
+
+const (
+	defaultRetryBackoff = 5 * time.Second
+	maxRetryBackoff     = 5 * time.Minute
+)
+
+// addAttempt saves the ended run of the job into history, and returns the whole history
+func (h *Handler) addAttempt(job *types.Job, exitCode int, detail string) ([]types.JobAttempt, error) {
+	attempt := &types.JobAttempt{
+		ExitCode:  exitCode,
+		Detail:    detail,
+		StartTime: job.StartTime,
+	}
+	if job.RunningHost != nil {
+		attempt.Runner = *job.RunningHost
+	}
+
+	err := h.jobDB.AddAttempt(job.ID, attempt)
+	if err != nil {
+		return nil, err
+	}
+	return h.jobDB.ListAttempts(job.ID)
+}
+
+// retryDelay checks the retry policy of the failed job, and returns how long to wait before next
+// attempt. It returns false if the job shall not be retried.
+func (h *Handler) retryDelay(job *types.Job, attempts []types.JobAttempt) (time.Duration, bool) {
+	if job.StopTime != nil || job.RunningHost == nil {
+		return 0, false
+	}
+
+	record := &types.JobRecord{}
+	err := json.Unmarshal([]byte(job.Metadata), record)
+	if err != nil {
+		h.logger.Warnf("unmarshal job %d record: %v", job.ID, err)
+		return 0, false
+	}
+	p := record.Retry
+	if p == nil {
+		return 0, false
+	}
+
+	// lost runners are handled by requeue, only count failures of recording itself
+	var (
+		failures  int
+		firstFail time.Time
+	)
+	for _, a := range attempts {
+		if a.ExitCode == recordSuccess || a.ExitCode == recordLost {
+			continue
+		}
+		if failures == 0 {
+			firstFail = a.EndTime
+		}
+		failures++
+	}
+	if failures == 0 || failures > p.MaxAttempts {
+		return 0, false
+	}
+	if p.Window > 0 && time.Since(firstFail) > p.Window {
+		return 0, false
+	}
+
+	delay := p.Backoff
+	for i := 1; i < failures && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+
+	if record.EndTime != nil && !time.Now().Add(delay).Before(time.Unix(int64(*record.EndTime), 0)) {
+		// nothing left to record after waiting
+		return 0, false
+	}
+	return delay, true
+}
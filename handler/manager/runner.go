@@ -1 +1,198 @@
 package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/leslie-wang/clusterd/common/util"
+	"github.com/leslie-wang/clusterd/types"
+)
+
+// recordLost is exit code of the job whose runner is lost
+var recordLost = -1
+
+// registerRunner adds or refreshes the runner in registry
+func (h *Handler) registerRunner(name string, hb *types.JobHeartbeat) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.runners[name] = &types.Runner{
+		Name:         name,
+		Address:      hb.Address,
+		Version:      hb.Version,
+		Capacity:     hb.Capacity,
+		Jobs:         hb.Jobs,
+		DiskFree:     hb.DiskFree,
+		DiskUsed:     hb.DiskUsed,
+		LastSeenTime: time.Now(),
+	}
+}
+
+// pruneRunners removes runners which are not seen since given time
+func (h *Handler) pruneRunners(before time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for name, r := range h.runners {
+		if r.LastSeenTime.Before(before) {
+			h.logger.Warnf("runner %s is not seen since %s, remove it", name, r.LastSeenTime)
+			delete(h.runners, name)
+		}
+	}
+}
+
+func (h *Handler) listRunners(w http.ResponseWriter, r *http.Request) {
+	h.lock.Lock()
+	runners := make([]types.Runner, 0, len(h.runners))
+	for _, r := range h.runners {
+		runners = append(runners, *r)
+	}
+	h.lock.Unlock()
+
+	sort.Slice(runners, func(i, j int) bool {
+		return runners[i].Name < runners[j].Name
+	})
+	util.WriteBody(w, runners)
+}
+
+func (h *Handler) getRunner(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[types.ID]
+
+	h.lock.Lock()
+	runner, ok := h.runners[name]
+	if ok {
+		// copy it, since registry may be updated while writing body
+		copied := *runner
+		runner = &copied
+	}
+	h.lock.Unlock()
+
+	if !ok {
+		util.WriteError(w, util.ErrNotExist)
+		return
+	}
+	util.WriteBody(w, runner)
+}
+
+func (h *Handler) heartbeat(w http.ResponseWriter, r *http.Request) {
+	runner := mux.Vars(r)[types.ID]
+
+	hb := &types.JobHeartbeat{}
+	err := json.NewDecoder(r.Body).Decode(hb)
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+
+	h.registerRunner(runner, hb)
+
+	resp := &types.JobHeartbeatResponse{Revoked: []int{}}
+	for _, id := range hb.Jobs {
+		owned, err := h.jobDB.Heartbeat(id, runner)
+		if err != nil {
+			util.WriteError(w, err)
+			return
+		}
+		if !owned {
+			h.logger.Warnf("job %d is not running on %s anymore", id, runner)
+			resp.Revoked = append(resp.Revoked, id)
+		}
+	}
+	util.WriteBody(w, resp)
+}
+
+// leaseLoop periodically re-queues or fails the running jobs whose runner stops heartbeat
+func (h *Handler) leaseLoop() {
+	if h.cfg.JobLeaseTimeout <= 0 {
+		h.logger.Warnf("job lease is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(h.cfg.JobLeaseTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-h.stop:
+			return
+		}
+		h.expireJobs()
+	}
+}
+
+func (h *Handler) expireJobs() {
+	before := time.Now().Add(-h.cfg.JobLeaseTimeout)
+	h.pruneRunners(before)
+
+	jobs, err := h.jobDB.ListExpired(before)
+	if err != nil {
+		h.logger.Warnf("list expired jobs: %s", err)
+		return
+	}
+
+	for i := range jobs {
+		job := &jobs[i]
+		runner := *job.RunningHost
+		detail := fmt.Sprintf("runner %s is lost since %s", runner, job.LastSeenTime.Local().Format(time.RFC3339))
+
+		var event types.LiveRecordStatusEvent = types.LiveRecordStatusError
+		if h.canRequeue(job) {
+			requeued, err := h.jobDB.Requeue(job.ID, runner, before)
+			if err != nil {
+				h.logger.Warnf("requeue job %d: %s", job.ID, err)
+				continue
+			}
+			if !requeued {
+				// renewed by heartbeat in the meantime
+				continue
+			}
+			h.logger.Warnf("job %d's lease on %s is expired, requeue it", job.ID, runner)
+			detail += ", recording is rescheduled"
+			event = types.LiveRecordStatusPaused
+		} else {
+			err = h.jobDB.CompleteAndArchive(int64(job.ID), &recordLost)
+			if err != nil {
+				h.logger.Warnf("archive job %d: %s", job.ID, err)
+				continue
+			}
+			h.logger.Warnf("job %d's lease on %s is expired, fail it", job.ID, runner)
+			detail += ", recording is ended"
+		}
+
+		_, err = h.addAttempt(job, recordLost, fmt.Sprintf("runner %s is lost", runner))
+		if err != nil {
+			h.logger.Warnf("save job %d attempt: %s", job.ID, err)
+		}
+
+		sessionID := strconv.Itoa(job.ID)
+		cb := h.getCallback(job)
+		h.notifyException(cb, sessionID, types.LiveAbnormalRunnerLost, detail)
+		h.notify(cb, sessionID, &types.LiveCallbackRecordStatusEvent{
+			SessionID:    sessionID,
+			RecordEvent:  event,
+			RecordDetail: detail,
+			DownloadURL:  h.mkDownloadURL(job.ID, ""),
+		})
+	}
+}
+
+// canRequeue checks whether the expired job still worth running on another runner
+func (h *Handler) canRequeue(job *types.Job) bool {
+	if job.StopTime != nil || job.RequeueCount >= h.cfg.MaxJobRequeue {
+		return false
+	}
+
+	record := &types.JobRecord{}
+	err := json.Unmarshal([]byte(job.Metadata), record)
+	if err != nil {
+		h.logger.Warnf("unmarshal job %d record: %v", job.ID, err)
+		return false
+	}
+	return record.EndTime == nil || time.Unix(int64(*record.EndTime), 0).After(time.Now())
+}
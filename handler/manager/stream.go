@@ -0,0 +1,118 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/model"
+	"github.com/leslie-wang/clusterd/common/util"
+	"github.com/leslie-wang/clusterd/types"
+)
+
+// DefaultStreamSourceURL is the url to pull the stream recorded by record rule
+const DefaultStreamSourceURL = "rtmp://{domain}/{app}/{stream}"
+
+// streamEvent receives push start / stop callback of the stream. The stream is recorded by the matching
+// record rule after it starts, and the recording is stopped when it stops.
+func (h *Handler) streamEvent(w http.ResponseWriter, r *http.Request) {
+	event := &types.LiveCallbackStreamEvent{}
+	err := json.NewDecoder(r.Body).Decode(event)
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+
+	if event.App == "" || event.StreamID == "" {
+		util.WriteError(w, errors.New(model.INVALIDPARAMETERVALUE))
+		return
+	}
+
+	// start and stop of the same stream may come at the same time
+	h.streamLock.Lock()
+	defer h.streamLock.Unlock()
+
+	switch event.EventType {
+	case types.LiveCallbackEventTypePushStart:
+		err = h.startAutoRecord(r.Context(), event)
+	case types.LiveCallbackEventTypePushStop:
+		err = h.stopAutoRecord(r.Context(), event)
+	default:
+		err = errors.New(model.INVALIDPARAMETERVALUE)
+	}
+	if err != nil {
+		util.WriteError(w, err)
+	}
+}
+
+func (h *Handler) startAutoRecord(ctx context.Context, event *types.LiveCallbackStreamEvent) error {
+	rule, err := h.recordDB.MatchRecordRule(ctx, event.App, event.AppName, event.StreamID)
+	if err != nil {
+		return err
+	}
+	if rule == nil {
+		h.logger.Debugf("no record rule for stream %s/%s/%s", event.App, event.AppName, event.StreamID)
+		return nil
+	}
+
+	ids, err := h.recordDB.ListAutoRecordJobs(ctx, event.App, event.AppName, event.StreamID)
+	if err != nil {
+		return err
+	}
+	if len(ids) != 0 {
+		// start event is sent again, e.g. after the ingest server restarts
+		h.logger.Infof("stream %s/%s/%s is being recorded by %v", event.App, event.AppName, event.StreamID, ids)
+		return nil
+	}
+
+	// stop event may be lost, so the recording ends anyway after max duration
+	end := uint64(time.Now().Add(h.cfg.AutoRecordMaxDur).Unix())
+	params := &model.CreateRecordTaskRequestParams{
+		DomainName: &event.App,
+		AppName:    &event.AppName,
+		StreamName: &event.StreamID,
+		EndTime:    &end,
+		RecordStreams: []model.RecordInputStream{
+			{SourceURL: h.mkStreamSourceURL(event.App, event.AppName, event.StreamID)},
+		},
+	}
+	if rule.TemplateId != nil {
+		tmpl := uint64(*rule.TemplateId)
+		params.TemplateId = &tmpl
+	}
+
+	id, err := h.createRecordTask(&types.LiveRecordTask{CreateRecordTaskRequestParams: params, AutoRecord: true})
+	if err != nil {
+		return err
+	}
+	h.logger.Infof("stream %s/%s/%s is started, record it by task %d", event.App, event.AppName, event.StreamID, id)
+	return nil
+}
+
+func (h *Handler) stopAutoRecord(ctx context.Context, event *types.LiveCallbackStreamEvent) error {
+	ids, err := h.recordDB.ListAutoRecordJobs(ctx, event.App, event.AppName, event.StreamID)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		h.logger.Infof("stream %s/%s/%s is stopped, stop record job %d", event.App, event.AppName, event.StreamID, id)
+		err = h.stopRecordTask(id)
+		if err != nil && !errors.Is(err, util.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// mkStreamSourceURL returns url to pull the stream
+func (h *Handler) mkStreamSourceURL(domain, app, stream string) string {
+	source := h.cfg.StreamSourceURL
+	if source == "" {
+		source = DefaultStreamSourceURL
+	}
+	return strings.NewReplacer("{domain}", domain, "{app}", app, "{stream}", stream).Replace(source)
+}
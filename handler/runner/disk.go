@@ -0,0 +1,108 @@
+package runner
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/util"
+)
+
+const (
+	// diskCheckInterval is how often free space is checked while recording
+	diskCheckInterval = 5 * time.Second
+	// diskUsedRefresh is how long space used by media dir is cached, since it walks the whole directory
+	diskUsedRefresh = time.Minute
+	// recordReserveSpace is free space below which recordings are stopped, so that ffmpeg can still close files
+	recordReserveSpace = 64 << 20
+)
+
+// diskUsage caches space used by media dir, and remembers whether free space is low
+type diskUsage struct {
+	lock     sync.Mutex
+	used     uint64
+	usedTime time.Time
+	low      bool
+}
+
+// diskSpace returns bytes free in the filesystem of media dir, and used by media dir
+func (h *Handler) diskSpace() (free, used uint64) {
+	free, err := util.FreeSpace(h.c.Workdir)
+	if err != nil {
+		h.logger.Warnf("free space of %s: %s", h.c.Workdir, err)
+	}
+
+	h.disk.lock.Lock()
+	defer h.disk.lock.Unlock()
+	if time.Since(h.disk.usedTime) > diskUsedRefresh {
+		h.disk.used, err = util.DirSize(h.c.Workdir)
+		if err != nil {
+			h.logger.Warnf("used space of %s: %s", h.c.Workdir, err)
+		}
+		h.disk.usedTime = time.Now()
+	}
+	return free, h.disk.used
+}
+
+// hasFreeSpace checks whether media dir has enough space to acquire new jobs
+func (h *Handler) hasFreeSpace() bool {
+	if h.c.MinFreeSpace == 0 {
+		return true
+	}
+	free, err := util.FreeSpace(h.c.Workdir)
+	if err != nil {
+		// unknown free space doesn't block recording
+		h.logger.Warnf("free space of %s: %s", h.c.Workdir, err)
+		return true
+	}
+	low := free < h.c.MinFreeSpace
+
+	h.disk.lock.Lock()
+	defer h.disk.lock.Unlock()
+	if low && !h.disk.low {
+		h.logger.Warnf("only %d bytes free in %s, stop acquiring jobs until it is more than %d", free,
+			h.c.Workdir, h.c.MinFreeSpace)
+	} else if !low && h.disk.low {
+		h.logger.Infof("%d bytes free in %s, acquire jobs again", free, h.c.Workdir)
+	}
+	h.disk.low = low
+	return !low
+}
+
+// watchSpace stops the recording in the directory when its filesystem runs out of space. It returns whether
+// the recording is stopped by it.
+func (h *Handler) watchSpace(ctx context.Context, id int, dir string, stop context.CancelFunc) *atomic.Bool {
+	exhausted := &atomic.Bool{}
+	if h.c.MinFreeSpace == 0 {
+		return exhausted
+	}
+	reserve := uint64(recordReserveSpace)
+	if h.c.MinFreeSpace < reserve {
+		reserve = h.c.MinFreeSpace
+	}
+
+	go func() {
+		ticker := time.NewTicker(diskCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			free, err := util.FreeSpace(dir)
+			if err != nil {
+				h.logger.Warnf("free space of %s: %s", dir, err)
+				continue
+			}
+			if free < reserve {
+				h.logger.Warnf("only %d bytes free in %s, stop recording %d", free, dir, id)
+				exhausted.Store(true)
+				stop()
+				return
+			}
+		}
+	}()
+	return exhausted
+}
@@ -0,0 +1,36 @@
+package runner
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/leslie-wang/clusterd/common/logger"
+	"github.com/leslie-wang/clusterd/common/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHandler(t *testing.T, c Config) *Handler {
+	dir := t.TempDir()
+	if c.Workdir == "" {
+		c.Workdir = dir
+	}
+	return &Handler{c: c, logger: logger.New(1, 1, filepath.Join(dir, "cd-runner.log"))}
+}
+
+func TestHasFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	free, err := util.FreeSpace(dir)
+	require.Nil(t, err)
+
+	// disabled
+	assert.True(t, newTestHandler(t, Config{Workdir: dir}).hasFreeSpace())
+
+	h := newTestHandler(t, Config{Workdir: dir, MinFreeSpace: 1 << 20})
+	assert.True(t, h.hasFreeSpace())
+	assert.False(t, h.disk.low)
+
+	h = newTestHandler(t, Config{Workdir: dir, MinFreeSpace: free + 1<<40})
+	assert.False(t, h.hasFreeSpace())
+	assert.True(t, h.disk.low)
+}
@@ -1,10 +1,10 @@
 package runner
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
@@ -21,7 +21,7 @@ import (
 	"github.com/leslie-wang/clusterd/common"
 	"github.com/leslie-wang/clusterd/common/hls"
 	"github.com/leslie-wang/clusterd/common/logger"
-	"github.com/leslie-wang/clusterd/common/util"
+	"github.com/leslie-wang/clusterd/common/storage"
 	"github.com/leslie-wang/clusterd/types"
 	"github.com/pkg/errors"
 )
@@ -30,6 +30,12 @@ const (
 	recordFilename    = "index.m3u8"
 	logStdoutFilename = "record-%d_out.log"
 	logStderrFilename = "record-%d_err.log"
+
+	// noSpaceMessage is in ffmpeg's log when it fails to write since the disk is full
+	noSpaceMessage = "No space left on device"
+
+	// ffmpegStopTimeout is how long to wait ffmpeg exits after interrupt before killing it
+	ffmpegStopTimeout = 30 * time.Second
 )
 
 // Config is configuration for the handler
@@ -37,12 +43,24 @@ type Config struct {
 	MgrHost  string
 	MgrPort  uint
 	Name     string
+	Address  string
 	Workdir  string
 	Interval time.Duration
+	MaxJobs  int
+
+	HeartbeatInterval time.Duration
+
+	// bytes free in media dir to acquire new jobs. Recordings are stopped when it is almost full. 0 disables
+	// the check.
+	MinFreeSpace uint64
 
-	LogDir       string
-	MaxLogSize   int
-	MaxLogBackup int
+	// store to upload recorded media, nothing is uploaded if Type is empty, i.e. media dir is shared
+	Storage storage.Config
+
+	LogDir          string
+	JobLogRetention time.Duration
+	MaxLogSize      int
+	MaxLogBackup    int
 }
 
 // Handler is structure for recorder API
@@ -50,13 +68,17 @@ type Handler struct {
 	c Config
 	r *mux.Router
 
-	runningJobID int
-	lock         *sync.Mutex
+	running map[int]context.CancelFunc // <job ID, cancel function of the job>
+	lock    *sync.Mutex
 
 	logger *logger.Logger
 
 	reportChan chan types.JobStatus
 	cli        *manager.Client
+
+	store storage.Store // nil if media dir is shared with manager
+
+	disk diskUsage
 }
 
 // NewHandler create new instance of Handler struct
@@ -66,12 +88,29 @@ func NewHandler(c Config) (*Handler, error) {
 		return nil, err
 	}
 
+	if c.MaxJobs <= 0 {
+		c.MaxJobs = 1
+	}
+
 	h := &Handler{c: c, lock: &sync.Mutex{}, reportChan: make(chan types.JobStatus),
-		logger: logger.New(c.MaxLogSize, c.MaxLogBackup, filepath.Join(c.LogDir, "cd-runner.log")),
+		running: map[int]context.CancelFunc{},
+		logger:  logger.New(c.MaxLogSize, c.MaxLogBackup, filepath.Join(c.LogDir, "cd-runner.log")),
 	}
 	h.cli = manager.NewClient(c.MgrHost, c.MgrPort)
+	// free space is checked in media dir before any job runs
+	err = os.MkdirAll(c.Workdir, 0777)
+	if err != nil {
+		return nil, err
+	}
+	if c.Storage.Type != "" {
+		h.store, err = storage.New(c.Storage)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	go h.reportLoop()
+	go h.cleanupLogLoop()
 
 	return h, nil
 }
@@ -84,77 +123,29 @@ func (h *Handler) CreateRouter() *mux.Router {
 	return h.r
 }
 
-func (h *Handler) jobLog(w http.ResponseWriter, r *http.Request) {
-	jobID := mux.Vars(r)[types.ID]
-
-	dir := filepath.Join(h.c.Workdir, jobID)
-	f, err := os.Open(filepath.Join(dir, logStdoutFilename))
-	if err != nil {
-		if os.IsNotExist(err) {
-			util.WriteError(w, util.ErrNotExist)
-		} else {
-			util.WriteError(w, err)
-		}
-		return
-	}
-	defer f.Close()
-
-	for {
-		_, err := io.CopyN(w, f, 4096)
-		if err == nil {
-			continue
-		}
-		if err != io.EOF {
-			util.WriteError(w, err)
-			return
-		}
-
-		h.lock.Lock()
-		if jobID != strconv.Itoa(h.runningJobID) {
-			h.lock.Unlock()
-			return
-		}
-		h.lock.Unlock()
-
-		// still writing logs, so wait and retry
-		time.Sleep(time.Second)
-	}
-}
-
 func (h *Handler) Run(ctx context.Context) error {
+	go h.heartbeatLoop(ctx)
+
 	count := 0
 	for {
-		job, err := h.cli.AcquireJob(h.c.Name)
-		if err != nil {
-			h.logger.Infof("Request job: %s", err)
-		} else if job != nil {
-			h.logger.Infof("Run job: %v", job)
-			h.lock.Lock()
-			h.runningJobID = job.ID
-			h.lock.Unlock()
-			status, err := h.runJob(ctx, job)
-			if err != nil {
-				h.logger.Infof("Handle job %+v: %v", job, err)
-			}
-			if status == nil {
-				goto wait
-			}
-			h.lock.Lock()
-			h.runningJobID = 0
-			h.lock.Unlock()
-			err = h.cli.ReportJobStatus(status)
+		if h.hasFreeSlot() && h.hasFreeSpace() {
+			job, err := h.cli.AcquireJob(h.c.Name, h.mkHeartbeat())
 			if err != nil {
-				h.logger.Infof("Report job %+v: %v", job, err)
-			}
-		} else {
-			count++
-			if count > int(5*time.Minute/h.c.Interval) {
-				h.logger.Infof("No jobs in 5 minutes, sleep")
-				count = 0
+				h.logger.Infof("Request job: %s", err)
+			} else if job != nil {
+				h.logger.Infof("Run job: %v", job)
+				h.startJob(ctx, job)
+				// acquire next one right away while there is still free slot
+				continue
+			} else {
+				count++
+				if count > int(5*time.Minute/h.c.Interval) {
+					h.logger.Infof("No jobs in 5 minutes, sleep")
+					count = 0
+				}
 			}
 		}
 
-	wait:
 		after := time.After(h.c.Interval)
 		select {
 		case <-after:
@@ -164,6 +155,63 @@ func (h *Handler) Run(ctx context.Context) error {
 	}
 }
 
+// startJob runs the job in its own slot, and reports the final status after it is done
+func (h *Handler) startJob(ctx context.Context, job *types.Job) {
+	jobCtx, cancel := context.WithCancel(ctx)
+
+	h.lock.Lock()
+	h.running[job.ID] = cancel
+	h.lock.Unlock()
+
+	go func() {
+		defer func() {
+			cancel()
+			h.lock.Lock()
+			delete(h.running, job.ID)
+			h.lock.Unlock()
+		}()
+
+		status, err := h.runJob(jobCtx, job)
+		if err != nil {
+			h.logger.Infof("Handle job %+v: %v", job, err)
+		}
+		if status == nil {
+			return
+		}
+		err = h.report(status)
+		if err != nil {
+			h.logger.Infof("Report job %+v: %v", job, err)
+		}
+	}()
+}
+
+// cancelJob stops the running job, e.g. it is not owned by current runner anymore
+func (h *Handler) cancelJob(id int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	cancel, ok := h.running[id]
+	if ok {
+		h.logger.Warnf("cancel job %d", id)
+		cancel()
+	}
+}
+
+func (h *Handler) isRunning(id int) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	_, ok := h.running[id]
+	return ok
+}
+
+func (h *Handler) hasFreeSlot() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return len(h.running) < h.c.MaxJobs
+}
+
 func (h *Handler) runJob(ctx context.Context, j *types.Job) (*types.JobStatus, error) {
 	if j.Category == types.CategoryRecord {
 		r := &types.JobRecord{}
@@ -184,33 +232,55 @@ func (h *Handler) runJob(ctx context.Context, j *types.Job) (*types.JobStatus, e
 		}
 
 		runCtx, cancel := context.WithCancel(ctx)
-		go func() {
-			//pull status, and cancel job if it is deleted
-			for {
-				currentJob, err := h.cli.GetJob(j.ID)
-				if err != nil {
-					h.logger.Infof("get job ID failure: %s\n", err)
-					goto sleep
-				}
-				if currentJob.EndTime == nil {
-					// not finished, sleep
-					goto sleep
-				}
+		defer cancel()
+		// latest pause state, which is replaced rather than waited for, so that polling isn't blocked until the
+		// recording reads it, and stop or revocation is still noticed
+		pauseChan := make(chan bool, 1)
+		go h.pollJob(runCtx, cancel, j.ID, pauseChan)
+		return h.runRecordJob(runCtx, j.ID, r, pauseChan)
+	}
+	return nil, fmt.Errorf("unknown job category: %v", j)
+}
 
-				cancel()
-				return
-			sleep:
-				after := time.After(5 * time.Second)
-				select {
-				case <-after:
-				case <-runCtx.Done():
-					return
-				}
+// jobPollInterval is how often a running job is pulled from manager
+var jobPollInterval = 5 * time.Second
+
+// pollJob pulls status of the job, and cancels it if it is ended, stopped or not owned anymore. Otherwise whether
+// it is paused replaces the one in pauseChan.
+func (h *Handler) pollJob(ctx context.Context, cancel context.CancelFunc, jobID int, pauseChan chan bool) {
+	for {
+		currentJob, err := h.cli.GetJob(jobID)
+		if err != nil {
+			h.logger.Infof("get job ID failure: %s\n", err)
+			goto sleep
+		}
+		switch {
+		case currentJob.EndTime != nil:
+			h.logger.Infof("job %d is ended at %s", jobID, currentJob.EndTime)
+		case currentJob.StopTime != nil:
+			h.logger.Infof("job %d is requested to stop at %s", jobID, currentJob.StopTime)
+		case currentJob.RunningHost == nil || *currentJob.RunningHost != h.c.Name:
+			h.logger.Warnf("job %d is not owned by %s anymore", jobID, h.c.Name)
+		default:
+			// not finished, tell the recording whether it is paused, and sleep
+			select {
+			case <-pauseChan:
+			default:
 			}
-		}()
-		return h.runRecordJob(runCtx, j.ID, r)
+			pauseChan <- currentJob.PauseTime != nil
+			goto sleep
+		}
+
+		cancel()
+		return
+	sleep:
+		after := time.After(jobPollInterval)
+		select {
+		case <-after:
+		case <-ctx.Done():
+			return
+		}
 	}
-	return nil, fmt.Errorf("unknown job category: %v", j)
 }
 
 const sdpTemplate = `SDP:
@@ -227,13 +297,11 @@ m=audio %s RTP/AVP 97
 a=rtpmap:97 opus/48000/2
 `
 
-func (h *Handler) runRecordJob(ctx context.Context, id int, r *types.JobRecord) (*types.JobStatus, error) {
+func (h *Handler) runRecordJob(ctx context.Context, id int, r *types.JobRecord,
+	pauseChan <-chan bool) (*types.JobStatus, error) {
 	var runCtx context.Context
 	if r.EndTime != nil {
-		var (
-			duration time.Duration
-			cancel   context.CancelFunc
-		)
+		var cancel context.CancelFunc
 		if r.StartTime != nil {
 			startTime := time.Unix(int64(*r.StartTime), 0)
 			if startTime.Before(time.Now()) {
@@ -241,17 +309,19 @@ func (h *Handler) runRecordJob(ctx context.Context, id int, r *types.JobRecord)
 			} else {
 				time.Sleep(time.Until(startTime))
 			}
-			duration = time.Duration(*r.EndTime-*r.StartTime) * time.Second
-		} else {
-			duration = time.Until(time.Unix(int64(*r.EndTime), 0))
 		}
-		runCtx, cancel = context.WithTimeout(ctx, duration)
+		// always end at the end time, even the job is picked up late, e.g. requeued from a lost runner
+		runCtx, cancel = context.WithDeadline(ctx, time.Unix(int64(*r.EndTime), 0))
 		defer cancel()
 	} else {
 		// no stop time until it is stopped by api
-		runCtx = context.Background()
+		runCtx = ctx
 	}
 
+	// recording is stopped early if media dir runs out of space
+	runCtx, stopRecord := context.WithCancel(runCtx)
+	defer stopRecord()
+
 	go h.addReport(types.JobStatus{ID: id, Type: types.RecordJobStart})
 
 	storePath := r.StorePath
@@ -267,9 +337,18 @@ func (h *Handler) runRecordJob(ctx context.Context, id int, r *types.JobRecord)
 			Stdout:   err.Error(),
 		}, err
 	}
+	uploader := h.newMediaUploader(id, dir)
+	err = uploader.restore(ctx)
+	if err != nil {
+		return &types.JobStatus{
+			ID:       id,
+			ExitCode: -1,
+			Stdout:   err.Error(),
+		}, err
+	}
 	masterIndexFilename := filepath.Join(dir, recordFilename)
 
-	var args []string
+	var args, codecArgs []string
 	sourceURL := r.RecordStreams[0].SourceURL
 	if r.RecordTimeout > 0 {
 		args = []string{"-rw_timeout", fmt.Sprintf("%d", r.RecordTimeout)}
@@ -300,21 +379,33 @@ func (h *Handler) runRecordJob(ctx context.Context, id int, r *types.JobRecord)
 		if err != nil {
 			return nil, err
 		}
-		args = append(args, "-protocol_whitelist", "file,udp,rtp", "-i", sourceURL, "-vcodec", "copy",
-			"-acodec", "aac", "-bsf:a", "aac_adtstoasc", "-hls_time", fmt.Sprintf("%d", r.HlsSegmentDuration),
-			"-hls_playlist_type", "event", "-hls_segment_type", "fmp4", "-hls_segment_filename", "%d.m4s", masterIndexFilename)
+		args = append(args, "-protocol_whitelist", "file,udp,rtp", "-i", sourceURL)
+		codecArgs = []string{"-vcodec", "copy", "-acodec", "aac", "-bsf:a", "aac_adtstoasc"}
 	} else {
-		args = append(args, "-i", sourceURL, "-c", "copy", "-bsf:a", "aac_adtstoasc",
-			"-hls_time", fmt.Sprintf("%d", r.HlsSegmentDuration),
-			"-hls_playlist_type", "event", "-hls_segment_type", "fmp4", "-hls_segment_filename", "%d.m4s", masterIndexFilename)
+		args = append(args, "-i", sourceURL)
+		codecArgs = []string{"-c", "copy", "-bsf:a", "aac_adtstoasc"}
 	}
 
-	h.logger.Infof("record started: ffmpeg %v\n", args)
-	cmd := exec.CommandContext(runCtx, "ffmpeg", args...)
-	cmd.Dir = dir
+	outputs := r.Outputs
+	if len(outputs) == 0 {
+		outputs = []types.RecordOutput{{Format: types.RecordFormatHLS}}
+	}
+	for _, o := range outputs {
+		if !isHLSFormat(o.Format) {
+			err = os.MkdirAll(filepath.Join(dir, o.Format), 0777)
+			if err != nil {
+				return &types.JobStatus{
+					ID:       id,
+					ExitCode: -1,
+					Stdout:   err.Error(),
+				}, err
+			}
+		}
+	}
+	recordHLS := hasHLSOutput(outputs)
 
-	logoutFilename := filepath.Join(h.c.LogDir, fmt.Sprintf(logStdoutFilename, id))
-	logoutFile, err := os.Create(logoutFilename)
+	logoutFilename := h.jobLogFilename(id, types.LogStreamStdout)
+	logoutFile, err := openJobLog(logoutFilename)
 	if err != nil {
 		return &types.JobStatus{
 			ID:       id,
@@ -324,8 +415,8 @@ func (h *Handler) runRecordJob(ctx context.Context, id int, r *types.JobRecord)
 	}
 	defer logoutFile.Close()
 
-	logerrFilename := filepath.Join(h.c.LogDir, fmt.Sprintf(logStderrFilename, id))
-	logerrFile, err := os.Create(logerrFilename)
+	logerrFilename := h.jobLogFilename(id, types.LogStreamStderr)
+	logerrFile, err := openJobLog(logerrFilename)
 	if err != nil {
 		return &types.JobStatus{
 			ID:       id,
@@ -335,55 +426,92 @@ func (h *Handler) runRecordJob(ctx context.Context, id int, r *types.JobRecord)
 	}
 	defer logerrFile.Close()
 
-	// start count record
-	go h.generateIntermittentDownloadIndexFile(runCtx, r, id, dir, masterIndexFilename)
-
-	errChan := make(chan error)
-	go func() {
-		cmd.Stdout = logoutFile
-		cmd.Stderr = logerrFile
+	// keep uploading logs until ffmpeg exits
+	shipCtx, stopShip := context.WithCancel(context.Background())
+	shipped := h.shipLogs(shipCtx, id, map[string]string{
+		types.LogStreamStdout: logoutFilename,
+		types.LogStreamStderr: logerrFilename,
+	})
+	// keep uploading media until the playlist is finalized
+	uploadCtx, stopUpload := context.WithCancel(context.Background())
+	uploaded := uploader.loop(uploadCtx)
+	noSpace := h.watchSpace(runCtx, id, dir, stopRecord)
+
+	if recordHLS {
+		// start count record
+		go h.generateIntermittentDownloadIndexFile(runCtx, r, id, dir, masterIndexFilename, uploader)
+	}
 
-		err = cmd.Run()
-		if err != nil {
-			err = fmt.Errorf("%v: %s", args, err)
-		} else if cmd.ProcessState == nil {
-			err = fmt.Errorf("empty process state after run")
+	rec := &ffmpegRecord{
+		dir:           dir,
+		indexFilename: masterIndexFilename,
+		args:          args,
+		codecArgs:     codecArgs,
+		hls:           recordHLS,
+		outputs:       outputs,
+		segDuration:   r.HlsSegmentDuration,
+		stdout:        logoutFile,
+		stderr:        logerrFile,
+		uploader:      uploader,
+	}
+	var (
+		cmd    *exec.Cmd
+		paused bool
+	)
+	for {
+		cmd, paused, err = h.runFFmpeg(runCtx, rec, pauseChan)
+		if !paused {
+			break
 		}
-		errChan <- err
-	}()
 
-	select {
-	case err = <-errChan:
-	case <-runCtx.Done():
-		err = runCtx.Err()
-		if err == context.DeadlineExceeded {
-			// recording is end now.
-			h.logger.Warnf("recording is exceeding deadline")
-			err = nil
+		h.logger.Infof("recording %d is paused", id)
+		duration, size := h.recordedSize(rec)
+		go h.addReport(types.JobStatus{ID: id, Type: types.RecordJobPaused, Size: size, Duration: duration})
+		if !waitResume(runCtx, pauseChan) {
+			break
 		}
+		h.logger.Infof("recording %d is resumed", id)
+		go h.addReport(types.JobStatus{ID: id, Type: types.RecordJobResumed})
 	}
-
-	var duration, size uint64
-	mediaPL, err := hls.ParseMediaPlaylist(masterIndexFilename)
-	if err != nil {
-		h.logger.Warnf("parse master index file %s: %s", masterIndexFilename, err)
-	} else {
-		duration = hls.CalculateDuration(mediaPL)
-		size = hls.CalculateFileSize(dir, mediaPL, h.logger)
+	stopShip()
+	<-shipped
+
+	stopped := runCtx.Err() != nil
+	if stopped {
+		// recording is ended by deadline, stopped by api, or out of space. ffmpeg exits non-zero after interrupt.
+		h.logger.Infof("recording is stopped: %s", runCtx.Err())
+		err = nil
+
+		if recordHLS {
+			if ferr := hls.FinalizeMediaPlaylist(masterIndexFilename); ferr != nil {
+				h.logger.Warnf("finalize %s: %s", masterIndexFilename, ferr)
+			}
+		}
 	}
+	stopUpload()
+	<-uploaded
 
-	exitCode := cmd.ProcessState.ExitCode()
-	if err == nil && exitCode == 0 {
+	duration, size := h.recordedSize(rec)
+	mediaStart, mediaEnd := h.recordedTime(rec)
+
+	exitCode := -1
+	if cmd != nil && cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	if (stopped && !noSpace.Load()) || (err == nil && exitCode == 0) {
 		h.logger.Infof("recording finished")
+		uploader.cleanup()
 
 		return &types.JobStatus{
-			ID:       id,
-			Type:     types.RecordJobEnd,
-			Size:     size,
-			Duration: duration,
+			ID:             id,
+			Type:           types.RecordJobEnd,
+			Size:           size,
+			Duration:       duration,
+			MediaStartTime: mediaStart,
+			MediaEndTime:   mediaEnd,
 		}, nil
 	} else {
-		h.logger.Infof("record exitcode: %d, err: %s", cmd.ProcessState.ExitCode(), err)
+		h.logger.Infof("record exitcode: %d, err: %s", exitCode, err)
 	}
 	sout, err := os.ReadFile(logoutFilename)
 	if err != nil {
@@ -394,7 +522,7 @@ func (h *Handler) runRecordJob(ctx context.Context, id int, r *types.JobRecord)
 		h.logger.Warnf("read stderr log file %s: %s", logerrFilename, err)
 	}
 
-	return &types.JobStatus{
+	status := &types.JobStatus{
 		ID:       id,
 		Type:     types.RecordJobException,
 		ExitCode: exitCode,
@@ -402,18 +530,216 @@ func (h *Handler) runRecordJob(ctx context.Context, id int, r *types.JobRecord)
 		Stderr:   string(serr),
 		Size:     size,
 		Duration: duration,
-	}, nil
+	}
+	if noSpace.Load() || bytes.Contains(serr, []byte(noSpaceMessage)) {
+		status.ExitCode = types.ExitCodeNoSpace
+		status.Detail = fmt.Sprintf("no space left in %s", dir)
+		// recording isn't retried, give the space back once files are in the store
+		uploader.cleanup()
+	}
+	return status, nil
+}
+
+// ffmpegRecord is what is needed to start ffmpeg for a recording
+type ffmpegRecord struct {
+	dir           string
+	indexFilename string
+	args          []string // input arguments
+	codecArgs     []string // codec arguments of HLS and FLV
+	hls           bool     // whether to record HLS
+	outputs       []types.RecordOutput
+	segDuration   uint
+	stdout        *os.File
+	stderr        *os.File
+	uploader      *mediaUploader
+}
+
+// runFFmpeg records into the job directory until ffmpeg exits. It continues the existing playlist if
+// there is one. ffmpeg is interrupted when the recording is paused, and paused is true in that case.
+func (h *Handler) runFFmpeg(ctx context.Context, rec *ffmpegRecord,
+	pauseChan <-chan bool) (cmd *exec.Cmd, paused bool, err error) {
+	args := append([]string{}, rec.args...)
+
+	var resume *recordResume
+	if rec.hls {
+		// continue into existing playlist if the recording was interrupted, e.g. ffmpeg failed or runner was lost
+		var resumeArgs []string
+		resume, resumeArgs, err = h.prepareResume(rec.dir, rec.indexFilename, rec.uploader.fileSize(rec.dir))
+		if err != nil {
+			return nil, false, err
+		}
+		outputFilename := rec.indexFilename
+		if resume != nil {
+			outputFilename = resume.playlistFilename
+		}
+		args = append(args, rec.codecArgs...)
+		args = append(args, "-hls_time", fmt.Sprintf("%d", rec.segDuration),
+			"-hls_playlist_type", "event", "-hls_segment_type", "fmp4", "-hls_segment_filename", "%d.m4s",
+			"-hls_flags", "program_date_time")
+		args = append(args, resumeArgs...)
+		args = append(args, outputFilename)
+	}
+	args = append(args, fileOutputArgs(rec.codecArgs, rec.outputs)...)
+
+	ffmpegCtx, stopFFmpeg := context.WithCancel(ctx)
+	defer stopFFmpeg()
+
+	h.logger.Infof("record started: ffmpeg %v\n", args)
+	cmd = exec.CommandContext(ffmpegCtx, "ffmpeg", args...)
+	cmd.Dir = rec.dir
+	// interrupt ffmpeg instead of killing it, so it can flush last segment and close the playlist
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = ffmpegStopTimeout
+	cmd.Stdout = rec.stdout
+	cmd.Stderr = rec.stderr
+
+	// keep merging new segments into index playlist until ffmpeg exits
+	var merged <-chan struct{}
+	mergeCtx, stopMerge := context.WithCancel(context.Background())
+	if resume != nil {
+		merged = h.mergeLoop(mergeCtx, resume)
+	}
+
+	errChan := make(chan error)
+	go func() {
+		err := cmd.Run()
+		if err != nil {
+			err = fmt.Errorf("%v: %s", args, err)
+		} else if cmd.ProcessState == nil {
+			err = fmt.Errorf("empty process state after run")
+		}
+		errChan <- err
+	}()
+
+	for done := false; !done; {
+		select {
+		case err = <-errChan:
+			done = true
+		case p := <-pauseChan:
+			if p && !paused {
+				paused = true
+				stopFFmpeg()
+			}
+		}
+	}
+
+	stopMerge()
+	if merged != nil {
+		<-merged
+	}
+	return cmd, paused && ctx.Err() == nil, err
+}
+
+// waitResume blocks while the recording is paused. It returns false if the recording is ended.
+func waitResume(ctx context.Context, pauseChan <-chan bool) bool {
+	for {
+		select {
+		case paused := <-pauseChan:
+			if !paused {
+				return true
+			}
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// recordedSize returns duration in milliseconds and size of what is recorded in HLS playlist
+func (h *Handler) recordedSize(rec *ffmpegRecord) (duration, size uint64) {
+	if !rec.hls {
+		return
+	}
+	content, err := os.ReadFile(rec.indexFilename)
+	if err != nil {
+		h.logger.Warnf("read master index file %s: %s", rec.indexFilename, err)
+		return
+	}
+	mediaPL, err := hls.UnmarshalMediaPlaylist(content)
+	if err != nil {
+		h.logger.Warnf("parse master index file %s: %s", rec.indexFilename, err)
+		return
+	}
+	return hls.CalculateDuration(mediaPL), hls.CalculateFileSize(content, rec.uploader.fileSize(rec.dir), h.logger)
+}
+
+// recordedTime returns unix milliseconds of the beginning and the end of what is recorded in HLS playlist
+func (h *Handler) recordedTime(rec *ffmpegRecord) (start, end int64) {
+	if !rec.hls {
+		return
+	}
+	mediaPL, err := hls.ParseMediaPlaylist(rec.indexFilename)
+	if err != nil {
+		h.logger.Warnf("parse master index file %s: %s", rec.indexFilename, err)
+		return
+	}
+	return mediaTime(mediaPL)
+}
+
+// mediaTime returns unix milliseconds of the beginning and the end of the playlist, or 0 if they are unknown
+func mediaTime(mediaPL *playlist.Media) (start, end int64) {
+	st, et, ok := hls.MediaTimeRange(mediaPL)
+	if !ok {
+		return 0, 0
+	}
+	return st.UnixMilli(), et.UnixMilli()
+}
+
+// isHLSFormat returns whether the format is recorded as HLS. MP4 files are cut from HLS playlist.
+func isHLSFormat(format string) bool {
+	return format == types.RecordFormatHLS || format == types.RecordFormatMP4
+}
+
+func hasHLSOutput(outputs []types.RecordOutput) bool {
+	for _, o := range outputs {
+		if isHLSFormat(o.Format) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileOutputArgs returns ffmpeg arguments of formats other than HLS, which are written into the
+// directory of the format, one file each interval. Files are named by start time, so they are not
+// overwritten when ffmpeg is started again for the same recording.
+func fileOutputArgs(codecArgs []string, outputs []types.RecordOutput) []string {
+	var args []string
+	for _, o := range outputs {
+		var format string
+		switch o.Format {
+		case types.RecordFormatFLV:
+			args = append(args, codecArgs...)
+			format = "flv"
+		case types.RecordFormatAAC:
+			args = append(args, "-vn", "-acodec", "aac")
+			format = "adts"
+		case types.RecordFormatMP3:
+			args = append(args, "-vn", "-acodec", "libmp3lame")
+			format = "mp3"
+		default:
+			continue
+		}
+		if o.Interval == 0 {
+			args = append(args, "-f", format,
+				filepath.Join(o.Format, time.Now().Format("20060102-150405")+"."+o.Format))
+			continue
+		}
+		args = append(args, "-f", "segment", "-segment_format", format, "-segment_time", strconv.Itoa(int(o.Interval)),
+			"-reset_timestamps", "1", "-strftime", "1", filepath.Join(o.Format, "%Y%m%d-%H%M%S."+o.Format))
+	}
+	return args
 }
 
 func (h *Handler) generateIntermittentDownloadIndexFile(ctx context.Context, r *types.JobRecord,
-	id int, dir, masterIndexFilename string) {
+	id int, dir, masterIndexFilename string, uploader *mediaUploader) {
 	if r.Mp4FileDuration <= 0 {
 		h.logger.Warnf("invalid mp4 record file duration: %ds", r.Mp4FileDuration)
 		return
 	}
-	index := 0
+	// continue numbering after media files created before the recording is resumed
+	index := lastDownloadIndex(dir)
 	for {
-		index++
 		start := time.Now()
 		after := time.After(time.Duration(r.Mp4FileDuration) * time.Second)
 		select {
@@ -421,58 +747,35 @@ func (h *Handler) generateIntermittentDownloadIndexFile(ctx context.Context, r *
 		case <-ctx.Done():
 			return
 		}
-		// create a new m3u8 file
-		dlIndexFilename := fmt.Sprintf("dl%d.m3u8", index)
-		lastIndexFilename := fmt.Sprintf("dl%d.m3u8", index-1)
-		var (
-			err     error
-			content []byte
-			mediaPL *playlist.Media
-		)
-		if index == 1 {
-			fname := filepath.Join(dir, dlIndexFilename)
-			f, err := os.Create(fname)
-			if err != nil {
-				h.logger.Warnf("create %s: %s", dlIndexFilename, err)
-				continue
-			}
-
-			content, err = os.ReadFile(masterIndexFilename)
-			if err != nil {
-				h.logger.Warnf("read %s: %s", masterIndexFilename, err)
-			} else {
-				_, err = f.Write(content)
-				if err != nil {
-					h.logger.Warnf("copy %s into %s: %s", masterIndexFilename, dlIndexFilename, err)
-				}
-			}
-			err = f.Close()
-			if err != nil {
-				h.logger.Warnf("close %s: %s", dlIndexFilename, err)
-				continue
-			}
+		// create a new m3u8 file, number is taken only after it is written, e.g. no new segment while paused
+		dlIndexFilename := fmt.Sprintf("dl%d.m3u8", index+1)
+		lastIndexFilename := fmt.Sprintf("dl%d.m3u8", index)
+		fname := filepath.Join(dir, dlIndexFilename)
 
-			mediaPL, err = hls.ParseMediaPlaylist(fname)
-			if err != nil {
-				h.logger.Warnf("parse %s: %s", fname, err)
-				continue
-			}
-		} else {
-			// copy last media file
-			mediaPL, err = h.trimSegments(masterIndexFilename, filepath.Join(dir, lastIndexFilename))
+		content, err := os.ReadFile(masterIndexFilename)
+		if err != nil {
+			h.logger.Warnf("read %s: %s", masterIndexFilename, err)
+			continue
+		}
+		if index > 0 {
+			// only keep segments after the last media file
+			content, err = h.trimSegments(content, filepath.Join(dir, lastIndexFilename))
 			if err != nil {
 				h.logger.Warnf("trim segment for %s: %s", dlIndexFilename, err)
 				continue
 			}
-			content, err = mediaPL.Marshal()
-			if err != nil {
-				h.logger.Warnf("marshal media playlist %s: %s", dlIndexFilename, content)
-				continue
-			}
-			err = os.WriteFile(filepath.Join(dir, dlIndexFilename), content, 0755)
-			if err != nil {
-				h.logger.Warnf("write media playlist %s: %s", dlIndexFilename, content)
-			}
+		}
+		err = os.WriteFile(fname, content, 0755)
+		if err != nil {
+			h.logger.Warnf("write media playlist %s: %s", dlIndexFilename, err)
+			continue
+		}
+		index++
+
+		mediaPL, err := hls.UnmarshalMediaPlaylist(content)
+		if err != nil {
+			h.logger.Warnf("parse %s: %s", fname, err)
+			continue
 		}
 
 		h.logger.Infof("Generated mp4 recording index file %s", filepath.Join(dir, dlIndexFilename))
@@ -487,13 +790,19 @@ func (h *Handler) generateIntermittentDownloadIndexFile(ctx context.Context, r *
 		}
 
 		duration := hls.CalculateDuration(mediaPL)
-		size := hls.CalculateFileSize(dir, mediaPL, h.logger)
-		err = h.cli.ReportJobStatus(&types.JobStatus{
-			ID:          id,
-			Type:        types.RecordMp4FileCreated,
-			Mp4Filename: timestampIndexFilename + ".mp4",
-			Duration:    duration,
-			Size:        size,
+		size := hls.CalculateFileSize(content, uploader.fileSize(dir), h.logger)
+		mediaStart, mediaEnd := mediaTime(mediaPL)
+		// the file is downloadable from the store once it is reported
+		uploader.sync()
+		err = h.report(&types.JobStatus{
+			ID:             id,
+			Type:           types.RecordMp4FileCreated,
+			Mp4Filename:    timestampIndexFilename + ".mp4",
+			Duration:       duration,
+			Size:           size,
+			MediaStartTime: mediaStart,
+			MediaEndTime:   mediaEnd,
+			FileIndex:      index,
 		})
 		if err != nil {
 			h.logger.Warnf("report mp4 file %s creation: %s", dlIndexFilename, content)
@@ -501,12 +810,9 @@ func (h *Handler) generateIntermittentDownloadIndexFile(ctx context.Context, r *
 	}
 }
 
-func (h *Handler) trimSegments(currentPl, lastPl string) (*playlist.Media, error) {
-	currMediaPL, err := hls.ParseMediaPlaylist(currentPl)
-	if err != nil {
-		return nil, err
-	}
-
+// trimSegments returns the current playlist with only segments after the last one of lastPl. It works on
+// text, so EXT-X-DISCONTINUITY and EXT-X-MAP of resumed recording are kept.
+func (h *Handler) trimSegments(current []byte, lastPl string) ([]byte, error) {
 	lastMediaPl, err := hls.ParseMediaPlaylist(lastPl)
 	if err != nil {
 		return nil, err
@@ -514,22 +820,20 @@ func (h *Handler) trimSegments(currentPl, lastPl string) (*playlist.Media, error
 
 	// find the last media segment uri
 	lastSegmentURI := lastMediaPl.Segments[len(lastMediaPl.Segments)-1].URI
+	h.logger.Infof("last download segment: %s", lastSegmentURI)
 
-	startIndex := 0
-	for i, s := range currMediaPL.Segments {
-		if s.URI == lastSegmentURI {
-			startIndex = i + 1
-			break
-		}
-	}
-	h.logger.Infof("last download segment: %s, new download segments %s -> %s", lastSegmentURI,
-		currMediaPL.Segments[startIndex].URI,
-		currMediaPL.Segments[len(currMediaPL.Segments)-1].URI)
+	return hls.TrimMediaPlaylist(current, lastSegmentURI)
+}
 
-	if startIndex == 0 {
-		return nil, errors.Errorf("unable to find last segment URI '%s' in current playlist", lastSegmentURI)
+// lastDownloadIndex returns the largest N of dlN.m3u8 in dir, or 0 if there is none
+func lastDownloadIndex(dir string) int {
+	index := 0
+	matches, _ := filepath.Glob(filepath.Join(dir, "dl*.m3u8"))
+	for _, m := range matches {
+		var n int
+		if _, err := fmt.Sscanf(filepath.Base(m), "dl%d.m3u8", &n); err == nil && n > index {
+			index = n
+		}
 	}
-
-	lastMediaPl.Segments = currMediaPL.Segments[startIndex:]
-	return lastMediaPl, nil
+	return index
 }
@@ -0,0 +1,79 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leslie-wang/clusterd/client/manager"
+	"github.com/leslie-wang/clusterd/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newManagerTestHandler creates handler whose manager is served by m
+func newManagerTestHandler(t *testing.T, m http.Handler) *Handler {
+	srv := httptest.NewServer(m)
+	t.Cleanup(srv.Close)
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	require.Nil(t, err)
+	p, err := strconv.Atoi(port)
+	require.Nil(t, err)
+
+	h := newTestHandler(t, Config{})
+	h.cli = manager.NewClient(host, uint(p))
+	return h
+}
+
+// fakeJobManager serves the job by the list of its states, the last one is kept
+type fakeJobManager struct {
+	mutex sync.Mutex
+	jobs  []types.Job
+}
+
+func (m *fakeJobManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job := m.jobs[0]
+	if len(m.jobs) > 1 {
+		m.jobs = m.jobs[1:]
+	}
+	json.NewEncoder(w).Encode(job)
+}
+
+// stop is noticed while the pause state isn't read by the recording
+func TestPollJobStopWhilePaused(t *testing.T) {
+	interval := jobPollInterval
+	jobPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { jobPollInterval = interval })
+
+	now := time.Now()
+	host := "runner"
+	m := &fakeJobManager{jobs: []types.Job{
+		{ID: 1, RunningHost: &host, PauseTime: &now},
+		{ID: 1, RunningHost: &host},
+		{ID: 1, RunningHost: &host, StopTime: &now},
+	}}
+	h := newManagerTestHandler(t, m)
+	h.c.Name = host
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pauseChan := make(chan bool, 1)
+	go h.pollJob(ctx, cancel, 1, pauseChan)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		cancel()
+		t.Fatal("stop isn't noticed")
+	}
+	// only the latest state is kept
+	assert.False(t, <-pauseChan)
+}
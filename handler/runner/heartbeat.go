@@ -0,0 +1,56 @@
+package runner
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/release"
+	"github.com/leslie-wang/clusterd/types"
+)
+
+// heartbeatLoop renews the lease of running jobs periodically, so manager won't requeue them
+func (h *Handler) heartbeatLoop(ctx context.Context) {
+	ticker := time.NewTicker(h.c.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		hb := h.mkHeartbeat()
+		resp, err := h.cli.Heartbeat(h.c.Name, hb)
+		if err != nil {
+			h.logger.Warnf("heartbeat %v: %s", hb.Jobs, err)
+			continue
+		}
+
+		for _, id := range resp.Revoked {
+			h.cancelJob(id)
+		}
+	}
+}
+
+// mkHeartbeat collects current runner's info and running jobs
+func (h *Handler) mkHeartbeat() *types.JobHeartbeat {
+	hb := &types.JobHeartbeat{
+		Address:  h.c.Address,
+		Version:  release.Version,
+		Capacity: h.c.MaxJobs,
+		Jobs:     []int{},
+	}
+
+	hb.DiskFree, hb.DiskUsed = h.diskSpace()
+
+	h.lock.Lock()
+	for id := range h.running {
+		hb.Jobs = append(hb.Jobs, id)
+	}
+	h.lock.Unlock()
+
+	sort.Ints(hb.Jobs)
+	return hb
+}
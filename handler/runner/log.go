@@ -0,0 +1,262 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/leslie-wang/clusterd/common/util"
+	"github.com/leslie-wang/clusterd/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	logUploadInterval  = 5 * time.Second
+	logUploadChunkSize = 1 << 20
+)
+
+// shipLogs uploads new content of the job's log files to manager periodically, so the logs
+// are kept by manager after the job is finished. files is <stream, log filename>. The returned
+// channel is closed after the last upload which happens once ctx is done.
+//
+// Log files are appended by every attempt of the job, which may run on other runners before. So
+// only content written since now is uploaded, and it is placed after what manager already has.
+func (h *Handler) shipLogs(ctx context.Context, id int, files map[string]string) <-chan struct{} {
+	logs := map[string]*shippingLog{}
+	for stream, fname := range files {
+		l := &shippingLog{fname: fname}
+		if stat, err := os.Stat(fname); err == nil {
+			l.offset = stat.Size()
+		}
+		logs[stream] = l
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for {
+			select {
+			case <-time.After(logUploadInterval):
+				h.uploadLogs(id, logs)
+			case <-ctx.Done():
+				h.uploadLogs(id, logs)
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// shippingLog is a log file being uploaded to manager
+type shippingLog struct {
+	fname  string
+	offset int64 // offset of local file to upload next
+	base   int64 // offset on manager minus offset of local file
+	based  bool  // base is got from manager
+}
+
+func (h *Handler) uploadLogs(id int, logs map[string]*shippingLog) {
+	buf := make([]byte, logUploadChunkSize)
+	for stream, l := range logs {
+		err := h.uploadLog(id, stream, l, buf)
+		if err != nil {
+			h.logger.Warnf("upload job %d %s log %s: %s", id, stream, l.fname, err)
+		}
+	}
+}
+
+func (h *Handler) uploadLog(id int, stream string, l *shippingLog, buf []byte) error {
+	if !l.based {
+		size, err := h.cli.JobLogSize(id, stream)
+		if err != nil {
+			return err
+		}
+		l.base, l.based = size-l.offset, true
+	}
+
+	f, err := os.Open(l.fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for {
+		n, err := f.ReadAt(buf, l.offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+
+		uerr := h.cli.UploadJobLog(id, stream, l.base+l.offset, buf[:n])
+		if uerr != nil {
+			return uerr
+		}
+		l.offset += int64(n)
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// jobLogFilename returns the log file of given stream which ffmpeg writes for the job
+func (h *Handler) jobLogFilename(id int, stream string) string {
+	if stream == types.LogStreamStdout {
+		return filepath.Join(h.c.LogDir, fmt.Sprintf(logStdoutFilename, id))
+	}
+	return filepath.Join(h.c.LogDir, fmt.Sprintf(logStderrFilename, id))
+}
+
+// openJobLog opens the log file for ffmpeg to write. Logs of previous attempts are kept, like they are on
+// manager.
+func openJobLog(fname string) (*os.File, error) {
+	return os.OpenFile(fname, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// jobLog serves the job log of given stream, which is stderr by default. It supports
+//   - "offset" to start reading at given byte offset
+//   - "follow" to keep streaming new content in chunks until the job is finished
+//   - Range header to read byte ranges, if not following
+func (h *Handler) jobLog(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)[types.ID])
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	stream := q.Get(types.LogStream)
+	switch stream {
+	case "":
+		stream = types.LogStreamStderr
+	case types.LogStreamStdout, types.LogStreamStderr:
+	default:
+		util.WriteError(w, errors.Errorf("invalid log stream %s", stream))
+		return
+	}
+
+	var offset int64
+	if val := q.Get(types.LogOffset); val != "" {
+		offset, err = strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			util.WriteError(w, err)
+			return
+		}
+	}
+
+	follow := false
+	if val := q.Get(types.LogFollow); val != "" {
+		follow, err = strconv.ParseBool(val)
+		if err != nil {
+			util.WriteError(w, err)
+			return
+		}
+	}
+
+	fname := h.jobLogFilename(id, stream)
+	f, err := os.Open(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			util.WriteError(w, util.ErrNotExist)
+		} else {
+			util.WriteError(w, err)
+		}
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !follow && offset == 0 {
+		stat, err := f.Stat()
+		if err != nil {
+			util.WriteError(w, err)
+			return
+		}
+		// handles Range and If-Range
+		http.ServeContent(w, r, filepath.Base(fname), stat.ModTime(), f)
+		return
+	}
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		util.WriteError(w, err)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	for {
+		_, err = io.Copy(w, f)
+		if err != nil {
+			h.logger.Warnf("serve job %d %s log: %s", id, stream, err)
+			return
+		}
+		if !follow {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if !h.isRunning(id) {
+			// drain the content written before job exits
+			_, _ = io.Copy(w, f)
+			return
+		}
+
+		// still writing logs, so wait and retry
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// cleanupLogLoop removes logs of finished jobs after retention
+func (h *Handler) cleanupLogLoop() {
+	if h.c.JobLogRetention <= 0 {
+		return
+	}
+
+	for {
+		h.cleanupLogs()
+		time.Sleep(time.Hour)
+	}
+}
+
+func (h *Handler) cleanupLogs() {
+	files, err := filepath.Glob(filepath.Join(h.c.LogDir, "record-*_*.log"))
+	if err != nil {
+		h.logger.Warnf("list job logs: %s", err)
+		return
+	}
+
+	before := time.Now().Add(-h.c.JobLogRetention)
+	for _, fname := range files {
+		var id int
+		_, err = fmt.Sscanf(filepath.Base(fname), "record-%d_", &id)
+		if err != nil || h.isRunning(id) {
+			continue
+		}
+
+		stat, err := os.Stat(fname)
+		if err != nil || stat.ModTime().After(before) {
+			continue
+		}
+
+		h.logger.Infof("remove job log %s", fname)
+		err = os.Remove(fname)
+		if err != nil {
+			h.logger.Warnf("remove job log %s: %s", fname, err)
+		}
+	}
+}
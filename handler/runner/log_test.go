@@ -0,0 +1,79 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/leslie-wang/clusterd/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLogManager keeps uploaded stderr log like manager does
+type fakeLogManager struct {
+	mutex   sync.Mutex
+	content []byte
+}
+
+func (m *fakeLogManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(m.content)))
+		return
+	}
+	offset, err := strconv.Atoi(r.URL.Query().Get(types.LogOffset))
+	if err != nil || offset > len(m.content) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	data, _ := io.ReadAll(r.Body)
+	m.content = append(m.content[:offset], data...)
+}
+
+func newLogTestHandler(t *testing.T, m *fakeLogManager) *Handler {
+	return newManagerTestHandler(t, m)
+}
+
+// runAttempt writes log like an attempt of the job, and waits it shipped
+func runAttempt(t *testing.T, h *Handler, fname, log string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	shipped := h.shipLogs(ctx, 1, map[string]string{types.LogStreamStderr: fname})
+
+	f, err := openJobLog(fname)
+	require.Nil(t, err)
+	_, err = f.WriteString(log)
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+
+	cancel()
+	<-shipped
+}
+
+func TestShipLogs(t *testing.T) {
+	m := &fakeLogManager{}
+	fname := filepath.Join(t.TempDir(), "record-1_stderr.log")
+
+	h := newLogTestHandler(t, m)
+	runAttempt(t, h, fname, "attempt 1\n")
+	assert.Equal(t, "attempt 1\n", string(m.content))
+
+	// resumed on the same runner
+	runAttempt(t, h, fname, "attempt 2\n")
+	assert.Equal(t, "attempt 1\nattempt 2\n", string(m.content))
+	local, err := os.ReadFile(fname)
+	require.Nil(t, err)
+	assert.Equal(t, "attempt 1\nattempt 2\n", string(local))
+
+	// resumed on another runner
+	fname = filepath.Join(t.TempDir(), "record-1_stderr.log")
+	runAttempt(t, newLogTestHandler(t, m), fname, "attempt 3\n")
+	assert.Equal(t, "attempt 1\nattempt 2\nattempt 3\n", string(m.content))
+}
@@ -7,7 +7,7 @@ import (
 func (h *Handler) reportLoop() {
 	for {
 		r := <-h.reportChan
-		err := h.cli.ReportJobStatus(&r)
+		err := h.report(&r)
 		if err != nil {
 			h.logger.Warnf("report %v: %s", r, err)
 		}
@@ -17,3 +17,9 @@ func (h *Handler) reportLoop() {
 func (h *Handler) addReport(r types.JobStatus) {
 	h.reportChan <- r
 }
+
+// report sends job status to manager on behalf of current runner
+func (h *Handler) report(status *types.JobStatus) error {
+	status.Runner = h.c.Name
+	return h.cli.ReportJobStatus(status)
+}
@@ -0,0 +1,114 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/hls"
+)
+
+const (
+	resumeFilename      = "resume-%d.m3u8"
+	resumeInitFilename  = "init_%d.mp4"
+	resumeMergeInterval = time.Second
+)
+
+// recordResume is state of continuing an interrupted recording. ffmpeg writes new segments into a
+// playlist of its own, which is appended to the segments recorded before.
+type recordResume struct {
+	base             []byte // media playlist recorded before
+	playlistFilename string // media playlist written by ffmpeg
+	indexFilename    string // media playlist merged from both
+}
+
+// prepareResume checks whether the recording in dir was interrupted before. If so, it returns the
+// resume state and extra ffmpeg arguments, so new segments keep numbering after the existing ones. Segments
+// are looked up by size, which finds the ones restored in the store only as well.
+func (h *Handler) prepareResume(dir, indexFilename string,
+	size func(name string) (int64, error)) (*recordResume, []string, error) {
+	base, err := os.ReadFile(indexFilename)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if hls.SegmentCount(base) == 0 {
+		return nil, nil, nil
+	}
+
+	next := hls.NextSegmentNumber(base)
+	// segments written after the last merge of a lost runner are not in the playlist, skip them
+	for {
+		_, err = size(fmt.Sprintf("%d.m4s", next))
+		if err != nil {
+			break
+		}
+		next++
+	}
+
+	attempt := hls.DiscontinuityCount(base) + 1
+	rr := &recordResume{
+		base:             base,
+		playlistFilename: filepath.Join(dir, fmt.Sprintf(resumeFilename, attempt)),
+		indexFilename:    indexFilename,
+	}
+	// start from scratch in case it is left by a lost runner
+	err = os.Remove(rr.playlistFilename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	h.logger.Infof("resume recording in %s from segment %d", dir, next)
+	return rr, []string{"-start_number", strconv.Itoa(next),
+		"-hls_fmp4_init_filename", fmt.Sprintf(resumeInitFilename, attempt)}, nil
+}
+
+// merge writes the recorded segments and the new ones into index playlist
+func (rr *recordResume) merge() error {
+	content, err := os.ReadFile(rr.playlistFilename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if hls.SegmentCount(content) == 0 {
+		return nil
+	}
+
+	// playlist is served and chunked while recording, so replace it atomically
+	tmp := rr.indexFilename + ".tmp"
+	err = os.WriteFile(tmp, hls.AppendMediaPlaylist(rr.base, content), 0755)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, rr.indexFilename)
+}
+
+// mergeLoop merges playlists periodically until ctx is done. The returned channel is closed after
+// the last merge.
+func (h *Handler) mergeLoop(ctx context.Context, rr *recordResume) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-time.After(resumeMergeInterval):
+			case <-ctx.Done():
+				if err := rr.merge(); err != nil {
+					h.logger.Warnf("merge %s into %s: %s", rr.playlistFilename, rr.indexFilename, err)
+				}
+				return
+			}
+			if err := rr.merge(); err != nil {
+				h.logger.Warnf("merge %s into %s: %s", rr.playlistFilename, rr.indexFilename, err)
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,326 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leslie-wang/clusterd/common/hls"
+	"github.com/leslie-wang/clusterd/common/storage"
+)
+
+const (
+	// mediaUploadInterval is how often new and changed media files are uploaded to the store
+	mediaUploadInterval = 2 * time.Second
+	// mediaUploadTimeout is the longest time to upload one media file
+	mediaUploadTimeout = 5 * time.Minute
+)
+
+// mediaUploader uploads files in the job directory to the store, when they are created or changed. It does
+// nothing if the runner has no store, i.e. media dir is shared with the manager.
+type mediaUploader struct {
+	h   *Handler
+	id  int
+	dir string
+
+	lock     sync.Mutex
+	uploaded map[string]fileVersion // <path under dir, version uploaded>
+	remote   map[string]int64       // <path under dir, size> of restored files which are in the store only
+}
+
+// fileVersion tells whether the file is changed since uploaded
+type fileVersion struct {
+	size    int64
+	modTime time.Time
+}
+
+func (h *Handler) newMediaUploader(id int, dir string) *mediaUploader {
+	if h.store == nil {
+		return nil
+	}
+	return &mediaUploader{h: h, id: id, dir: dir, uploaded: map[string]fileVersion{},
+		remote: map[string]int64{}}
+}
+
+// restore downloads playlists of the job which are not in the directory, e.g. the recording is resumed by
+// another runner, so that it continues into the existing playlist. Segments are left in the store, they are
+// only probed for their sizes.
+func (u *mediaUploader) restore(ctx context.Context) error {
+	if u == nil {
+		return nil
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	restored, err := u.restorePlaylist(ctx, recordFilename)
+	if err != nil || !restored {
+		return err
+	}
+	// mp4 playlists are numbered from 1, and the last one is where the next mp4 file starts
+	n := 1
+	for {
+		restored, err = u.restorePlaylist(ctx, fmt.Sprintf("dl%d.m3u8", n))
+		if err != nil {
+			return err
+		}
+		if !restored {
+			break
+		}
+		n++
+	}
+
+	content, err := os.ReadFile(filepath.Join(u.dir, recordFilename))
+	if err != nil {
+		return err
+	}
+	segments := hls.MediaSegments(content)
+	names := hls.InitURIs(segments)
+	if len(names) == 0 {
+		names = []string{"init.mp4"}
+	}
+	for _, seg := range segments {
+		names = append(names, seg.URI)
+	}
+	for _, name := range names {
+		_, err = u.probe(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+	// segments uploaded after the last playlist by a lost runner, so that they are not overwritten
+	for next := hls.NextSegmentNumber(content); ; next++ {
+		found, err := u.probe(ctx, fmt.Sprintf("%d.m4s", next))
+		if err != nil {
+			return err
+		}
+		if !found {
+			break
+		}
+	}
+	u.h.logger.Infof("restored %d playlists of job %d, %d files are kept in store", n, u.id, len(u.remote))
+	return nil
+}
+
+// restorePlaylist downloads the playlist if it isn't in the directory. It returns false if the playlist is
+// in neither of them.
+func (u *mediaUploader) restorePlaylist(ctx context.Context, name string) (bool, error) {
+	fname := filepath.Join(u.dir, filepath.FromSlash(name))
+	if _, err := os.Stat(fname); err == nil {
+		return true, nil
+	}
+	err := u.download(ctx, storage.MediaKey(u.id, name), fname)
+	if errors.Is(err, storage.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	stat, err := os.Stat(fname)
+	if err != nil {
+		return false, err
+	}
+	u.uploaded[name] = fileVersion{size: stat.Size(), modTime: stat.ModTime()}
+	return true, nil
+}
+
+// probe saves size of the file which is in the store but not in the directory. It returns false if the file
+// is in neither of them.
+func (u *mediaUploader) probe(ctx context.Context, name string) (bool, error) {
+	if _, err := os.Stat(filepath.Join(u.dir, filepath.FromSlash(name))); err == nil {
+		return true, nil
+	}
+	info, err := u.h.store.Stat(ctx, storage.MediaKey(u.id, name))
+	if errors.Is(err, storage.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	u.remote[name] = info.Size
+	return true, nil
+}
+
+// fileSize returns function which sizes files in dir, or the ones restored in the store only
+func (u *mediaUploader) fileSize(dir string) func(name string) (int64, error) {
+	local := hls.DirFileSize(dir)
+	return func(name string) (int64, error) {
+		size, err := local(name)
+		if err == nil || u == nil || !os.IsNotExist(err) {
+			return size, err
+		}
+		u.lock.Lock()
+		defer u.lock.Unlock()
+		if size, ok := u.remote[name]; ok {
+			return size, nil
+		}
+		return 0, err
+	}
+}
+
+func (u *mediaUploader) download(ctx context.Context, key, fname string) error {
+	obj, err := u.h.store.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	err = os.MkdirAll(filepath.Dir(fname), 0777)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	_, err = f.ReadFrom(obj)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loop keeps uploading until ctx is done, then uploads the last time
+func (u *mediaUploader) loop(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if u == nil {
+			return
+		}
+		for {
+			select {
+			case <-time.After(mediaUploadInterval):
+				u.sync()
+			case <-ctx.Done():
+				u.sync()
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// sync uploads files which are created or changed since last upload. Playlists are uploaded after media
+// segments, so they never refer to a segment which isn't in the store yet.
+func (u *mediaUploader) sync() {
+	if u == nil {
+		return
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	var names []string
+	versions := map[string]fileVersion{}
+	err := filepath.WalkDir(u.dir, func(fname string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// skip temporary files, e.g. ffmpeg writes playlist into .tmp then renames it
+		if d.IsDir() || strings.HasSuffix(d.Name(), ".tmp") || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		// links are uploaded as the file they point to, e.g. timestamp named mp4 playlist
+		stat, err := os.Stat(fname)
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(u.dir, fname)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		v := fileVersion{size: stat.Size(), modTime: stat.ModTime()}
+		if u.uploaded[name] != v {
+			names = append(names, name)
+			versions[name] = v
+		}
+		return nil
+	})
+	if err != nil {
+		u.h.logger.Warnf("list media files of job %d: %s", u.id, err)
+	}
+
+	sort.SliceStable(names, func(i, j int) bool {
+		return !isPlaylist(names[i]) && isPlaylist(names[j])
+	})
+	failed := false
+	for _, name := range names {
+		if failed && isPlaylist(name) {
+			// it may refer to the segment which failed, so it is uploaded by next round
+			u.h.logger.Warnf("skip uploading %s of job %d, some segments are not uploaded", name, u.id)
+			continue
+		}
+		err = u.upload(name)
+		if err != nil {
+			u.h.logger.Warnf("upload %s of job %d: %s", name, u.id, err)
+			failed = true
+			continue
+		}
+		u.uploaded[name] = versions[name]
+	}
+}
+
+// cleanup removes the job directory after everything in it is uploaded, so that finished recordings don't
+// take space of the runner
+func (u *mediaUploader) cleanup() {
+	if u == nil {
+		return
+	}
+	u.sync()
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	pending := false
+	filepath.WalkDir(u.dir, func(fname string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(d.Name(), ".tmp") || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(u.dir, fname)
+		if err != nil {
+			return nil
+		}
+		if _, ok := u.uploaded[filepath.ToSlash(rel)]; !ok {
+			pending = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if pending {
+		u.h.logger.Warnf("keep media files of job %d, some of them are not uploaded", u.id)
+		return
+	}
+	err := os.RemoveAll(u.dir)
+	if err != nil {
+		u.h.logger.Warnf("remove uploaded media files of job %d: %s", u.id, err)
+	}
+}
+
+func (u *mediaUploader) upload(name string) error {
+	f, err := os.Open(filepath.Join(u.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// size is taken again, the file may grow after it is listed
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mediaUploadTimeout)
+	defer cancel()
+	return u.h.store.Put(ctx, storage.MediaKey(u.id, name), io.LimitReader(f, stat.Size()), stat.Size())
+}
+
+func isPlaylist(name string) bool {
+	return filepath.Ext(name) == ".m3u8"
+}
@@ -0,0 +1,122 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/leslie-wang/clusterd/common/hls"
+	"github.com/leslie-wang/clusterd/common/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPlaylist = `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:6
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:EVENT
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:6.000000,
+0.m4s
+#EXTINF:6.000000,
+1.m4s
+`
+
+// testStore records objects opened, and fails to put objects under the failing key
+type testStore struct {
+	storage.Store
+	opened  []string
+	failing string
+}
+
+func (s *testStore) Open(ctx context.Context, key string) (storage.Object, error) {
+	obj, err := s.Store.Open(ctx, key)
+	if err == nil {
+		s.opened = append(s.opened, key)
+	}
+	return obj, err
+}
+
+func (s *testStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if s.failing != "" && key == s.failing {
+		return errors.New("put failed")
+	}
+	return s.Store.Put(ctx, key, r, size)
+}
+
+func newStorageTestHandler(t *testing.T) (*Handler, *testStore) {
+	h := newTestHandler(t, Config{})
+	store := &testStore{Store: storage.NewLocal(t.TempDir())}
+	h.store = store
+	return h, store
+}
+
+// only playlists are downloaded, segments are sized from the store
+func TestRestore(t *testing.T) {
+	h, store := newStorageTestHandler(t)
+	ctx := context.Background()
+	files := map[string]string{
+		recordFilename: testPlaylist,
+		"dl1.m3u8":     testPlaylist,
+		"init.mp4":     "init",
+		"0.m4s":        "segment0",
+		"1.m4s":        "segment1",
+		"2.m4s":        "segment2", // written after the last playlist
+	}
+	for name, content := range files {
+		require.Nil(t, store.Put(ctx, storage.MediaKey(1, name), strings.NewReader(content), int64(len(content))))
+	}
+
+	dir := t.TempDir()
+	u := h.newMediaUploader(1, dir)
+	require.Nil(t, u.restore(ctx))
+	assert.ElementsMatch(t, []string{storage.MediaKey(1, recordFilename), storage.MediaKey(1, "dl1.m3u8")},
+		store.opened)
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+
+	size := u.fileSize(dir)
+	assert.Equal(t, uint64(4+8+8), hls.CalculateFileSize([]byte(testPlaylist), size, h.logger))
+	_, args, err := h.prepareResume(dir, filepath.Join(dir, recordFilename), size)
+	require.Nil(t, err)
+	assert.Equal(t, "3", args[1])
+
+	// playlists in the directory are kept
+	store.opened = nil
+	require.Nil(t, u.restore(ctx))
+	assert.Empty(t, store.opened)
+}
+
+// playlist isn't uploaded until every segment it may refer to is
+func TestSyncSkipsPlaylist(t *testing.T) {
+	h, store := newStorageTestHandler(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		recordFilename: testPlaylist, "init.mp4": "init", "0.m4s": "segment0", "1.m4s": "segment1",
+	} {
+		require.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	stored := func(name string) bool {
+		_, err := store.Stat(ctx, storage.MediaKey(1, name))
+		return err == nil
+	}
+
+	u := h.newMediaUploader(1, dir)
+	store.failing = storage.MediaKey(1, "1.m4s")
+	u.sync()
+	assert.True(t, stored("0.m4s"))
+	assert.False(t, stored("1.m4s"))
+	assert.False(t, stored(recordFilename))
+
+	store.failing = ""
+	u.sync()
+	assert.True(t, stored("1.m4s"))
+	assert.True(t, stored(recordFilename))
+}
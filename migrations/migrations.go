@@ -0,0 +1,8 @@
+// Package migrations embeds the schema migrations of all supported DB drivers. Migrations of each
+// driver are kept in the directory named after the driver, as <version>.sql.
+package migrations
+
+import "embed"
+
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var FS embed.FS
@@ -1,17 +1,13 @@
-CREATE DATABASE IF NOT EXISTS clusterd;
-
-USE clusterd;
-
 CREATE TABLE IF NOT EXISTS jobs (
     id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
     ref_id INTEGER NOT NULL,
     category INTEGER NOT NULL,
-    metadata VARCHAR NOT NULL,
+    metadata TEXT NOT NULL,
     runner VARCHAR(255),
     create_time TIMESTAMP NOT NULL,
-    schedule_time TIMESTAMP NOT NULL,
-    start_time TIMESTAMP,
-    last_seen_time TIMESTAMP
+    schedule_time TIMESTAMP NULL,
+    start_time TIMESTAMP NULL,
+    last_seen_time TIMESTAMP NULL
 );
 
 CREATE TABLE IF NOT EXISTS job_archives (
@@ -20,30 +16,30 @@ CREATE TABLE IF NOT EXISTS job_archives (
     runner VARCHAR(255),
     exit_code INT,
     category INTEGER NOT NULL,
-    metadata VARCHAR NOT NULL,
+    metadata TEXT NOT NULL,
     create_time TIMESTAMP NOT NULL,
-    start_time TIMESTAMP,
+    start_time TIMESTAMP NULL,
     end_time TIMESTAMP NOT NULL
 );
 
 CREATE TABLE IF NOT EXISTS record_templates (
-    id INT NOT NULL PRIMARY KEY,
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
     name VARCHAR(255) NOT NULL,
     params VARCHAR(4096) NOT NULL,
     create_time TIMESTAMP NOT NULL
 );
 
 CREATE TABLE IF NOT EXISTS record_rules (
-    id INT NOT NULL PRIMARY KEY,
-    template_id INT NOT NULL,
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    template_id INT,
     domain_name VARCHAR(1024) NOT NULL,
-    app_name VARCHAR(1024) NOT NULL,
-    stream_name VARCHAR(1024) NOT NULL,
+    app_name VARCHAR(1024),
+    stream_name VARCHAR(1024),
     create_time TIMESTAMP NOT NULL
 );
 
 CREATE TABLE IF NOT EXISTS record_tasks (
-    id INT NOT NULL PRIMARY KEY,
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
     template_id INT,
     domain_name VARCHAR(1024) NOT NULL,
     app_name VARCHAR(1024) NOT NULL,
@@ -52,31 +48,31 @@ CREATE TABLE IF NOT EXISTS record_tasks (
     source_url VARCHAR(1024),
     store_path VARCHAR(1024),
     create_time TIMESTAMP NOT NULL,
-    start_time TIMESTAMP,
+    start_time TIMESTAMP NULL,
     end_time TIMESTAMP NOT NULL
 );
 
-CREATE TABLE IF NOT EXISTS record_templates (
-    id INT NOT NULL PRIMARY KEY,
+CREATE TABLE IF NOT EXISTS record_cb_templates (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
     name VARCHAR(255) NOT NULL,
-    description VARCHAR(4096),
+    description TEXT,
     callback_key VARCHAR(4096),
-    begin_url VARCHAR(4096),
-    end_url VARCHAR(4096),
-    record_url VARCHAR(4096),
-    record_status_url VARCHAR(4096),
-    porn_censorship_url VARCHAR(4096),
-    stream_mix_url VARCHAR(4096),
-    push_exception_url VARCHAR(4096),
-    audio_audit_url VARCHAR(4096),
-    snapshot_url VARCHAR(4096),
+    begin_url TEXT,
+    end_url TEXT,
+    record_url TEXT,
+    record_status_url TEXT,
+    porn_censorship_url TEXT,
+    stream_mix_url TEXT,
+    push_exception_url TEXT,
+    audio_audit_url TEXT,
+    snapshot_url TEXT,
     create_time TIMESTAMP NOT NULL
 );
 
-CREATE TABLE IF NOT EXISTS record_rules (
-    id INT NOT NULL PRIMARY KEY,
-    template_id INT NOT NULL,
+CREATE TABLE IF NOT EXISTS record_cb_rules (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    template_id INT,
     domain_name VARCHAR(1024) NOT NULL,
-    app_name VARCHAR(1024) NOT NULL,
+    app_name VARCHAR(1024),
     create_time TIMESTAMP NOT NULL
-);
\ No newline at end of file
+);
@@ -0,0 +1 @@
+ALTER TABLE jobs ADD COLUMN stop_time TIMESTAMP NULL;
@@ -0,0 +1 @@
+ALTER TABLE jobs ADD COLUMN requeue_count INT NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE jobs ADD COLUMN priority INT NOT NULL DEFAULT 0;
+ALTER TABLE jobs ADD COLUMN tenant VARCHAR(2048) NULL;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS job_attempts (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    job_id INT NOT NULL,
+    runner VARCHAR(255),
+    exit_code INT NOT NULL,
+    detail VARCHAR(4096),
+    start_time TIMESTAMP NULL,
+    end_time TIMESTAMP NOT NULL,
+    INDEX job_attempts_job_id (job_id)
+);
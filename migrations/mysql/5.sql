@@ -0,0 +1 @@
+ALTER TABLE jobs ADD COLUMN pause_time TIMESTAMP NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE record_templates ADD COLUMN update_time TIMESTAMP NULL;
+ALTER TABLE record_cb_templates ADD COLUMN update_time TIMESTAMP NULL;
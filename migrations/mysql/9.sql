@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS record_retentions (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    job_id INT NOT NULL,
+    format VARCHAR(32) NOT NULL,
+    expire_time TIMESTAMP NULL,
+    expired_time TIMESTAMP NULL,
+    create_time TIMESTAMP NOT NULL,
+    INDEX record_retentions_job_id (job_id),
+    INDEX record_retentions_expire_time (expired_time, expire_time)
+);
@@ -0,0 +1 @@
+ALTER TABLE record_tasks ADD COLUMN auto_record INTEGER NOT NULL DEFAULT 0;
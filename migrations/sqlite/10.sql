@@ -0,0 +1,2 @@
+ALTER TABLE record_retentions ADD COLUMN domain_name VARCHAR(1024);
+ALTER TABLE record_retentions ADD COLUMN size BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS job_attempts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    job_id INTEGER NOT NULL,
+    runner VARCHAR(255),
+    exit_code INT NOT NULL,
+    detail VARCHAR(4096),
+    start_time TIMESTAMP,
+    end_time TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS job_attempts_job_id ON job_attempts (job_id);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS callbacks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_id VARCHAR(255) NOT NULL,
+    stream_name VARCHAR(1024),
+    url VARCHAR(4096) NOT NULL,
+    sign_key VARCHAR(4096),
+    sign_method VARCHAR(32),
+    event_type INT NOT NULL,
+    content TEXT NOT NULL,
+    status INT NOT NULL DEFAULT 0,
+    attempts INT NOT NULL DEFAULT 0,
+    result_code INT,
+    response VARCHAR(4096),
+    next_time TIMESTAMP NOT NULL,
+    create_time TIMESTAMP NOT NULL,
+    update_time TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS callbacks_status_session_id ON callbacks (status, session_id);
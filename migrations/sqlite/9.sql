@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS record_retentions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    job_id INTEGER NOT NULL,
+    format VARCHAR(32) NOT NULL,
+    expire_time TIMESTAMP,
+    expired_time TIMESTAMP,
+    create_time TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS record_retentions_job_id ON record_retentions (job_id);
+CREATE INDEX IF NOT EXISTS record_retentions_expire_time ON record_retentions (expired_time, expire_time);
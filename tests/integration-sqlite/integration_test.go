@@ -1,7 +1,6 @@
 package integration_sqlite
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -20,9 +19,8 @@ import (
 )
 
 var (
-	dbscheduleDir = filepath.Join("..", "..", "migrations", "sqlite")
-	mediaDir      = filepath.Join("..", "test-media", "apple", "basic-stream-osx-ios4-3")
-	mediaFile     = "gear1_mix.mp4"
+	mediaDir  = filepath.Join("..", "test-media", "apple", "basic-stream-osx-ios4-3")
+	mediaFile = "gear1_mix.mp4"
 )
 
 type IntegrationTestSuite struct {
@@ -48,17 +46,9 @@ func (suite *IntegrationTestSuite) SetupSuite() {
 
 	fmt.Printf("---- testing sqlite db: %s\n", suite.sqliteDBFile)
 
-	cmd := exec.Command("sqlite3", suite.sqliteDBFile)
-	buf := &bytes.Buffer{}
-	for _, n := range []string{"0.sql"} {
-		schema, err := os.ReadFile(filepath.Join(dbscheduleDir, n))
-		suite.Require().NoError(err)
-		_, err = buf.Write(schema)
-		suite.Require().NoError(err)
-	}
-	cmd.Stdin = buf
-
-	content, err := cmd.CombinedOutput()
+	// schema is migrated by cd-manager at startup
+	content, err := exec.CommandContext(suite.globalCtx, "cd-manager", "--db-host", db.Sqlite+"://"+suite.sqliteDBFile,
+		"migrate").CombinedOutput()
 	suite.Require().NoError(err, string(content))
 
 	go func() {
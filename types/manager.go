@@ -18,17 +18,46 @@ const (
 	ID = "id"
 )
 
+// job log query keys and values
+const (
+	LogStream = "stream"
+	LogOffset = "offset"
+	LogTail   = "tail"
+	LogFollow = "follow"
+
+	LogStreamStdout = "stdout"
+	LogStreamStderr = "stderr"
+)
+
+// RetentionLimit is query key of how many retentions are listed
+const RetentionLimit = "limit"
+
+// download query keys and values
+const (
+	DownloadMode  = "mode"
+	DownloadStart = "start" // seconds since the beginning of the recording
+	DownloadEnd   = "end"
+
+	// DownloadModeFragmented concatenates the init segment and fragments as they are, which is the default
+	DownloadModeFragmented = "fragmented"
+	// DownloadModeProgressive remuxes fragments into mp4 with moov in front, which can be trimmed by time
+	DownloadModeProgressive = "progressive"
+)
+
 const (
 	BaseURL         = "/mediaproc/v1"
 	URLRecord       = BaseURL + "/record"
 	URLPlay         = BaseURL + "/play"
 	URLDownload     = BaseURL + "/dl"
+	URLStream       = BaseURL + "/stream"
 	URLRunner       = "/cd/v1/runner"
 	URLRunnerLogJob = URLRunner + "/log/job/"
+	URLRetention    = "/cd/v1/retention"
 
-	URLJob       = "/cd/v1/job"
-	URLJobRunner = URLJob + "/runner/"
-	URLJobLog    = URLJob + "/log/"
+	URLJob          = "/cd/v1/job"
+	URLJobRunner    = URLJob + "/runner/"
+	URLJobLog       = URLJob + "/log/"
+	URLJobHeartbeat = URLJob + "/heartbeat/"
 )
 
 func MkIDURLByBase(base string) string {
@@ -45,17 +74,62 @@ const (
 )
 
 type Job struct {
-	ID           int         `json:"id"`
-	RefID        int64       `json:"ref_id"`
-	Category     JobCategory `json:"category"`
-	Metadata     string      `json:"metadata"`
-	RunningHost  *string     `json:"run_on,omitempty"`
-	ExitCode     *int        `json:"exit_code,omitempty"`
-	CreateTime   time.Time   `json:"create_time"`
-	ScheduleTime *time.Time  `json:"schedule_time"`
-	StartTime    *time.Time  `json:"start_time,omitempty"`
-	EndTime      *time.Time  `json:"end_time,omitempty"`
-	LastSeenTime *time.Time  `json:"last_seen_time,omitempty"`
+	ID           int          `json:"id"`
+	RefID        int64        `json:"ref_id"`
+	Category     JobCategory  `json:"category"`
+	Metadata     string       `json:"metadata"`
+	Priority     int          `json:"priority,omitempty"` // higher priority job is scheduled first
+	Tenant       string       `json:"tenant,omitempty"`   // jobs are shared fairly among tenants
+	RunningHost  *string      `json:"run_on,omitempty"`
+	ExitCode     *int         `json:"exit_code,omitempty"`
+	CreateTime   time.Time    `json:"create_time"`
+	ScheduleTime *time.Time   `json:"schedule_time"`
+	StartTime    *time.Time   `json:"start_time,omitempty"`
+	EndTime      *time.Time   `json:"end_time,omitempty"`
+	StopTime     *time.Time   `json:"stop_time,omitempty"`
+	PauseTime    *time.Time   `json:"pause_time,omitempty"`
+	LastSeenTime *time.Time   `json:"last_seen_time,omitempty"`
+	RequeueCount int          `json:"requeue_count,omitempty"`
+	Attempts     []JobAttempt `json:"attempts,omitempty"`
+}
+
+// JobAttempt is one ended run of the job
+type JobAttempt struct {
+	Runner    string     `json:"runner"`
+	ExitCode  int        `json:"exit_code"`
+	Detail    string     `json:"detail,omitempty"`
+	StartTime *time.Time `json:"start_time,omitempty"`
+	EndTime   time.Time  `json:"end_time"`
+}
+
+// JobHeartbeat is sent by runner while acquiring jobs and periodically, to register itself
+// and renew the lease of its running jobs
+type JobHeartbeat struct {
+	Address  string `json:"address"`
+	Version  string `json:"version"`
+	Capacity int    `json:"capacity"`
+	Jobs     []int  `json:"jobs"`
+	// bytes free in the filesystem of media dir, and used by media dir
+	DiskFree uint64 `json:"disk_free,omitempty"`
+	DiskUsed uint64 `json:"disk_used,omitempty"`
+}
+
+// JobHeartbeatResponse is manager's answer to runner's heartbeat
+type JobHeartbeatResponse struct {
+	// Revoked is the jobs which are not owned by the runner anymore, and need be stopped
+	Revoked []int `json:"revoked"`
+}
+
+// Runner is one runner registered in manager
+type Runner struct {
+	Name         string    `json:"name"`
+	Address      string    `json:"address"`
+	Version      string    `json:"version"`
+	Capacity     int       `json:"capacity"`
+	Jobs         []int     `json:"jobs"`
+	DiskFree     uint64    `json:"disk_free,omitempty"`
+	DiskUsed     uint64    `json:"disk_used,omitempty"`
+	LastSeenTime time.Time `json:"last_seen_time"`
 }
 
 type JobRecord struct {
@@ -67,6 +141,36 @@ type JobRecord struct {
 	Mp4FileDuration    uint
 	HlsSegmentDuration uint
 	RecordTimeout      int64
+	Retry              *RetryPolicy
+	Outputs            []RecordOutput // formats to record, only HLS if it is empty
+	StorageTime        int64          // seconds to keep files of all formats given by task, overrides the template's
+	DomainName         string         // push domain, whose storage quota the recording takes
+	// settings given by the task rather than its template, which are kept when the template is modified
+	TaskMp4FileDuration bool
+	TaskRetry           bool
+}
+
+// record output formats
+const (
+	RecordFormatHLS = "hls"
+	RecordFormatMP4 = "mp4"
+	RecordFormatFLV = "flv"
+	RecordFormatAAC = "aac"
+	RecordFormatMP3 = "mp3"
+)
+
+// RecordOutput is one format which the recording is saved in, given by record template
+type RecordOutput struct {
+	Format      string
+	Interval    uint  // seconds of each file, 0 means one file for the whole recording
+	StorageTime int64 // seconds to keep the files, 0 means forever
+}
+
+// RetryPolicy decides whether the failed recording is retried
+type RetryPolicy struct {
+	MaxAttempts int           // max times to retry after failures
+	Backoff     time.Duration // delay before the first retry, doubled for each following one
+	Window      time.Duration // retry only within this duration since the first failure, 0 means no limit
 }
 
 type JobStatusType int
@@ -76,10 +180,13 @@ const (
 	RecordJobEnd
 	RecordJobException
 	RecordMp4FileCreated
+	RecordJobPaused
+	RecordJobResumed
 )
 
 type JobStatus struct {
 	ID          int           `json:"id"`
+	Runner      string        `json:"runner,omitempty"`
 	Type        JobStatusType `json:"type"`
 	ExitCode    int           `json:"exit_code"`
 	Stdout      string        `json:"stdout"`
@@ -87,8 +194,18 @@ type JobStatus struct {
 	Mp4Filename string        `json:"mp4_filename"`
 	Size        uint64        `json:"size"`
 	Duration    uint64        `json:"duration"`
+	// wall clock time in unix milliseconds of the beginning and the end of recorded media, 0 if unknown
+	MediaStartTime int64 `json:"media_start_time,omitempty"`
+	MediaEndTime   int64 `json:"media_end_time,omitempty"`
+	// N of dlN.m3u8 which the mp4 file is cut from, 0 for the whole recording
+	FileIndex int `json:"file_index,omitempty"`
+	// reason of the failure which isn't told by exit code of ffmpeg
+	Detail string `json:"detail,omitempty"`
 }
 
+// ExitCodeNoSpace is exit code of the recording which is stopped since media dir runs out of space
+const ExitCodeNoSpace = -2
+
 type LiveRecordRule struct {
 	*model.CreateLiveRecordRuleRequestParams
 	ID         int64     `json:"id"`
@@ -97,14 +214,16 @@ type LiveRecordRule struct {
 
 type LiveRecordTemplate struct {
 	*model.CreateLiveRecordTemplateRequestParams
-	ID         int64     `json:"id"`
-	CreateTime time.Time `json:"create_time"`
+	ID         int64      `json:"id"`
+	CreateTime time.Time  `json:"create_time"`
+	UpdateTime *time.Time `json:"update_time,omitempty"`
 }
 
 type LiveRecordTask struct {
 	*model.CreateRecordTaskRequestParams
 	ID         int64     `json:"id"`
 	CreateTime time.Time `json:"create_time"`
+	AutoRecord bool      `json:"-"` // created by record rule when the stream starts
 }
 
 // Config is configuration of DB
@@ -155,6 +274,12 @@ type LiveCallbackAbnormalDetail struct {
 	OccurTime string `json:"occur_time"`
 }
 
+// abnormal event types of recording, which are notified in stream exception event
+const (
+	LiveAbnormalRecordFailed = 1001 // recording process exits with error
+	LiveAbnormalRunnerLost   = 1002 // runner of the recording stops heartbeat
+)
+
 type LiveCallbackAbnormalEvent struct {
 	Type   int                          `json:"type"`
 	Count  int                          `json:"count"`
@@ -166,6 +291,9 @@ type LiveCallbackAbnormalEvent struct {
 type LiveCallbackStreamExceptionEvent struct {
 	EventType LiveCallbackEventType `json:"event_type"`
 
+	Sign string `json:"sign"`
+	T    int64  `json:"t"`
+
 	AppID          int                         `json:"appid"`
 	StreamID       string                      `json:"stream_id"`
 	DataTime       int                         `json:"data_time"`
@@ -211,6 +339,7 @@ const (
 	LiveRecordStatusError          = "record_error"
 	LiveRecordStatusEnded          = "record_ended"
 	LiveRecordMp4FileCreated       = "record_mp4_created"
+	LiveRecordFileExpired          = "record_file_expired"
 )
 
 type LiveCallbackRecordStatusEvent struct {
@@ -232,3 +361,56 @@ type LiveCallbackRecordStatusEvent struct {
 	Size         uint64                `json:"size"`
 	Duration     uint64                `json:"duration"`
 }
+
+type CallbackStatus int
+
+const (
+	CallbackPending CallbackStatus = iota
+	CallbackDelivered
+	CallbackFailed
+)
+
+// Callback is one event in the outbox, which is delivered to the url in order of its session
+type Callback struct {
+	ID         int64                 `json:"id"`
+	SessionID  string                `json:"session_id"`
+	StreamName string                `json:"stream_name,omitempty"`
+	URL        string                `json:"url"`
+	SignKey    string                `json:"-"`
+	SignMethod string                `json:"-"`
+	EventType  LiveCallbackEventType `json:"event_type"`
+	Content    string                `json:"content"` // json of the event without sign
+	Status     CallbackStatus        `json:"status"`
+	Attempts   int                   `json:"attempts"`
+	ResultCode *int                  `json:"result_code,omitempty"` // 0 if delivered, otherwise http status code or 1 for error
+	Response   string                `json:"response,omitempty"`
+	NextTime   time.Time             `json:"next_time"`
+	CreateTime time.Time             `json:"create_time"`
+	UpdateTime *time.Time            `json:"update_time,omitempty"`
+}
+
+// CallbackFilter selects callbacks to list
+type CallbackFilter struct {
+	StartTime  *time.Time
+	EndTime    *time.Time
+	SessionID  string
+	StreamName string
+	EventType  *LiveCallbackEventType
+	Status     *CallbackStatus
+	ResultCode *int
+	Offset     int
+	Limit      int
+}
+
+// RecordRetention is when files of one format of the recording expire. HLS and MP4 share segments, so both
+// are kept by HLS retention.
+type RecordRetention struct {
+	ID          int64      `json:"id"`
+	JobID       int        `json:"job_id"`
+	Format      string     `json:"format"`
+	ExpireTime  *time.Time `json:"expire_time,omitempty"`  // nil if the files are kept forever
+	ExpiredTime *time.Time `json:"expired_time,omitempty"` // when the files are removed
+	DomainName  string     `json:"domain_name,omitempty"`  // push domain, whose storage quota the files take
+	Size        int64      `json:"size,omitempty"`         // size of the files, counted in storage quota of the domain
+	Files       int        `json:"files,omitempty"`        // number of files to remove, only in dry run listing
+}